@@ -0,0 +1,152 @@
+package harness
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CertPath holds the fixed test certificates of the harness server, a
+// localhost certificate with an ed25519 key signed by ca.crt. the ca key is
+// not kept, the proxy trusts ca.crt when started with -ca.
+var CertPath = "./certs/harness/"
+
+// Addr is the address the harness server is served on, the proxy listener
+// routes the server name localhost to port 8081
+var Addr = "localhost:8081"
+
+// Config configures the harness server
+type Config struct {
+	// cipher suites in order of preference, TLS_AES_128_GCM_SHA256 by default
+	CipherSuites []uint16
+	// groups in order of preference, x25519 and p256 by default. the server
+	// sends a hello retry request if the client offers a group without a
+	// key share for it.
+	Groups []uint16
+	// maximum plaintext length of the records sent by the server, handshake
+	// messages and responses are fragmented at this size
+	RecordSize int
+	// coalesces the encrypted server handshake messages into shared
+	// records, otherwise each message starts a new record
+	CoalesceHandshake bool
+	// sends a key update, which requests an update of the client keys,
+	// after KeyUpdateAfter bytes of the response
+	KeyUpdate      bool
+	KeyUpdateAfter int
+	// http response sent to every request, DefaultResponse if nil
+	Response []byte
+	// certificate of the server, the fixed localhost certificate at
+	// CertPath if nil
+	Certificate *tls.Certificate
+	// source of the server random and key shares, crypto/rand by default
+	Rand io.Reader
+}
+
+func (c Config) cipherSuites() []uint16 {
+	if len(c.CipherSuites) == 0 {
+		return []uint16{TLS_AES_128_GCM_SHA256}
+	}
+	return c.CipherSuites
+}
+
+func (c Config) groups() []uint16 {
+	if len(c.Groups) == 0 {
+		return []uint16{GroupX25519, GroupP256}
+	}
+	return c.Groups
+}
+
+func (c Config) response() []byte {
+	if c.Response == nil {
+		return DefaultResponse
+	}
+	return c.Response
+}
+
+func (c Config) rand() io.Reader {
+	if c.Rand == nil {
+		return rand.Reader
+	}
+	return c.Rand
+}
+
+// DefaultResponse is a json response whose price the reference prover proves.
+// the price is at the start of the body so that it fits into one record of
+// 256 bytes, the padding spreads the body over several such records.
+var DefaultResponse = httpResponse(`{"price": 38500, "currency": "USD", "padding": "` + padding(600) + `"}`)
+
+func httpResponse(body string) []byte {
+	return []byte("HTTP/1.1 200 OK\r\n" +
+		"Content-Type: application/json\r\n" +
+		fmt.Sprintf("Content-Length: %d\r\n", len(body)) +
+		"Connection: close\r\n\r\n" +
+		body)
+}
+
+func padding(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = 'a' + byte(i%26)
+	}
+	return string(b)
+}
+
+// Certificate loads the fixed localhost certificate of the harness server
+func Certificate() (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(CertPath, "localhost.crt"), filepath.Join(CertPath, "localhost.key"))
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// Roots returns a pool holding only the harness ca certificate
+func Roots() (*x509.CertPool, error) {
+	ca, err := os.ReadFile(filepath.Join(CertPath, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate in %s", filepath.Join(CertPath, "ca.crt"))
+	}
+	return roots, nil
+}
+
+// deterministicRand is a sha256 counter mode stream
+type deterministicRand struct {
+	seed    [sha256.Size]byte
+	counter uint64
+	buf     []byte
+}
+
+// NewRand returns a deterministic source of randomness derived from seed.
+// server and client which draw from such sources exchange the same bytes in
+// every run, as ed25519 signatures are deterministic.
+func NewRand(seed string) io.Reader {
+	return &deterministicRand{seed: sha256.Sum256([]byte(seed))}
+}
+
+func (r *deterministicRand) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			block := make([]byte, sha256.Size+8)
+			copy(block, r.seed[:])
+			binary.BigEndian.PutUint64(block[sha256.Size:], r.counter)
+			r.counter++
+			sum := sha256.Sum256(block)
+			r.buf = sum[:]
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
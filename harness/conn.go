@@ -0,0 +1,96 @@
+package harness
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net"
+
+	"proxy/internal/tls13"
+)
+
+// tls 1.3 cipher suites
+const (
+	TLS_AES_128_GCM_SHA256       = tls13.TLS_AES_128_GCM_SHA256
+	TLS_AES_256_GCM_SHA384       = tls13.TLS_AES_256_GCM_SHA384
+	TLS_CHACHA20_POLY1305_SHA256 = tls13.TLS_CHACHA20_POLY1305_SHA256
+)
+
+// conn is the record layer of a server connection. written records are
+// buffered until flush, so that several records leave in one segment.
+type conn struct {
+	raw   net.Conn
+	read  *tls13.HalfConn
+	write *tls13.HalfConn
+	// maximum plaintext length of written records
+	recordSize int
+	out        []byte
+	// digest of all bytes sent to the client
+	transcript hash.Hash
+}
+
+func newConn(raw net.Conn, recordSize int) *conn {
+	if recordSize <= 0 || recordSize > tls13.MaxPlaintext {
+		recordSize = tls13.MaxPlaintext
+	}
+	return &conn{raw: raw, recordSize: recordSize, transcript: sha256.New()}
+}
+
+// readRecord reads the next record and removes its protection. change
+// cipher spec records of middlebox compatibility mode are skipped.
+func (c *conn) readRecord() (uint8, []byte, error) {
+
+	for {
+		r, err := tls13.ReadRecord(c.raw)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch {
+		case r.ContentType == tls13.RecordTypeChangeCipherSpec:
+			continue
+		case c.read == nil:
+			return r.ContentType, r.Payload, nil
+		case r.ContentType != tls13.RecordTypeApplicationData:
+			return 0, nil, fmt.Errorf("unprotected record of type %d", r.ContentType)
+		}
+		return c.read.Open(r.Header, r.Payload)
+	}
+}
+
+// writeRecords buffers data as records of the content type, fragmented at
+// the record size and protected if write keys are set
+func (c *conn) writeRecords(contentType uint8, data []byte) {
+
+	for len(data) > 0 {
+		n := len(data)
+		if n > c.recordSize {
+			n = c.recordSize
+		}
+
+		if c.write != nil {
+			c.out = append(c.out, c.write.Seal(contentType, data[:n])...)
+		} else {
+			header := []byte{contentType, 0, 0, 0, 0}
+			binary.BigEndian.PutUint16(header[1:3], tls13.VersionTLS12)
+			binary.BigEndian.PutUint16(header[3:5], uint16(n))
+			c.out = append(append(c.out, header...), data[:n]...)
+		}
+		data = data[n:]
+	}
+}
+
+// flush sends the buffered records
+func (c *conn) flush() error {
+	c.transcript.Write(c.out)
+	_, err := c.raw.Write(c.out)
+	c.out = c.out[:0]
+	return err
+}
+
+// sendAlert sends an alert and flushes pending records
+func (c *conn) sendAlert(level uint8, description uint8) error {
+	c.writeRecords(tls13.RecordTypeAlert, []byte{level, description})
+	return c.flush()
+}
@@ -0,0 +1,94 @@
+package harness
+
+import (
+	"proxy/internal/tls13"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// key exchange groups
+const (
+	GroupP256   = tls13.GroupP256
+	GroupX25519 = tls13.GroupX25519
+)
+
+// serverHello encodes a server hello, a hello retry request if random is
+// the retry value. share is the server key share, or empty to only name
+// the group of a retry.
+func serverHello(random []byte, sessionID []byte, suite uint16, group uint16, share []byte) []byte {
+
+	var b cryptobyte.Builder
+	b.AddUint16(tls13.VersionTLS12)
+	b.AddBytes(random)
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(sessionID)
+	})
+	b.AddUint16(suite)
+	b.AddUint8(0)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(tls13.ExtensionSupportedVersions)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(tls13.VersionTLS13)
+		})
+		b.AddUint16(tls13.ExtensionKeyShare)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(group)
+			if len(share) > 0 {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(share)
+				})
+			}
+		})
+	})
+
+	return tls13.HandshakeMessage(tls13.TypeServerHello, b.BytesOrPanic())
+}
+
+// encryptedExtensions confirms the application protocol, if any
+func encryptedExtensions(alpn string) []byte {
+
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		if alpn == "" {
+			return
+		}
+		b.AddUint16(tls13.ExtensionALPN)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes([]byte(alpn))
+				})
+			})
+		})
+	})
+
+	return tls13.HandshakeMessage(tls13.TypeEncryptedExtensions, b.BytesOrPanic())
+}
+
+// certificateMessage encodes the certificate chain without extensions
+func certificateMessage(chain [][]byte) []byte {
+
+	var b cryptobyte.Builder
+	b.AddUint8(0)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, cert := range chain {
+			b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes(cert)
+			})
+			b.AddUint16(0)
+		}
+	})
+
+	return tls13.HandshakeMessage(tls13.TypeCertificate, b.BytesOrPanic())
+}
+
+func certificateVerify(scheme uint16, signature []byte) []byte {
+
+	var b cryptobyte.Builder
+	b.AddUint16(scheme)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(signature)
+	})
+
+	return tls13.HandshakeMessage(tls13.TypeCertificateVerify, b.BytesOrPanic())
+}
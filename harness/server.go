@@ -0,0 +1,508 @@
+package harness
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"proxy/internal/tls13"
+
+	"github.com/rs/zerolog/log"
+)
+
+// signature schemes of the server certificate keys
+const (
+	ecdsaP256SHA256  uint16 = 0x0403
+	rsaPSSRSAESHA256 uint16 = 0x0804
+	schemeEd25519    uint16 = 0x0807
+)
+
+// context string of server certificate verify signatures, see RFC 8446 4.4.3
+const serverSignatureContext = "TLS 1.3, server CertificateVerify\x00"
+
+// how long a connection may take from client hello to close_notify
+var connTimeout = 10 * time.Second
+
+// ServerSession describes a connection served by the harness server
+type ServerSession struct {
+	CipherSuite uint16 `json:"cipher_suite"`
+	Group       uint16 `json:"group"`
+	// the server sent a hello retry request
+	Retried bool `json:"retried"`
+	// the client updated its keys after the key update of the server
+	KeyUpdated bool `json:"key_updated"`
+	// sha256 of all bytes sent by the server, equal across runs with
+	// deterministic randomness on both sides
+	Transcript string `json:"transcript"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Server is a tls 1.3 server which answers every http request with a fixed
+// response. connections are served one at a time, so that a deterministic
+// source of randomness yields the same sessions in every run.
+type Server struct {
+	config   Config
+	cert     *tls.Certificate
+	listener net.Listener
+
+	mu       sync.Mutex
+	sessions []ServerSession
+}
+
+// Listen starts the harness server on addr, usually localhost:8081 which the
+// proxy listener routes the server name localhost to
+func Listen(addr string, config Config) (*Server, error) {
+
+	cert := config.Certificate
+	if cert == nil {
+		var err error
+		cert, err = Certificate()
+		if err != nil {
+			log.Error().Err(err).Msg("Certificate()")
+			return nil, err
+		}
+	}
+	for _, id := range config.cipherSuites() {
+		if tls13.CipherSuiteByID(id) == nil {
+			return nil, fmt.Errorf("unsupported cipher suite %#04x", id)
+		}
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Error().Err(err).Msg("net.Listen()")
+		return nil, err
+	}
+
+	return &Server{config: config, cert: cert, listener: listener}, nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts and serves connections until the server is closed
+func (s *Server) Serve() error {
+
+	for {
+		raw, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("listener.Accept()")
+			return err
+		}
+
+		session := s.serve(raw)
+		if session.Error != "" {
+			log.Debug().Str("error", session.Error).Msg("harness connection failed")
+		}
+		s.mu.Lock()
+		s.sessions = append(s.sessions, session)
+		s.mu.Unlock()
+	}
+}
+
+// Close stops accepting connections
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// Sessions returns the connections served so far
+func (s *Server) Sessions() []ServerSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ServerSession(nil), s.sessions...)
+}
+
+// serverConn holds the state of one connection
+type serverConn struct {
+	config  Config
+	cert    *tls.Certificate
+	conn    *conn
+	hb      tls13.HandshakeBuffer
+	suite   *tls13.CipherSuite
+	session ServerSession
+}
+
+func (s *Server) serve(raw net.Conn) ServerSession {
+
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(connTimeout))
+
+	sc := &serverConn{config: s.config, cert: s.cert, conn: newConn(raw, s.config.RecordSize)}
+	err := sc.handshake()
+	if err == nil {
+		err = sc.respond()
+	}
+	if err != nil {
+		sc.session.Error = err.Error()
+	}
+	sc.session.Transcript = hex.EncodeToString(sc.conn.transcript.Sum(nil))
+
+	return sc.session
+}
+
+// fail sends a fatal alert and returns err
+func (sc *serverConn) fail(alert uint8, err error) error {
+	sc.conn.sendAlert(tls13.AlertLevelFatal, alert)
+	return err
+}
+
+// readHandshake returns the next handshake message, which must be of the
+// given type
+func (sc *serverConn) readHandshake(msgType uint8) ([]byte, error) {
+
+	for {
+		msg, ok := sc.hb.Next()
+		if ok {
+			if msg[0] != msgType {
+				return nil, sc.fail(tls13.AlertUnexpectedMessage, fmt.Errorf("unexpected handshake message %d, expected %d", msg[0], msgType))
+			}
+			return msg, nil
+		}
+
+		contentType, data, err := sc.conn.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		switch contentType {
+		case tls13.RecordTypeHandshake:
+			sc.hb.Write(data)
+		case tls13.RecordTypeAlert:
+			return nil, fmt.Errorf("client sent alert %x", data)
+		default:
+			return nil, sc.fail(tls13.AlertUnexpectedMessage, fmt.Errorf("unexpected record type %d during handshake", contentType))
+		}
+	}
+}
+
+func (sc *serverConn) readClientHello() (*tls13.ClientHello, error) {
+
+	msg, err := sc.readHandshake(tls13.TypeClientHello)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := tls13.ParseClientHello(msg)
+	if err != nil {
+		return nil, sc.fail(tls13.AlertIllegalParameter, err)
+	}
+	if !tls13.Contains(ch.SupportedVersions, tls13.VersionTLS13) {
+		return nil, sc.fail(tls13.AlertProtocolVersion, errors.New("client does not offer tls 1.3"))
+	}
+	return ch, nil
+}
+
+// selectGroup returns the first group of the server preference the client
+// sent a key share for, or else the first group the client supports, which
+// requires a hello retry request
+func (sc *serverConn) selectGroup(ch *tls13.ClientHello) (uint16, []byte) {
+
+	for _, group := range sc.config.groups() {
+		if share, ok := ch.KeyShares[group]; ok {
+			return group, share
+		}
+	}
+	for _, group := range sc.config.groups() {
+		if tls13.Contains(ch.Groups, group) {
+			return group, nil
+		}
+	}
+	return 0, nil
+}
+
+// signatureScheme returns the scheme of the certificate key
+func (sc *serverConn) signatureScheme() (uint16, error) {
+
+	switch key := sc.cert.PrivateKey.(type) {
+	case ed25519.PrivateKey:
+		return schemeEd25519, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve.Params().BitSize == 256 {
+			return ecdsaP256SHA256, nil
+		}
+	case *rsa.PrivateKey:
+		return rsaPSSRSAESHA256, nil
+	}
+	return 0, errors.New("unsupported certificate key")
+}
+
+// sign signs the certificate verify content with the certificate key
+func (sc *serverConn) sign(scheme uint16, transcriptHash []byte) ([]byte, error) {
+
+	signed := bytes.Repeat([]byte{0x20}, 64)
+	signed = append(signed, serverSignatureContext...)
+	signed = append(signed, transcriptHash...)
+
+	signer := sc.cert.PrivateKey.(crypto.Signer)
+	if scheme == schemeEd25519 {
+		return signer.Sign(nil, signed, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(signed)
+	var opts crypto.SignerOpts = crypto.SHA256
+	if scheme == rsaPSSRSAESHA256 {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	}
+	return signer.Sign(sc.config.rand(), digest[:], opts)
+}
+
+// handshake runs the server side of a tls 1.3 handshake without psk
+func (sc *serverConn) handshake() error {
+
+	ch, err := sc.readClientHello()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range sc.config.cipherSuites() {
+		if tls13.Contains(ch.CipherSuites, id) {
+			sc.suite = tls13.CipherSuiteByID(id)
+			break
+		}
+	}
+	if sc.suite == nil {
+		return sc.fail(tls13.AlertHandshakeFailure, errors.New("no common cipher suite"))
+	}
+	sc.session.CipherSuite = sc.suite.ID
+
+	scheme, err := sc.signatureScheme()
+	if err != nil {
+		return sc.fail(tls13.AlertHandshakeFailure, err)
+	}
+	if !tls13.Contains(ch.SignatureSchemes, scheme) {
+		return sc.fail(tls13.AlertHandshakeFailure, fmt.Errorf("client does not accept signature scheme %#04x", scheme))
+	}
+
+	group, clientShare := sc.selectGroup(ch)
+	if group == 0 {
+		return sc.fail(tls13.AlertHandshakeFailure, errors.New("no common group"))
+	}
+	sc.session.Group = group
+
+	transcript := sc.suite.Hash()
+	if clientShare == nil {
+		ch, clientShare, err = sc.helloRetryRequest(ch, group, transcript)
+		if err != nil {
+			return err
+		}
+	}
+	transcript.Write(ch.Raw)
+
+	// server hello and middlebox compatibility change cipher spec
+	share, err := tls13.NewKeyShare(group, sc.config.rand())
+	if err != nil {
+		return err
+	}
+	random := make([]byte, 32)
+	_, err = io.ReadFull(sc.config.rand(), random)
+	if err != nil {
+		return err
+	}
+	sh := serverHello(random, ch.SessionID, sc.suite.ID, group, share.Public)
+	transcript.Write(sh)
+	sc.conn.writeRecords(tls13.RecordTypeHandshake, sh)
+	if !sc.session.Retried && len(ch.SessionID) > 0 {
+		sc.conn.writeRecords(tls13.RecordTypeChangeCipherSpec, []byte{1})
+	}
+
+	shared, err := share.SharedSecret(clientShare)
+	if err != nil {
+		return sc.fail(tls13.AlertIllegalParameter, err)
+	}
+
+	// handshake secrets, see RFC 8446 7.1
+	suite := sc.suite
+	zeros := make([]byte, suite.Hash().Size())
+	emptyHash := suite.Hash().Sum(nil)
+	early := suite.Extract(zeros, zeros)
+	hs := suite.Extract(suite.DeriveSecret(early, "derived", emptyHash), shared)
+	h2 := transcript.Sum(nil)
+	chts := suite.DeriveSecret(hs, "c hs traffic", h2)
+	shts := suite.DeriveSecret(hs, "s hs traffic", h2)
+
+	sc.conn.write, err = tls13.NewHalfConn(suite, shts)
+	if err != nil {
+		return err
+	}
+
+	// encrypted server flight
+	alpn := ""
+	for _, proto := range ch.ALPN {
+		if proto == "http/1.1" {
+			alpn = proto
+		}
+	}
+	ee := encryptedExtensions(alpn)
+	transcript.Write(ee)
+	cert := certificateMessage(sc.cert.Certificate)
+	transcript.Write(cert)
+	signature, err := sc.sign(scheme, transcript.Sum(nil))
+	if err != nil {
+		return err
+	}
+	cv := certificateVerify(scheme, signature)
+	transcript.Write(cv)
+	fin := tls13.HandshakeMessage(tls13.TypeFinished, suite.FinishedMAC(shts, transcript.Sum(nil)))
+	transcript.Write(fin)
+
+	flight := [][]byte{ee, cert, cv, fin}
+	if sc.config.CoalesceHandshake {
+		sc.conn.writeRecords(tls13.RecordTypeHandshake, bytes.Join(flight, nil))
+	} else {
+		for _, msg := range flight {
+			sc.conn.writeRecords(tls13.RecordTypeHandshake, msg)
+		}
+	}
+	err = sc.conn.flush()
+	if err != nil {
+		return err
+	}
+
+	// application secrets
+	h3 := transcript.Sum(nil)
+	ms := suite.Extract(suite.DeriveSecret(hs, "derived", emptyHash), zeros)
+	cats := suite.DeriveSecret(ms, "c ap traffic", h3)
+	sats := suite.DeriveSecret(ms, "s ap traffic", h3)
+
+	// client finished
+	sc.conn.read, err = tls13.NewHalfConn(suite, chts)
+	if err != nil {
+		return err
+	}
+	msg, err := sc.readHandshake(tls13.TypeFinished)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(msg[tls13.HandshakeHeaderLen:], suite.FinishedMAC(chts, h3)) {
+		return sc.fail(tls13.AlertDecryptError, errors.New("invalid client finished"))
+	}
+	if !sc.hb.Empty() {
+		return sc.fail(tls13.AlertUnexpectedMessage, errors.New("trailing data after client finished"))
+	}
+
+	sc.conn.read, err = tls13.NewHalfConn(suite, cats)
+	if err != nil {
+		return err
+	}
+	sc.conn.write, err = tls13.NewHalfConn(suite, sats)
+	return err
+}
+
+// helloRetryRequest asks the client for a key share of group and returns the
+// second client hello. the first client hello is replaced by its hash in
+// the transcript, see RFC 8446 4.4.1.
+func (sc *serverConn) helloRetryRequest(ch *tls13.ClientHello, group uint16, transcript hash.Hash) (*tls13.ClientHello, []byte, error) {
+
+	h := sc.suite.Hash()
+	h.Write(ch.Raw)
+	transcript.Write(tls13.HandshakeMessage(tls13.TypeMessageHash, h.Sum(nil)))
+
+	hrr := serverHello(tls13.HelloRetryRequestRandom, ch.SessionID, sc.suite.ID, group, nil)
+	transcript.Write(hrr)
+	sc.conn.writeRecords(tls13.RecordTypeHandshake, hrr)
+	if len(ch.SessionID) > 0 {
+		sc.conn.writeRecords(tls13.RecordTypeChangeCipherSpec, []byte{1})
+	}
+	err := sc.conn.flush()
+	if err != nil {
+		return nil, nil, err
+	}
+	sc.session.Retried = true
+
+	retry, err := sc.readClientHello()
+	if err != nil {
+		return nil, nil, err
+	}
+	share, ok := retry.KeyShares[group]
+	if !ok || len(retry.KeyShares) != 1 {
+		return nil, nil, sc.fail(tls13.AlertIllegalParameter, errors.New("second client hello without the requested key share"))
+	}
+	if !tls13.Contains(retry.CipherSuites, sc.suite.ID) {
+		return nil, nil, sc.fail(tls13.AlertIllegalParameter, errors.New("second client hello without the selected cipher suite"))
+	}
+
+	return retry, share, nil
+}
+
+// respond reads the http request, sends the response, optionally with a key
+// update within, and closes the session with a close_notify alert
+func (sc *serverConn) respond() error {
+
+	var request []byte
+	for !bytes.Contains(request, []byte("\r\n\r\n")) {
+		contentType, data, err := sc.conn.readRecord()
+		if err != nil {
+			return err
+		}
+		switch contentType {
+		case tls13.RecordTypeApplicationData:
+			request = append(request, data...)
+		case tls13.RecordTypeAlert:
+			return fmt.Errorf("client sent alert %x before the request", data)
+		default:
+			return sc.fail(tls13.AlertUnexpectedMessage, fmt.Errorf("unexpected record type %d before the request", contentType))
+		}
+	}
+
+	response := sc.config.response()
+	split := len(response)
+	if sc.config.KeyUpdate && sc.config.KeyUpdateAfter < split {
+		split = sc.config.KeyUpdateAfter
+	}
+	sc.conn.writeRecords(tls13.RecordTypeApplicationData, response[:split])
+	if sc.config.KeyUpdate {
+		sc.conn.writeRecords(tls13.RecordTypeHandshake, tls13.HandshakeMessage(tls13.TypeKeyUpdate, []byte{1}))
+		next, err := sc.conn.write.Next()
+		if err != nil {
+			return err
+		}
+		sc.conn.write = next
+	}
+	sc.conn.writeRecords(tls13.RecordTypeApplicationData, response[split:])
+	err := sc.conn.sendAlert(tls13.AlertLevelWarning, tls13.AlertCloseNotify)
+	if err != nil {
+		return err
+	}
+
+	// wait for the client to close, answering its key update
+	for {
+		contentType, data, err := sc.conn.readRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch contentType {
+		case tls13.RecordTypeHandshake:
+			if len(data) != tls13.HandshakeHeaderLen+1 || data[0] != tls13.TypeKeyUpdate {
+				return fmt.Errorf("unexpected post handshake message %x", data)
+			}
+			next, err := sc.conn.read.Next()
+			if err != nil {
+				return err
+			}
+			sc.conn.read = next
+			sc.session.KeyUpdated = true
+		case tls13.RecordTypeAlert:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	h "proxy/harness"
+	pr "proxy/prover"
+)
+
+// outcomes of a scenario which passes all stages of prover.Run, or which
+// is only captured by the listener
+const (
+	outcomeVerified = "verified"
+	outcomeCaptured = "captured"
+)
+
+// scenario is a session driven through the proxy against the harness server
+type scenario struct {
+	name   string
+	server h.Config
+	// key shares of the first client hello of the reference prover
+	keyShares []uint16
+	// runs the session with a crypto/tls client, which offers every tls 1.3
+	// cipher suite, instead of the reference prover. such sessions are
+	// captured by the listener but not proven, and vary between runs.
+	standard bool
+	// outcomeVerified, outcomeCaptured or the stage of prover.Run the
+	// scenario is expected to fail at
+	expect string
+	// the harness server sends a hello retry request
+	retried bool
+}
+
+// scenarios cover the handshake and record layouts the listener, the parser
+// and the verifier have to handle
+var scenarios = []scenario{
+	{name: "baseline", expect: outcomeVerified},
+	{name: "hello-retry", server: h.Config{Groups: []uint16{h.GroupP256}}, expect: outcomeVerified, retried: true},
+	{name: "p256", server: h.Config{Groups: []uint16{h.GroupP256}}, keyShares: []uint16{h.GroupP256}, expect: outcomeVerified},
+	{name: "small-records", server: h.Config{RecordSize: 256}, expect: outcomeVerified},
+	{name: "coalesced", server: h.Config{CoalesceHandshake: true}, expect: outcomeVerified},
+	{name: "coalesced-small-records", server: h.Config{CoalesceHandshake: true, RecordSize: 256}, expect: outcomeVerified},
+	{name: "key-update", server: h.Config{RecordSize: 256, KeyUpdate: true, KeyUpdateAfter: 256}, expect: outcomeVerified},
+	// the oracle circuit derives the first server application keys only
+	{name: "key-update-before-response", server: h.Config{KeyUpdate: true}, expect: pr.StageInputs},
+	// the reference prover offers TLS_AES_128_GCM_SHA256 only
+	{name: "aes256-prover", server: h.Config{CipherSuites: []uint16{h.TLS_AES_256_GCM_SHA384}}, expect: pr.StageSession},
+	{name: "aes256", server: h.Config{CipherSuites: []uint16{h.TLS_AES_256_GCM_SHA384}}, standard: true, expect: outcomeCaptured},
+	{name: "chacha20", server: h.Config{CipherSuites: []uint16{h.TLS_CHACHA20_POLY1305_SHA256}}, standard: true, expect: outcomeCaptured},
+}
+
+// each scenario serves one session on the harness server and drives it
+// through the proxy. client and server draw from randomness seeded with the
+// scenario name, so that proven sessions repeat byte for byte.
+func TestHarnessScenarios(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("proves the oracle circuit")
+	}
+
+	// the listener routes localhost to a fixed port, the scenarios serve
+	// their harness servers on it one after another
+	harnessAddr := freeAddr(t)
+	_, port, err := net.SplitHostPort(harnessAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, server := startProxy(t, port)
+
+	roots, err := h.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sc := range scenarios {
+		t.Run(sc.name, func(t *testing.T) {
+
+			config := sc.server
+			config.Rand = h.NewRand(sc.name + "/server")
+			srv, stop := startHarness(t, harnessAddr, config)
+
+			var err error
+			if sc.standard {
+				err = standardSession(listener, server, roots)
+			} else {
+				_, err = pr.Run(pr.Options{
+					Listener:  listener,
+					Server:    server,
+					URL:       testURL,
+					Substring: testSubstring,
+					Backend:   backend,
+					Config: pr.Config{
+						Roots:     roots,
+						KeyShares: sc.keyShares,
+						Rand:      h.NewRand(sc.name + "/client"),
+					},
+				})
+			}
+
+			// wait for the server to finish the connection
+			stop()
+
+			var stageErr *pr.StageError
+			outcome := outcomeVerified
+			switch {
+			case err == nil && sc.standard:
+				outcome = outcomeCaptured
+			case errors.As(err, &stageErr):
+				outcome = stageErr.Stage
+			case err != nil:
+				outcome = err.Error()
+			}
+			if outcome != sc.expect {
+				t.Fatalf("outcome %s, want %s: %v", outcome, sc.expect, err)
+			}
+
+			sessions := srv.Sessions()
+			if len(sessions) != 1 {
+				t.Fatalf("harness server served %d sessions", len(sessions))
+			}
+			if sessions[0].Retried != sc.retried {
+				t.Errorf("hello retry request sent %t, want %t", sessions[0].Retried, sc.retried)
+			}
+			if sc.expect == outcomeVerified && sessions[0].Error != "" {
+				t.Errorf("harness server: %s", sessions[0].Error)
+			}
+		})
+	}
+}
+
+// standardSession requests the url with a crypto/tls client and waits for
+// the listener to capture the session
+func standardSession(listener string, server string, roots *x509.CertPool) error {
+
+	dialedAt := time.Now().UTC()
+	conn, err := tls.Dial("tcp", listener, &tls.Config{
+		ServerName: "localhost",
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS13,
+		NextProtos: []string{"http/1.1"},
+	})
+	if err != nil {
+		return &pr.StageError{Stage: pr.StageSession, Err: err}
+	}
+	_, err = conn.Write([]byte("GET /price HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	if err == nil {
+		_, err = io.ReadAll(conn)
+	}
+	conn.Close()
+	if err != nil {
+		return &pr.StageError{Stage: pr.StageSession, Err: err}
+	}
+
+	_, err = pr.CapturedSession("http://"+server, dialedAt)
+	if err != nil {
+		return &pr.StageError{Stage: pr.StageCapture, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package tls13
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// tls 1.3 handshake message types
+const (
+	HandshakeHeaderLen = 4
+
+	TypeClientHello         uint8 = 1
+	TypeServerHello         uint8 = 2
+	TypeNewSessionTicket    uint8 = 4
+	TypeEncryptedExtensions uint8 = 8
+	TypeCertificate         uint8 = 11
+	TypeCertificateRequest  uint8 = 13
+	TypeCertificateVerify   uint8 = 15
+	TypeFinished            uint8 = 20
+	TypeKeyUpdate           uint8 = 24
+	TypeMessageHash         uint8 = 254
+)
+
+// extensions of the handshake messages
+const (
+	ExtensionServerName          uint16 = 0
+	ExtensionSupportedGroups     uint16 = 10
+	ExtensionSignatureAlgorithms uint16 = 13
+	ExtensionALPN                uint16 = 16
+	ExtensionSupportedVersions   uint16 = 43
+	ExtensionCookie              uint16 = 44
+	ExtensionKeyShare            uint16 = 51
+)
+
+// ServerHello.random value which marks a HelloRetryRequest, see RFC 8446 4.1.3
+var HelloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11,
+	0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e,
+	0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// HandshakeBuffer reassembles handshake messages from record payloads.
+// a record may carry several coalesced messages and a message may be
+// fragmented across several records.
+type HandshakeBuffer struct {
+	buf []byte
+}
+
+func (hb *HandshakeBuffer) Write(p []byte) {
+	hb.buf = append(hb.buf, p...)
+}
+
+// Next returns the next complete message including its 4 byte header
+func (hb *HandshakeBuffer) Next() ([]byte, bool) {
+
+	if len(hb.buf) < HandshakeHeaderLen {
+		return nil, false
+	}
+	length := int(hb.buf[1])<<16 | int(hb.buf[2])<<8 | int(hb.buf[3])
+	if len(hb.buf) < HandshakeHeaderLen+length {
+		return nil, false
+	}
+
+	msg := hb.buf[: HandshakeHeaderLen+length : HandshakeHeaderLen+length]
+	hb.buf = hb.buf[HandshakeHeaderLen+length:]
+	return msg, true
+}
+
+// Empty reports whether no partial message is buffered
+func (hb *HandshakeBuffer) Empty() bool {
+	return len(hb.buf) == 0
+}
+
+// HandshakeMessage prepends the 4 byte header to a message body
+func HandshakeMessage(msgType uint8, body []byte) []byte {
+	n := len(body)
+	return append([]byte{msgType, byte(n >> 16), byte(n >> 8), byte(n)}, body...)
+}
+
+// MessageBody returns the body of a handshake message after checking that
+// type and length of the 4 byte header match the message
+func MessageBody(msg []byte, msgType uint8) (cryptobyte.String, error) {
+
+	if len(msg) < HandshakeHeaderLen {
+		return nil, errors.New("truncated handshake message")
+	}
+	if msg[0] != msgType {
+		return nil, fmt.Errorf("unexpected handshake message %d, expected %d", msg[0], msgType)
+	}
+	length := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	if length != len(msg)-HandshakeHeaderLen {
+		return nil, fmt.Errorf("handshake message %d length mismatch", msgType)
+	}
+
+	return cryptobyte.String(msg[HandshakeHeaderLen:]), nil
+}
+
+// IsHelloRetryRequest reports whether a raw server hello message is a
+// hello retry request
+func IsHelloRetryRequest(serverHello []byte) bool {
+
+	if len(serverHello) < HandshakeHeaderLen+2+32 {
+		return false
+	}
+	random := serverHello[HandshakeHeaderLen+2 : HandshakeHeaderLen+2+32]
+	return bytes.Equal(random, HelloRetryRequestRandom)
+}
+
+func Contains(list []uint16, v uint16) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
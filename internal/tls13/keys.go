@@ -0,0 +1,149 @@
+package tls13
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// tls 1.3 cipher suites
+const (
+	TLS_AES_128_GCM_SHA256       uint16 = 0x1301
+	TLS_AES_256_GCM_SHA384       uint16 = 0x1302
+	TLS_CHACHA20_POLY1305_SHA256 uint16 = 0x1303
+)
+
+// key exchange groups
+const (
+	GroupP256   uint16 = 23
+	GroupX25519 uint16 = 29
+)
+
+// CipherSuite holds the aead and hash of a tls 1.3 cipher suite
+type CipherSuite struct {
+	ID     uint16
+	KeyLen int
+	Hash   func() hash.Hash
+	AEAD   func(key []byte) (cipher.AEAD, error)
+}
+
+var cipherSuites = []*CipherSuite{
+	{TLS_AES_128_GCM_SHA256, 16, sha256.New, aeadAESGCM},
+	{TLS_AES_256_GCM_SHA384, 32, sha512.New384, aeadAESGCM},
+	{TLS_CHACHA20_POLY1305_SHA256, chacha20poly1305.KeySize, sha256.New, chacha20poly1305.New},
+}
+
+// CipherSuiteByID returns the cipher suite, or nil if it is not supported
+func CipherSuiteByID(id uint16) *CipherSuite {
+	for _, s := range cipherSuites {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func aeadAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// HKDFLabel encodes the info of HKDF-Expand-Label
+func HKDFLabel(label string, context []byte, length int) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(length))
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte("tls13 " + label))
+	})
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(context)
+	})
+	return b.BytesOrPanic()
+}
+
+// ExpandLabel implements HKDF-Expand-Label of RFC 8446 7.1
+func (cs *CipherSuite) ExpandLabel(secret []byte, label string, context []byte, length int) []byte {
+	out := make([]byte, length)
+	hkdf.Expand(cs.Hash, secret, HKDFLabel(label, context, length)).Read(out)
+	return out
+}
+
+func (cs *CipherSuite) Extract(salt []byte, secret []byte) []byte {
+	return hkdf.Extract(cs.Hash, secret, salt)
+}
+
+// DeriveSecret implements Derive-Secret of RFC 8446 7.1 over a transcript hash
+func (cs *CipherSuite) DeriveSecret(secret []byte, label string, transcriptHash []byte) []byte {
+	return cs.ExpandLabel(secret, label, transcriptHash, cs.Hash().Size())
+}
+
+func (cs *CipherSuite) FinishedMAC(trafficSecret []byte, transcriptHash []byte) []byte {
+	key := cs.ExpandLabel(trafficSecret, "finished", nil, cs.Hash().Size())
+	mac := hmac.New(cs.Hash, key)
+	mac.Write(transcriptHash)
+	return mac.Sum(nil)
+}
+
+// KeyShare is an ephemeral key of a key exchange group
+type KeyShare struct {
+	Group   uint16
+	Private []byte
+	Public  []byte
+}
+
+func NewKeyShare(group uint16, rand io.Reader) (*KeyShare, error) {
+
+	switch group {
+	case GroupX25519:
+		private := make([]byte, curve25519.ScalarSize)
+		_, err := io.ReadFull(rand, private)
+		if err != nil {
+			return nil, err
+		}
+		public, err := curve25519.X25519(private, curve25519.Basepoint)
+		if err != nil {
+			return nil, err
+		}
+		return &KeyShare{Group: group, Private: private, Public: public}, nil
+
+	case GroupP256:
+		private, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand)
+		if err != nil {
+			return nil, err
+		}
+		return &KeyShare{Group: group, Private: private, Public: elliptic.Marshal(elliptic.P256(), x, y)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported group %d", group)
+}
+
+// SharedSecret computes the ecdhe shared secret with the key share of the peer
+func (k *KeyShare) SharedSecret(peer []byte) ([]byte, error) {
+
+	if k.Group == GroupX25519 {
+		return curve25519.X25519(k.Private, peer)
+	}
+
+	x, y := elliptic.Unmarshal(elliptic.P256(), peer)
+	if x == nil {
+		return nil, errors.New("invalid p256 key share")
+	}
+	x, _ = elliptic.P256().ScalarMult(x, y, k.Private)
+	shared := make([]byte, 32)
+	return x.FillBytes(shared), nil
+}
@@ -0,0 +1,296 @@
+package tls13
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ClientHello holds the fields of a client hello and the decoded
+// extensions the proxy, the prover and the harness server act on
+type ClientHello struct {
+	Raw          []byte
+	Random       []byte
+	SessionID    []byte
+	CipherSuites []uint16
+	// all extensions by type
+	Extensions map[uint16][]byte
+
+	ServerName        string
+	Groups            []uint16
+	SignatureSchemes  []uint16
+	ALPN              []string
+	SupportedVersions []uint16
+	KeyShares         map[uint16][]byte
+}
+
+// ServerHello holds the fields of a server hello or hello retry request
+type ServerHello struct {
+	LegacyVersion uint16
+	Random        []byte
+	SessionID     []byte
+	CipherSuite   uint16
+	// all extensions by type
+	Extensions map[uint16][]byte
+
+	HelloRetryRequest bool
+	// selected version, zero without supported_versions
+	SupportedVersion uint16
+	// key share of a server hello, only the selected group of a retry
+	Group    uint16
+	KeyShare []byte
+	Cookie   []byte
+}
+
+func ParseClientHello(msg []byte) (*ClientHello, error) {
+
+	s, err := MessageBody(msg, TypeClientHello)
+	if err != nil {
+		return nil, err
+	}
+	ch := &ClientHello{Raw: msg, KeyShares: map[uint16][]byte{}}
+
+	var legacyVersion uint16
+	var sessionID, compressionMethods, cipherSuites cryptobyte.String
+	if !s.ReadUint16(&legacyVersion) ||
+		!s.ReadBytes(&ch.Random, 32) ||
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!s.ReadUint8LengthPrefixed(&compressionMethods) {
+		return nil, errors.New("malformed client hello")
+	}
+	ch.SessionID = sessionID
+	for !cipherSuites.Empty() {
+		var suite uint16
+		if !cipherSuites.ReadUint16(&suite) {
+			return nil, errors.New("malformed client hello cipher suites")
+		}
+		ch.CipherSuites = append(ch.CipherSuites, suite)
+	}
+
+	ch.Extensions, err = ParseExtensions(&s)
+	if err != nil {
+		return nil, err
+	}
+
+	for ext, raw := range ch.Extensions {
+		data := cryptobyte.String(raw)
+		var ok bool
+		switch ext {
+		case ExtensionServerName:
+			var nameList cryptobyte.String
+			ok = data.ReadUint16LengthPrefixed(&nameList)
+			for ok && !nameList.Empty() {
+				var nameType uint8
+				var name cryptobyte.String
+				ok = nameList.ReadUint8(&nameType) && nameList.ReadUint16LengthPrefixed(&name)
+				if nameType == 0 {
+					ch.ServerName = string(name)
+				}
+			}
+		case ExtensionSupportedGroups:
+			ch.Groups, ok = readUint16List(&data)
+		case ExtensionSignatureAlgorithms:
+			ch.SignatureSchemes, ok = readUint16List(&data)
+		case ExtensionALPN:
+			var protocols cryptobyte.String
+			ok = data.ReadUint16LengthPrefixed(&protocols)
+			for ok && !protocols.Empty() {
+				var proto cryptobyte.String
+				ok = protocols.ReadUint8LengthPrefixed(&proto)
+				ch.ALPN = append(ch.ALPN, string(proto))
+			}
+		case ExtensionSupportedVersions:
+			var versions cryptobyte.String
+			ok = data.ReadUint8LengthPrefixed(&versions)
+			for ok && !versions.Empty() {
+				var v uint16
+				ok = versions.ReadUint16(&v)
+				ch.SupportedVersions = append(ch.SupportedVersions, v)
+			}
+		case ExtensionKeyShare:
+			var shares cryptobyte.String
+			ok = data.ReadUint16LengthPrefixed(&shares)
+			for ok && !shares.Empty() {
+				var group uint16
+				var share cryptobyte.String
+				ok = shares.ReadUint16(&group) && shares.ReadUint16LengthPrefixed(&share)
+				ch.KeyShares[group] = share
+			}
+		default:
+			data, ok = nil, true
+		}
+		if !ok || !data.Empty() {
+			return nil, fmt.Errorf("malformed client hello extension %d", ext)
+		}
+	}
+
+	return ch, nil
+}
+
+func ParseServerHello(msg []byte) (*ServerHello, error) {
+
+	s, err := MessageBody(msg, TypeServerHello)
+	if err != nil {
+		return nil, err
+	}
+	sh := &ServerHello{HelloRetryRequest: IsHelloRetryRequest(msg)}
+
+	var sessionID cryptobyte.String
+	var compressionMethod uint8
+	if !s.ReadUint16(&sh.LegacyVersion) ||
+		!s.ReadBytes(&sh.Random, 32) ||
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16(&sh.CipherSuite) ||
+		!s.ReadUint8(&compressionMethod) {
+		return nil, errors.New("malformed server hello")
+	}
+	sh.SessionID = sessionID
+
+	sh.Extensions, err = ParseExtensions(&s)
+	if err != nil {
+		return nil, err
+	}
+
+	for ext, raw := range sh.Extensions {
+		data := cryptobyte.String(raw)
+		var ok bool
+		switch ext {
+		case ExtensionSupportedVersions:
+			ok = data.ReadUint16(&sh.SupportedVersion)
+		case ExtensionKeyShare:
+			ok = data.ReadUint16(&sh.Group)
+			if !sh.HelloRetryRequest {
+				ok = ok && data.ReadUint16LengthPrefixed((*cryptobyte.String)(&sh.KeyShare))
+			}
+		case ExtensionCookie:
+			ok = data.ReadUint16LengthPrefixed((*cryptobyte.String)(&sh.Cookie))
+		default:
+			data, ok = nil, true
+		}
+		if !ok || !data.Empty() {
+			return nil, fmt.Errorf("malformed server hello extension %d", ext)
+		}
+	}
+
+	return sh, nil
+}
+
+// ParseEncryptedExtensions returns the extensions of an encrypted extensions message
+func ParseEncryptedExtensions(msg []byte) (map[uint16][]byte, error) {
+	s, err := MessageBody(msg, TypeEncryptedExtensions)
+	if err != nil {
+		return nil, err
+	}
+	return ParseExtensions(&s)
+}
+
+// ParseALPN returns the protocol selected by the server in an alpn extension
+func ParseALPN(data []byte) (string, error) {
+
+	s := cryptobyte.String(data)
+	var protoList, proto cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&protoList) ||
+		!protoList.ReadUint8LengthPrefixed(&proto) ||
+		!protoList.Empty() || !s.Empty() || len(proto) == 0 {
+		return "", errors.New("malformed alpn extension")
+	}
+
+	return string(proto), nil
+}
+
+// ParseExtensions reads a length prefixed extension block, which must end
+// the message
+func ParseExtensions(s *cryptobyte.String) (map[uint16][]byte, error) {
+
+	extensions := make(map[uint16][]byte)
+	if s.Empty() {
+		return extensions, nil
+	}
+
+	var block cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&block) || !s.Empty() {
+		return nil, errors.New("malformed extensions")
+	}
+	for !block.Empty() {
+		var extType uint16
+		var data cryptobyte.String
+		if !block.ReadUint16(&extType) || !block.ReadUint16LengthPrefixed(&data) {
+			return nil, errors.New("malformed extension")
+		}
+		if _, ok := extensions[extType]; ok {
+			return nil, errors.New("duplicate extension")
+		}
+		extensions[extType] = data
+	}
+
+	return extensions, nil
+}
+
+// ParseCertificate returns the certificate chain of a certificate message
+func ParseCertificate(msg []byte) ([]*x509.Certificate, error) {
+
+	s, err := MessageBody(msg, TypeCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	var context, certList cryptobyte.String
+	if !s.ReadUint8LengthPrefixed(&context) || !s.ReadUint24LengthPrefixed(&certList) || !s.Empty() {
+		return nil, errors.New("malformed certificate message")
+	}
+
+	var certs []*x509.Certificate
+	for !certList.Empty() {
+		var certData, extensions cryptobyte.String
+		if !certList.ReadUint24LengthPrefixed(&certData) || !certList.ReadUint16LengthPrefixed(&extensions) {
+			return nil, errors.New("malformed certificate entry")
+		}
+		cert, err := x509.ParseCertificate(certData)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("empty certificate message")
+	}
+
+	return certs, nil
+}
+
+// ParseCertificateVerify returns signature scheme and signature of a
+// certificate verify message
+func ParseCertificateVerify(msg []byte) (uint16, []byte, error) {
+
+	s, err := MessageBody(msg, TypeCertificateVerify)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var scheme uint16
+	var signature cryptobyte.String
+	if !s.ReadUint16(&scheme) || !s.ReadUint16LengthPrefixed(&signature) || !s.Empty() {
+		return 0, nil, errors.New("malformed certificate verify message")
+	}
+
+	return scheme, signature, nil
+}
+
+func readUint16List(data *cryptobyte.String) ([]uint16, bool) {
+	var list cryptobyte.String
+	if !data.ReadUint16LengthPrefixed(&list) {
+		return nil, false
+	}
+	var out []uint16
+	for !list.Empty() {
+		var v uint16
+		if !list.ReadUint16(&v) {
+			return nil, false
+		}
+		out = append(out, v)
+	}
+	return out, true
+}
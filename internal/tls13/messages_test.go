@@ -0,0 +1,118 @@
+package tls13
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+var testRandom = bytes.Repeat([]byte{1}, 32)
+
+// server hello selecting TLS_AES_128_GCM_SHA256 without extensions
+func testServerHello(random []byte) []byte {
+	body := append([]byte{3, 3}, random...)
+	body = append(body, 0, 0x13, 0x01, 0)
+	return HandshakeMessage(TypeServerHello, body)
+}
+
+func testClientHello(serverName string) []byte {
+
+	var b cryptobyte.Builder
+	b.AddUint16(0x0303)
+	b.AddBytes(testRandom)
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(0x1301)
+		b.AddUint16(0x1302)
+	})
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint8(0) })
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(ExtensionServerName)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8(0)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes([]byte(serverName))
+				})
+			})
+		})
+		b.AddUint16(ExtensionSupportedVersions)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint16(0x0304) })
+		})
+	})
+
+	return HandshakeMessage(TypeClientHello, b.BytesOrPanic())
+}
+
+func TestParseClientHello(t *testing.T) {
+
+	ch, err := ParseClientHello(testClientHello("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.ServerName != "example.com" || !bytes.Equal(ch.Random, testRandom) {
+		t.Errorf("server name %q, random %x", ch.ServerName, ch.Random)
+	}
+	if len(ch.CipherSuites) != 2 || ch.CipherSuites[0] != 0x1301 {
+		t.Errorf("cipher suites %x", ch.CipherSuites)
+	}
+	if _, ok := ch.Extensions[ExtensionSupportedVersions]; !ok {
+		t.Error("missing supported versions extension")
+	}
+}
+
+// malformed messages, e.g. a disclosed inner client hello, must be rejected
+// without reading past the message
+func TestHandshakeMessageHeaders(t *testing.T) {
+
+	valid := testClientHello("example.com")
+	long := append(append([]byte{}, valid...), 0)
+
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", []byte{}},
+		{"nil", nil},
+		{"truncated header", valid[:3]},
+		{"header only", valid[:4]},
+		{"truncated body", valid[:len(valid)-1]},
+		{"trailing byte", long},
+		{"wrong type", testServerHello(testRandom)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseClientHello(tt.msg); err == nil {
+				t.Error("ParseClientHello accepted the message")
+			}
+		})
+	}
+
+	parsers := map[string]func([]byte) error{
+		"server hello":         func(m []byte) error { _, err := ParseServerHello(m); return err },
+		"encrypted extensions": func(m []byte) error { _, err := ParseEncryptedExtensions(m); return err },
+		"certificate":          func(m []byte) error { _, err := ParseCertificate(m); return err },
+		"certificate verify":   func(m []byte) error { _, _, err := ParseCertificateVerify(m); return err },
+	}
+	for name, parse := range parsers {
+		for _, msg := range [][]byte{nil, {}, {TypeFinished, 0, 0}, HandshakeMessage(TypeFinished, []byte("xx")), valid} {
+			if parse(msg) == nil {
+				t.Errorf("%s parser accepted %x", name, msg)
+			}
+		}
+	}
+}
+
+func TestParseServerHello(t *testing.T) {
+
+	sh, err := ParseServerHello(testServerHello(testRandom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sh.CipherSuite != 0x1301 || !bytes.Equal(sh.Random, testRandom) {
+		t.Errorf("cipher suite %x, random %x", sh.CipherSuite, sh.Random)
+	}
+}
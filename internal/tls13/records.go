@@ -0,0 +1,147 @@
+package tls13
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// tls record layer constants
+const (
+	RecordHeaderLen = 5
+	// maximum plaintext length of a record
+	MaxPlaintext = 16384
+	// maximum ciphertext length of a tls 1.3 record
+	MaxRecordLen = MaxPlaintext + 256
+
+	RecordTypeChangeCipherSpec uint8 = 20
+	RecordTypeAlert            uint8 = 21
+	RecordTypeHandshake        uint8 = 22
+	RecordTypeApplicationData  uint8 = 23
+
+	VersionTLS10 uint16 = 0x0301
+	VersionTLS12 uint16 = 0x0303
+	VersionTLS13 uint16 = 0x0304
+)
+
+// alert levels and descriptions
+const (
+	AlertLevelWarning      uint8 = 1
+	AlertLevelFatal        uint8 = 2
+	AlertCloseNotify       uint8 = 0
+	AlertUnexpectedMessage uint8 = 10
+	AlertHandshakeFailure  uint8 = 40
+	AlertIllegalParameter  uint8 = 47
+	AlertDecryptError      uint8 = 51
+	AlertProtocolVersion   uint8 = 70
+)
+
+// Record is a tls record as sent on the wire
+type Record struct {
+	ContentType uint8
+	// 5 byte record header, the additional data of protected records
+	Header  []byte
+	Payload []byte
+}
+
+// ReadRecord reads the next record from a connection
+func ReadRecord(r io.Reader) (Record, error) {
+
+	header := make([]byte, RecordHeaderLen)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return Record{}, err
+	}
+	length := int(binary.BigEndian.Uint16(header[3:5]))
+	if length > MaxRecordLen {
+		return Record{}, fmt.Errorf("record length %d exceeds maximum", length)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return Record{}, err
+	}
+
+	return Record{ContentType: header[0], Header: header, Payload: payload}, nil
+}
+
+// HalfConn protects the records of one traffic direction
+type HalfConn struct {
+	Suite  *CipherSuite
+	Secret []byte
+	Key    []byte
+	IV     []byte
+	AEAD   cipher.AEAD
+	// sequence number of the next record
+	Seq uint64
+}
+
+// NewHalfConn derives key and iv from a traffic secret
+func NewHalfConn(suite *CipherSuite, secret []byte) (*HalfConn, error) {
+
+	key := suite.ExpandLabel(secret, "key", nil, suite.KeyLen)
+	iv := suite.ExpandLabel(secret, "iv", nil, 12)
+
+	aead, err := suite.AEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HalfConn{Suite: suite, Secret: secret, Key: key, IV: iv, AEAD: aead}, nil
+}
+
+// Next returns the half connection of the updated traffic secret, see
+// RFC 8446 7.2
+func (hc *HalfConn) Next() (*HalfConn, error) {
+	secret := hc.Suite.ExpandLabel(hc.Secret, "traffic upd", nil, hc.Suite.Hash().Size())
+	return NewHalfConn(hc.Suite, secret)
+}
+
+// Nonce of the record with sequence number seq, see RFC 8446 5.3
+func (hc *HalfConn) Nonce(seq uint64) []byte {
+	nonce := make([]byte, len(hc.IV))
+	copy(nonce, hc.IV)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(seq >> (8 * i))
+	}
+	return nonce
+}
+
+// Seal encrypts an inner plaintext into a protected record
+func (hc *HalfConn) Seal(contentType uint8, data []byte) []byte {
+
+	inner := append(append([]byte{}, data...), contentType)
+	length := len(inner) + hc.AEAD.Overhead()
+
+	header := []byte{RecordTypeApplicationData, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(header[1:3], VersionTLS12)
+	binary.BigEndian.PutUint16(header[3:5], uint16(length))
+
+	out := hc.AEAD.Seal(header, hc.Nonce(hc.Seq), inner, header)
+	hc.Seq++
+	return out
+}
+
+// Open returns the inner content type and plaintext of a protected record
+func (hc *HalfConn) Open(header []byte, payload []byte) (uint8, []byte, error) {
+
+	plaintext, err := hc.AEAD.Open(nil, hc.Nonce(hc.Seq), payload, header)
+	if err != nil {
+		return 0, nil, fmt.Errorf("record %d: %w", hc.Seq, err)
+	}
+	hc.Seq++
+
+	// strip zero padding, the last non zero byte is the content type
+	i := len(plaintext) - 1
+	for i >= 0 && plaintext[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return 0, nil, errors.New("record without inner content type")
+	}
+
+	return plaintext[i], plaintext[:i], nil
+}
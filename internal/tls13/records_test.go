@@ -0,0 +1,53 @@
+package tls13
+
+import (
+	"bytes"
+	"testing"
+)
+
+// records sealed by one side open on the other, also after a key update
+func TestHalfConnRoundTrip(t *testing.T) {
+
+	for _, suite := range cipherSuites {
+		secret := bytes.Repeat([]byte{7}, suite.Hash().Size())
+		seal, err := NewHalfConn(suite, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		open, err := NewHalfConn(suite, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, data := range [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte{9}, 300)} {
+			if i == 2 {
+				seal, err = seal.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				open, err = open.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			r, err := ReadRecord(bytes.NewReader(seal.Seal(RecordTypeApplicationData, data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			contentType, plaintext, err := open.Open(r.Header, r.Payload)
+			if err != nil {
+				t.Fatalf("suite %#04x record %d: %v", suite.ID, i, err)
+			}
+			if contentType != RecordTypeApplicationData || !bytes.Equal(plaintext, data) {
+				t.Errorf("suite %#04x record %d: type %d, plaintext %x", suite.ID, i, contentType, plaintext)
+			}
+		}
+
+		// the header is authenticated
+		record := seal.Seal(RecordTypeHandshake, []byte("x"))
+		_, _, err = open.Open([]byte{RecordTypeApplicationData, 3, 1, 0, 0}, record[RecordHeaderLen:])
+		if err == nil {
+			t.Errorf("suite %#04x: opened a record with a wrong header", suite.ID)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package listen
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	u "proxy/utils"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// client hello extensions of interest
+const (
+	extensionServerName          uint16 = 0
+	extensionSupportedGroups     uint16 = 10
+	extensionPointFormats        uint16 = 11
+	extensionSignatureAlgorithms uint16 = 13
+	extensionALPN                uint16 = 16
+	extensionSupportedVersions   uint16 = 43
+	extensionECH                 uint16 = 0xfe0d
+)
+
+// grease values of RFC 8701 are excluded from fingerprints
+func isGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// readHandshakeMessage reassembles the first handshake message from the raw
+// bytes of the client flight, which may span several records
+func readHandshakeMessage(raw []byte) ([]byte, error) {
+
+	var msg []byte
+	for len(raw) >= 5 && raw[0] == 22 {
+		n := int(binary.BigEndian.Uint16(raw[3:5]))
+		if len(raw) < 5+n {
+			break
+		}
+		msg = append(msg, raw[5:5+n]...)
+		raw = raw[5+n:]
+
+		if len(msg) >= 4 {
+			length := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+			if len(msg) >= 4+length {
+				return msg[:4+length], nil
+			}
+		}
+	}
+
+	return nil, errors.New("incomplete client hello")
+}
+
+// parseClientHelloMeta extracts offers and JA3/JA4 fingerprints from the raw
+// client hello record(s)
+func parseClientHelloMeta(raw []byte) (*u.ClientHelloMeta, error) {
+
+	msg, err := readHandshakeMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	s := cryptobyte.String(msg)
+	var msgType uint8
+	var body cryptobyte.String
+	if !s.ReadUint8(&msgType) || msgType != 1 || !s.ReadUint24LengthPrefixed(&body) {
+		return nil, errors.New("not a client hello")
+	}
+
+	var legacyVersion uint16
+	var random []byte
+	var sessionID, cipherSuites, compression, extensions cryptobyte.String
+	if !body.ReadUint16(&legacyVersion) ||
+		!body.ReadBytes(&random, 32) ||
+		!body.ReadUint8LengthPrefixed(&sessionID) ||
+		!body.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!body.ReadUint8LengthPrefixed(&compression) {
+		return nil, errors.New("malformed client hello")
+	}
+	if !body.Empty() && !body.ReadUint16LengthPrefixed(&extensions) {
+		return nil, errors.New("malformed client hello extensions")
+	}
+
+	meta := &u.ClientHelloMeta{}
+	for !cipherSuites.Empty() {
+		var suite uint16
+		if !cipherSuites.ReadUint16(&suite) {
+			return nil, errors.New("malformed cipher suites")
+		}
+		if !isGrease(suite) {
+			meta.CipherSuites = append(meta.CipherSuites, suite)
+		}
+	}
+
+	for !extensions.Empty() {
+		var extType uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&extType) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return nil, errors.New("malformed extension")
+		}
+		if isGrease(extType) {
+			continue
+		}
+		meta.Extensions = append(meta.Extensions, extType)
+
+		err = parseExtension(meta, extType, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(meta.Versions) == 0 {
+		meta.Versions = []uint16{legacyVersion}
+	}
+
+	meta.JA3 = ja3(legacyVersion, meta)
+	h := md5.Sum([]byte(meta.JA3))
+	meta.JA3Hash = hex.EncodeToString(h[:])
+	meta.JA4 = ja4(meta)
+
+	return meta, nil
+}
+
+func parseExtension(meta *u.ClientHelloMeta, extType uint16, data cryptobyte.String) error {
+
+	var list cryptobyte.String
+	switch extType {
+	case extensionECH:
+		meta.ECH = true
+
+	case extensionServerName:
+		if !data.ReadUint16LengthPrefixed(&list) {
+			return errors.New("malformed server name")
+		}
+		for !list.Empty() {
+			var nameType uint8
+			var name cryptobyte.String
+			if !list.ReadUint8(&nameType) || !list.ReadUint16LengthPrefixed(&name) {
+				return errors.New("malformed server name")
+			}
+			if nameType == 0 {
+				meta.ServerName = string(name)
+			}
+		}
+
+	case extensionSupportedGroups, extensionSignatureAlgorithms:
+		if !data.ReadUint16LengthPrefixed(&list) {
+			return fmt.Errorf("malformed extension %d", extType)
+		}
+		for !list.Empty() {
+			var v uint16
+			if !list.ReadUint16(&v) {
+				return fmt.Errorf("malformed extension %d", extType)
+			}
+			if isGrease(v) {
+				continue
+			}
+			if extType == extensionSignatureAlgorithms {
+				meta.SignatureSchemes = append(meta.SignatureSchemes, v)
+			} else {
+				meta.SupportedGroups = append(meta.SupportedGroups, v)
+			}
+		}
+
+	case extensionPointFormats:
+		if !data.ReadUint8LengthPrefixed(&list) {
+			return errors.New("malformed point formats")
+		}
+		for _, f := range list {
+			meta.PointFormats = append(meta.PointFormats, int(f))
+		}
+
+	case extensionALPN:
+		if !data.ReadUint16LengthPrefixed(&list) {
+			return errors.New("malformed alpn")
+		}
+		for !list.Empty() {
+			var proto cryptobyte.String
+			if !list.ReadUint8LengthPrefixed(&proto) {
+				return errors.New("malformed alpn")
+			}
+			meta.ALPN = append(meta.ALPN, string(proto))
+		}
+
+	case extensionSupportedVersions:
+		if !data.ReadUint8LengthPrefixed(&list) {
+			return errors.New("malformed supported versions")
+		}
+		for !list.Empty() {
+			var v uint16
+			if !list.ReadUint16(&v) {
+				return errors.New("malformed supported versions")
+			}
+			if !isGrease(v) {
+				meta.Versions = append(meta.Versions, v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// joins values as decimal numbers
+func joinDec(values []uint16) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(s, "-")
+}
+
+// ja3 returns the JA3 string: version,ciphers,extensions,groups,point formats
+func ja3(legacyVersion uint16, meta *u.ClientHelloMeta) string {
+
+	formats := make([]uint16, len(meta.PointFormats))
+	for i, f := range meta.PointFormats {
+		formats[i] = uint16(f)
+	}
+
+	return strings.Join([]string{
+		strconv.Itoa(int(legacyVersion)),
+		joinDec(meta.CipherSuites),
+		joinDec(meta.Extensions),
+		joinDec(meta.SupportedGroups),
+		joinDec(formats),
+	}, ",")
+}
+
+// ja4 returns the JA4 fingerprint of a client hello sent over tcp
+func ja4(meta *u.ClientHelloMeta) string {
+
+	// highest offered version
+	var version uint16
+	for _, v := range meta.Versions {
+		if v > version {
+			version = v
+		}
+	}
+	versions := map[uint16]string{0x0304: "13", 0x0303: "12", 0x0302: "11", 0x0301: "10", 0x0300: "s3"}
+	v, ok := versions[version]
+	if !ok {
+		v = "00"
+	}
+
+	sni := "i"
+	if meta.ServerName != "" {
+		sni = "d"
+	}
+
+	// first and last character of the first alpn value, of its hex encoding
+	// if one of them is not alphanumeric
+	alpn := "00"
+	if len(meta.ALPN) > 0 && meta.ALPN[0] != "" {
+		first := meta.ALPN[0]
+		if !isAlphanumeric(first[0]) || !isAlphanumeric(first[len(first)-1]) {
+			first = hex.EncodeToString([]byte(first))
+		}
+		alpn = string(first[0]) + string(first[len(first)-1])
+	}
+
+	count := func(n int) string {
+		if n > 99 {
+			n = 99
+		}
+		return fmt.Sprintf("%02d", n)
+	}
+	a := "t" + v + sni + count(len(meta.CipherSuites)) + count(len(meta.Extensions)) + alpn
+
+	// sorted ciphers
+	b := truncatedHash(sortedHex(meta.CipherSuites))
+
+	// sorted extensions without sni and alpn, followed by signature schemes
+	var exts []uint16
+	for _, e := range meta.Extensions {
+		if e != extensionServerName && e != extensionALPN {
+			exts = append(exts, e)
+		}
+	}
+	c := sortedHex(exts)
+	if len(meta.SignatureSchemes) > 0 {
+		c += "_" + hexList(meta.SignatureSchemes)
+	}
+
+	return a + "_" + b + "_" + truncatedHash(c)
+}
+
+func isAlphanumeric(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z'
+}
+
+func hexList(values []uint16) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+func sortedHex(values []uint16) string {
+	sorted := make([]uint16, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return hexList(sorted)
+}
+
+// first 12 hex characters of the sha256 hash, zeros for empty input
+func truncatedHash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])[:12]
+}
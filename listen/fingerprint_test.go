@@ -0,0 +1,189 @@
+package listen
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+type testExtension struct {
+	typ  uint16
+	data []byte
+}
+
+// testClientHello encodes a client hello record with the given offers
+func testClientHello(legacyVersion uint16, ciphers []uint16, extensions []testExtension) []byte {
+
+	var b cryptobyte.Builder
+	b.AddUint8(1)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(legacyVersion)
+		b.AddBytes(make([]byte, 32))
+		b.AddUint8(0)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, c := range ciphers {
+				b.AddUint16(c)
+			}
+		})
+		b.AddBytes([]byte{1, 0})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, e := range extensions {
+				b.AddUint16(e.typ)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(e.data) })
+			}
+		})
+	})
+	msg := b.BytesOrPanic()
+
+	return append([]byte{22, 3, 1, byte(len(msg) >> 8), byte(len(msg))}, msg...)
+}
+
+// extension data of a list of 16 bit values, prefixed with the length in
+// lengthBytes bytes
+func testList(lengthBytes int, values ...uint16) []byte {
+	var b cryptobyte.Builder
+	add := func(b *cryptobyte.Builder) {
+		for _, v := range values {
+			b.AddUint16(v)
+		}
+	}
+	if lengthBytes == 1 {
+		b.AddUint8LengthPrefixed(add)
+	} else {
+		b.AddUint16LengthPrefixed(add)
+	}
+	return b.BytesOrPanic()
+}
+
+func testServerName(name string) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(0)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(name)) })
+	})
+	return b.BytesOrPanic()
+}
+
+func testALPN(protos ...string) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, p := range protos {
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(p)) })
+		}
+	})
+	return b.BytesOrPanic()
+}
+
+// the example of the JA3 readme and the chrome example of the JA4 technical
+// details, grease values are ignored by both
+func TestFingerprintVectors(t *testing.T) {
+
+	ja3Ciphers := []uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4}
+	ja3Extensions := []testExtension{
+		{extensionServerName, testServerName("example.com")},
+		{extensionSupportedGroups, testList(2, 23, 24, 25)},
+		{extensionPointFormats, []byte{1, 0}},
+	}
+	greaseExtensions := append([]testExtension{{0x1a1a, nil}}, ja3Extensions...)
+	greaseExtensions[2] = testExtension{extensionSupportedGroups, testList(2, 0x2a2a, 23, 24, 25)}
+
+	chromeCiphers := []uint16{0x4a4a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035}
+	chromeExtensions := []testExtension{
+		{0x0a0a, nil},
+		{extensionServerName, testServerName("example.com")},
+		{0x0017, nil},
+		{0xff01, []byte{0}},
+		{extensionSupportedGroups, testList(2, 0x3a3a, 0x001d, 0x0017, 0x0018)},
+		{extensionPointFormats, []byte{1, 0}},
+		{0x0023, nil},
+		{extensionALPN, testALPN("h2", "http/1.1")},
+		{0x0005, []byte{1, 0, 0, 0, 0}},
+		{extensionSignatureAlgorithms, testList(2, 0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601)},
+		{0x0012, nil},
+		{0x0033, nil},
+		{0x002d, []byte{1, 1}},
+		{extensionSupportedVersions, testList(1, 0x3a3a, 0x0304, 0x0303)},
+		{0x001b, nil},
+		{0x4469, nil},
+		{0x0015, nil},
+		{0x1a1a, []byte{0}},
+	}
+
+	tests := []struct {
+		name    string
+		hello   []byte
+		ja3     string
+		ja3Hash string
+		ja4     string
+	}{
+		{
+			"ja3 readme",
+			testClientHello(769, ja3Ciphers, ja3Extensions),
+			"769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			"ada70206e40642a3e4461f35503241d5",
+			"",
+		},
+		{
+			"ja3 readme with grease",
+			testClientHello(769, append([]uint16{0x0a0a}, ja3Ciphers...), greaseExtensions),
+			"769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			"ada70206e40642a3e4461f35503241d5",
+			"",
+		},
+		{
+			"ja4 chrome",
+			testClientHello(0x0303, chromeCiphers, chromeExtensions),
+			"",
+			"",
+			"t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := parseClientHelloMeta(tt.hello)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ja3 != "" && (meta.JA3 != tt.ja3 || meta.JA3Hash != tt.ja3Hash) {
+				t.Errorf("ja3 %s %s, want %s %s", meta.JA3, meta.JA3Hash, tt.ja3, tt.ja3Hash)
+			}
+			if tt.ja4 != "" && meta.JA4 != tt.ja4 {
+				t.Errorf("ja4 %s, want %s", meta.JA4, tt.ja4)
+			}
+		})
+	}
+}
+
+func TestJA4ALPN(t *testing.T) {
+
+	tests := []struct {
+		name string
+		alpn []string
+		want string
+	}{
+		{"none", nil, "00"},
+		{"h2", []string{"h2", "http/1.1"}, "h2"},
+		{"http/1.1", []string{"http/1.1"}, "h1"},
+		{"single character", []string{"h"}, "hh"},
+		{"non-alphanumeric bytes", []string{"\xab\xcd"}, "ad"},
+		{"non-alphanumeric last byte", []string{"h2\x00"}, "60"},
+		{"non-alphanumeric first byte", []string{"-h2"}, "22"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var extensions []testExtension
+			if tt.alpn != nil {
+				extensions = append(extensions, testExtension{extensionALPN, testALPN(tt.alpn...)})
+			}
+			meta, err := parseClientHelloMeta(testClientHello(0x0303, nil, extensions))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := meta.JA4[8:10]; got != tt.want {
+				t.Errorf("alpn %q, want %q in %s", got, tt.want, meta.JA4)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	// "crypto/tls"
 	tls "proxy/tls-fork"
+	u "proxy/utils"
 
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
@@ -23,6 +24,10 @@ type Listener struct {
 	ClientSentRecordsFileName string
 	DefaultPort               string
 	LocalhostPort             string
+	// expect a PROXY protocol header on inbound connections
+	AcceptProxyProtocol bool
+	// PROXY protocol version sent to upstream servers, none if empty
+	UpstreamProxyProtocol string
 }
 
 func NewListener(proxyURL string) Listener {
@@ -62,8 +67,27 @@ func (l *Listener) Listen() error {
 			return err
 		}
 
+		// original addresses of connections forwarded by a load balancer
+		var clientIn io.Reader = clientConn
+		sourceAddr, destAddr := clientConn.RemoteAddr(), clientConn.LocalAddr()
+		if l.AcceptProxyProtocol {
+			br := bufio.NewReader(clientConn)
+			src, dst, err := readProxyHeader(br)
+			if err != nil {
+				// health checks and scanners must not stop the listener
+				log.Error().Err(err).Msg("readProxyHeader()")
+				clientConn.Close()
+				continue
+			}
+			if src != nil {
+				sourceAddr, destAddr = src, dst
+				log.Debug().Msg("proxied connection from " + sourceAddr.String())
+			}
+			clientIn = br
+		}
+
 		// read clientHello
-		clientHello, clientReader, err := peekClientHello(clientConn)
+		clientHello, clientHelloRaw, clientReader, err := peekClientHello(clientIn)
 		if err != nil {
 			log.Error().Err(err).Msg("peekClientHello(clientConn)")
 			return err
@@ -75,9 +99,25 @@ func (l *Listener) Listen() error {
 			return err
 		}
 
-		// use port 8081 if local setting, default to 443
+		// stamp the captured session with capture time and nonce
+		session, err := u.NewSession()
+		if err != nil {
+			return err
+		}
+		session.SourceAddr = sourceAddr.String()
+		// metadata is left empty for client hellos the fork accepts but we cannot parse
+		session.ClientHello, err = parseClientHelloMeta(clientHelloRaw)
+		if err != nil {
+			log.Error().Err(err).Msg("parseClientHelloMeta()")
+		}
+
+		// use port 8081 if local setting, default to 443. with ech the
+		// connection is routed on the public name of the outer client hello
 		port := l.DefaultPort
 		domain := clientHello.ServerName
+		if session.ClientHello != nil && session.ClientHello.ECH {
+			log.Debug().Msg("ech offered, routing on outer server name " + domain)
+		}
 		if domain == "localhost" {
 			port = l.LocalhostPort
 		}
@@ -89,6 +129,20 @@ func (l *Listener) Listen() error {
 			return err
 		}
 
+		// announce the original client to the upstream, the header is not captured
+		if l.UpstreamProxyProtocol != "" {
+			err = writeProxyHeader(serverConn, l.UpstreamProxyProtocol, sourceAddr, destAddr)
+			if err != nil {
+				log.Error().Err(err).Msg("writeProxyHeader()")
+				return err
+			}
+		}
+
+		err = u.StoreSession(session)
+		if err != nil {
+			return err
+		}
+
 		// prepare capturing configurations
 		serverPath := l.StoragePath + l.ServerSentRecordsFileName
 		clientPath := l.StoragePath + l.ClientSentRecordsFileName
@@ -112,6 +166,14 @@ func (l *Listener) Listen() error {
 			return err
 		}
 
+		// mark end of capture
+		closedAt := time.Now().UTC()
+		session.ClosedAt = &closedAt
+		err = u.StoreSession(session)
+		if err != nil {
+			return err
+		}
+
 		// close channels
 		defer clientConn.Close()
 		defer serverConn.Close()
@@ -217,14 +279,15 @@ func bufferWrite(msg []byte, fileHandleRaw *os.File, fileHandleTxt *os.File) {
 // sale, use or other dealings in this Software without prior written
 // authorization.
 
-// reader of clientHello
-func peekClientHello(reader io.Reader) (*tls.ClientHelloInfo, io.Reader, error) {
+// reader of clientHello, also returns the raw bytes read
+func peekClientHello(reader io.Reader) (*tls.ClientHelloInfo, []byte, io.Reader, error) {
 	peekedBytes := new(bytes.Buffer)
 	hello, err := readClientHello(io.TeeReader(reader, peekedBytes))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	return hello, io.MultiReader(peekedBytes, reader), nil
+	raw := append([]byte(nil), peekedBytes.Bytes()...)
+	return hello, raw, io.MultiReader(peekedBytes, reader), nil
 }
 
 // connection io reader
@@ -0,0 +1,69 @@
+package listen
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// a connection without a valid PROXY header, e.g. a load balancer health
+// check, is dropped and the next connection is served
+func TestListenSkipsBadProxyHeader(t *testing.T) {
+
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.Addr().String()
+	probe.Close()
+
+	l := NewListener(addr)
+	l.AcceptProxyProtocol = true
+	done := make(chan error, 1)
+	go func() { done <- l.Listen() }()
+
+	dial := func(data string) net.Conn {
+		var conn net.Conn
+		for i := 0; i < 50; i++ {
+			conn, err = net.Dial("tcp", addr)
+			if err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.Write([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	bad := dial("GET / HTTP/1.1\r\n\r\n")
+	defer bad.Close()
+	bad.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadAll(bad)
+	if err != nil {
+		t.Fatalf("bad header connection was not closed: %v", err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("listener stopped on a bad PROXY header: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the header is accepted, the missing client hello ends Listen
+	good := dial("PROXY TCP4 192.0.2.1 198.51.100.7 51000 443\r\n")
+	good.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Listen returned without error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("second connection was not served")
+	}
+}
@@ -0,0 +1,194 @@
+package listen
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PROXY protocol versions, see https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyProtocolV1MaxLen = 107
+	proxyProtocolV2HdrLen = 16
+	proxyProtocolV2Local  = 0x20
+	proxyProtocolV2Proxy  = 0x21
+	proxyProtocolV2TCP4   = 0x11
+	proxyProtocolV2TCP6   = 0x21
+	proxyProtocolV2Unspec = 0x00
+)
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header and returns the
+// original source and destination addresses. both are nil if the header
+// carries no addresses, e.g. for health checks of the balancer.
+func readProxyHeader(r *bufio.Reader) (net.Addr, net.Addr, error) {
+
+	sig, err := r.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(sig, proxyProtocolV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+
+	return nil, nil, errors.New("missing PROXY protocol header")
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+
+	// the header line is at most 107 bytes including crlf
+	var line []byte
+	for len(line) < proxyProtocolV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("PROXY v1 header too long")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+
+	src, err := tcpAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := tcpAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	if (src.IP.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, nil, errors.New("PROXY v1 address does not match protocol")
+	}
+
+	return src, dst, nil
+}
+
+func tcpAddr(ip string, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if addr == nil || err != nil {
+		return nil, fmt.Errorf("malformed PROXY address %s:%s", ip, port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+
+	hdr := make([]byte, proxyProtocolV2HdrLen)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil {
+		return nil, nil, err
+	}
+	command, family := hdr[12], hdr[13]
+	length := int(binary.BigEndian.Uint16(hdr[14:16]))
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch command {
+	case proxyProtocolV2Local:
+		return nil, nil, nil
+	case proxyProtocolV2Proxy:
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY v2 command %#x", command)
+	}
+
+	// addresses are followed by optional tlvs, which are ignored
+	var ipLen int
+	switch family {
+	case proxyProtocolV2TCP4:
+		ipLen = net.IPv4len
+	case proxyProtocolV2TCP6:
+		ipLen = net.IPv6len
+	case proxyProtocolV2Unspec:
+		return nil, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY v2 address family %#x", family)
+	}
+	if length < 2*ipLen+4 {
+		return nil, nil, errors.New("PROXY v2 header too short")
+	}
+
+	src := &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+
+	return src, dst, nil
+}
+
+// writeProxyHeader sends a PROXY protocol header announcing src and dst
+func writeProxyHeader(w io.Writer, version string, src net.Addr, dst net.Addr) error {
+
+	s, ok1 := src.(*net.TCPAddr)
+	d, ok2 := dst.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return errors.New("PROXY header requires tcp addresses")
+	}
+	isIPv4 := s.IP.To4() != nil && d.IP.To4() != nil
+
+	var hdr []byte
+	switch version {
+	case ProxyProtocolV1:
+		proto := "TCP6"
+		if isIPv4 {
+			proto = "TCP4"
+		}
+		hdr = []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, s.IP, d.IP, s.Port, d.Port))
+
+	case ProxyProtocolV2:
+		family := byte(proxyProtocolV2TCP6)
+		srcIP, dstIP := s.IP.To16(), d.IP.To16()
+		if isIPv4 {
+			family = proxyProtocolV2TCP4
+			srcIP, dstIP = s.IP.To4(), d.IP.To4()
+		}
+		hdr = append(hdr, proxyProtocolV2Signature...)
+		hdr = append(hdr, proxyProtocolV2Proxy, family)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(2*len(srcIP)+4))
+		hdr = append(hdr, srcIP...)
+		hdr = append(hdr, dstIP...)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(s.Port))
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(d.Port))
+
+	default:
+		return fmt.Errorf("unknown PROXY protocol version %q", version)
+	}
+
+	_, err := w.Write(hdr)
+	return err
+}
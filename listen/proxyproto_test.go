@@ -0,0 +1,136 @@
+package listen
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestProxyHeaderRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		version string
+		src     string
+		dst     string
+		want    string
+	}{
+		{"v1 tcp4", ProxyProtocolV1, "192.0.2.1:51000", "198.51.100.7:443", "PROXY TCP4 192.0.2.1 198.51.100.7 51000 443\r\n"},
+		{"v1 tcp6", ProxyProtocolV1, "[2001:db8::1]:51000", "[2001:db8::2]:443", "PROXY TCP6 2001:db8::1 2001:db8::2 51000 443\r\n"},
+		{"v2 tcp4", ProxyProtocolV2, "192.0.2.1:51000", "198.51.100.7:443", ""},
+		{"v2 tcp6", ProxyProtocolV2, "[2001:db8::1]:51000", "[2001:db8::2]:443", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, _ := net.ResolveTCPAddr("tcp", tt.src)
+			dst, _ := net.ResolveTCPAddr("tcp", tt.dst)
+
+			var buf bytes.Buffer
+			err := writeProxyHeader(&buf, tt.version, src, dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != "" && buf.String() != tt.want {
+				t.Fatalf("header %q, want %q", buf.String(), tt.want)
+			}
+
+			// the header is followed by the client hello, which must remain unread
+			buf.WriteString("\x16\x03\x01")
+			r := bufio.NewReader(&buf)
+			gotSrc, gotDst, err := readProxyHeader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotSrc.String() != src.String() || gotDst.String() != dst.String() {
+				t.Fatalf("addresses %s %s, want %s %s", gotSrc, gotDst, src, dst)
+			}
+			rest, _ := r.Peek(3)
+			if string(rest) != "\x16\x03\x01" {
+				t.Fatalf("header consumed %q of the following data", rest)
+			}
+		})
+	}
+}
+
+// v2 header with command, family and address payload
+func proxyHeaderV2(command byte, family byte, payload []byte) []byte {
+	hdr := append([]byte{}, proxyProtocolV2Signature...)
+	hdr = append(hdr, command, family)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(payload)))
+	return append(hdr, payload...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+
+	tcp4 := []byte{192, 0, 2, 1, 198, 51, 100, 7, 0xc7, 0x38, 0x01, 0xbb}
+	tlv := []byte{0x04, 0x00, 0x01, 0x00}
+
+	tests := []struct {
+		name    string
+		header  []byte
+		src     string
+		wantErr bool
+	}{
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 unknown with addresses", []byte("PROXY UNKNOWN ::1 ::1 1 2\r\n"), "", false},
+		{"v1 tcp4", []byte("PROXY TCP4 192.0.2.1 198.51.100.7 51000 443\r\n"), "192.0.2.1:51000", false},
+		{"v1 mismatched family", []byte("PROXY TCP4 2001:db8::1 2001:db8::2 51000 443\r\n"), "", true},
+		{"v1 bad port", []byte("PROXY TCP4 192.0.2.1 198.51.100.7 70000 443\r\n"), "", true},
+		{"v1 missing field", []byte("PROXY TCP4 192.0.2.1 198.51.100.7 51000\r\n"), "", true},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), "", true},
+		{"v2 tcp4", proxyHeaderV2(proxyProtocolV2Proxy, proxyProtocolV2TCP4, tcp4), "192.0.2.1:51000", false},
+		{"v2 tcp4 with tlv", proxyHeaderV2(proxyProtocolV2Proxy, proxyProtocolV2TCP4, append(append([]byte{}, tcp4...), tlv...)), "192.0.2.1:51000", false},
+		{"v2 local", proxyHeaderV2(proxyProtocolV2Local, proxyProtocolV2Unspec, nil), "", false},
+		{"v2 unspec", proxyHeaderV2(proxyProtocolV2Proxy, proxyProtocolV2Unspec, nil), "", false},
+		{"v2 short addresses", proxyHeaderV2(proxyProtocolV2Proxy, proxyProtocolV2TCP4, tcp4[:8]), "", true},
+		{"v2 unknown command", proxyHeaderV2(0x22, proxyProtocolV2TCP4, tcp4), "", true},
+		{"v2 udp", proxyHeaderV2(proxyProtocolV2Proxy, 0x12, tcp4), "", true},
+		{"v2 truncated", proxyHeaderV2(proxyProtocolV2Proxy, proxyProtocolV2TCP4, tcp4)[:20], "", true},
+		{"missing header", []byte("\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03\x00"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst, err := readProxyHeader(bufio.NewReader(bytes.NewReader(tt.header)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("accepted header %q", tt.header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.src == "" {
+				if src != nil || dst != nil {
+					t.Fatalf("addresses %s %s, want none", src, dst)
+				}
+				return
+			}
+			if src == nil || src.String() != tt.src {
+				t.Fatalf("source %v, want %s", src, tt.src)
+			}
+		})
+	}
+}
+
+func TestWriteProxyHeaderErrors(t *testing.T) {
+
+	tcp := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}
+	udp := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}
+
+	var buf bytes.Buffer
+	if writeProxyHeader(&buf, ProxyProtocolV1, udp, tcp) == nil {
+		t.Fatal("accepted udp source address")
+	}
+	if writeProxyHeader(&buf, "v3", tcp, tcp) == nil {
+		t.Fatal("accepted unknown version")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("wrote %d bytes on error", buf.Len())
+	}
+}
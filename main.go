@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,15 +14,28 @@ import (
 
 	// "crypto/tls"
 
+	h "proxy/harness"
 	l "proxy/listen"
 	p "proxy/parser"
+	pr "proxy/prover"
 	u "proxy/utils"
 	v "proxy/verifier"
 
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
+// maximum age of a session between capture and postprocessing
+// and between postprocessing and proof submission
+var maxCaptureAge time.Duration
+var maxProofAge time.Duration
+
+// proof system of the oracle circuits
+var backend string
+
 func main() {
 
 	// logging settings
@@ -38,6 +54,53 @@ func main() {
 	proxyListenerURL := flag.String("proxylistener", "", "URL of the proxy server")
 	proxyServerURL := flag.String("proxyserver", "", "URL of the proxy server")
 
+	// PROXY protocol when running behind a load balancer
+	acceptProxyProtocol := flag.Bool("proxyprotocol", false, "expect PROXY protocol headers on inbound connections.")
+	upstreamProxyProtocol := flag.String("upstreamproxyprotocol", "", "send PROXY protocol headers (v1 or v2) to upstream servers.")
+
+	// freshness of captured sessions, zero disables the check
+	flag.DurationVar(&maxCaptureAge, "maxcaptureage", 10*time.Minute, "maximum age of a captured session at postprocessing.")
+	flag.DurationVar(&maxProofAge, "maxproofage", 30*time.Minute, "maximum time between postprocessing and proof submission.")
+
+	// proof system, plonk uses the universal srs
+	flag.StringVar(&backend, "backend", "groth16", "proof system of the circuits (groth16 or plonk).")
+	flag.StringVar(&v.SRSPath, "srs", v.SRSPath, "path of the universal kzg srs used by plonk.")
+	curve := flag.String("curve", "bn254", "curve of the circuits (bn254, bls12_381 or bls12_377).")
+
+	// circuit, input and output file of the commands below
+	circuit := flag.String("circuit", v.OracleCircuit, "circuit of the ceremony, solidity, calldata and snarkjs commands.")
+	in := flag.String("in", "", "input file of the ceremony and snarkjs commands.")
+	out := flag.String("out", "", "output file of the ceremony, snarkjs, batch, aggregate and prove commands, all but ceremony and snarkjs print to stdout if empty.")
+
+	// groth16 phase-2 ceremony
+	ceremony := flag.String("ceremony", "", "phase-2 ceremony action: init, contribute, add, finalize or verify, contributions are read from -in and written to -out.")
+	phase1 := flag.String("phase1", "", "phase-1 (powers of tau) file the ceremony is initialized from.")
+	contributor := flag.String("contributor", "", "name of the ceremony participant.")
+	flag.BoolVar(&v.RequireCeremony, "requireceremony", false, "refuse groth16 keys not generated by a ceremony.")
+
+	// on-chain verification
+	solidity := flag.String("solidity", "", "writes the solidity verifier of the circuit to the given file.")
+	calldata := flag.Bool("calldata", false, "prints the verifyProof calldata of the stored proof of the circuit.")
+
+	// snarkjs interchange
+	snarkjs := flag.String("snarkjs", "", "snarkjs json action: exportvk, importvk or exportproof, using -in and -out.")
+
+	// re-verification of stored proofs
+	batch := flag.String("batch", "", "verifies the proofs of the given batch file and writes the results to -out.")
+	workers := flag.Int("workers", 0, "number of concurrent batch verifications, defaults to the number of cpus.")
+
+	// recursive aggregation of bls12_377 groth16 proofs
+	aggregate := flag.String("aggregate", "", "aggregates the proofs of the given batch file into one proof written to -out.")
+	verifyAggregate := flag.String("verifyaggregate", "", "verifies the given aggregate file.")
+
+	// reference prover driving a session through a running proxy
+	prove := flag.String("prove", "", "requests the https url through the proxy and proves the response with the reference prover, the result is written to -out.")
+	substring := flag.String("substring", "\"price\"", "json field name whose number value is proven by the reference prover.")
+	flag.StringVar(&p.CAPath, "ca", p.CAPath, "ca certificate trusted besides the system roots by the parser and the reference prover.")
+
+	// deterministic local tls test harness, the proxy trusts its certificates with -ca ./certs/harness/ca.crt
+	harness := flag.Bool("harness", false, "serves the harness server on localhost:8081.")
+
 	// parse all flags
 	flag.Parse()
 
@@ -50,11 +113,100 @@ func main() {
 	// activated check
 	log.Debug().Msg("Debugging activated.")
 
+	err := v.SetCurve(*curve)
+	if err != nil {
+		log.Error().Err(err).Msg("v.SetCurve()")
+		return
+	}
+	err = v.CheckBackend(backend)
+	if err != nil {
+		log.Error().Err(err).Msg("v.CheckBackend()")
+		return
+	}
+	err = v.CheckCircuit(*circuit)
+	if err != nil {
+		log.Error().Err(err).Msg("v.CheckCircuit()")
+		return
+	}
+
+	// ceremony actions run without the proxy
+	if *ceremony != "" {
+		err := runCeremony(*ceremony, *circuit, *phase1, *contributor, *in, *out)
+		if err != nil {
+			log.Error().Err(err).Msg("runCeremony()")
+		}
+		return
+	}
+
+	if *solidity != "" {
+		err := exportSolidity(*circuit, *solidity)
+		if err != nil {
+			log.Error().Err(err).Msg("exportSolidity()")
+		}
+		return
+	}
+	if *calldata {
+		err := printCalldata(*circuit)
+		if err != nil {
+			log.Error().Err(err).Msg("printCalldata()")
+		}
+		return
+	}
+
+	if *snarkjs != "" {
+		err := runSnarkJS(*snarkjs, *circuit, *in, *out)
+		if err != nil {
+			log.Error().Err(err).Msg("runSnarkJS()")
+		}
+		return
+	}
+
+	if *batch != "" {
+		err := runBatch(*batch, *workers, *out)
+		if err != nil {
+			log.Error().Err(err).Msg("runBatch()")
+		}
+		return
+	}
+
+	if *aggregate != "" {
+		err := runAggregate(*aggregate, *out)
+		if err != nil {
+			log.Error().Err(err).Msg("runAggregate()")
+		}
+		return
+	}
+	if *verifyAggregate != "" {
+		err := runVerifyAggregate(*verifyAggregate)
+		if err != nil {
+			log.Error().Err(err).Msg("runVerifyAggregate()")
+		}
+		return
+	}
+
+	if *prove != "" {
+		err := runProve(*prove, *substring, p.CAPath, *proxyListenerURL, *proxyServerURL, *out)
+		if err != nil {
+			log.Error().Err(err).Msg("runProve()")
+		}
+		return
+	}
+
+	if *harness {
+		err := serveHarness()
+		if err != nil {
+			log.Error().Err(err).Msg("serveHarness()")
+		}
+		return
+	}
+
 	// start proxy in listener mode
 	if *listen {
 		// Start the listener in a separate Goroutine
 		go func() {
 			listener := l.NewListener(*proxyListenerURL)
+			listener.AcceptProxyProtocol = *acceptProxyProtocol
+			listener.UpstreamProxyProtocol = *upstreamProxyProtocol
 			err := listener.Listen()
 			if err != nil {
 				log.Error().Err(err).Msg("listener.Listen()")
@@ -70,7 +222,11 @@ func main() {
 
 	// additional stats
 	if *stats {
-		err := u.TrascriptStats()
+		err := u.TrascriptStats(
+			v.ArtifactPath(v.OracleCircuit, backend, ".ccs"),
+			v.ArtifactPath(v.OracleCircuit, backend, ".pk"),
+			v.ArtifactPath(v.OracleCircuit, backend, ".vk"),
+		)
 		if err != nil {
 			log.Error().Msg("u.TrascriptStats()")
 			return
@@ -81,16 +237,138 @@ func main() {
 
 // startServer initializes the HTTP server and routes
 func startServer(proxyServerURL string) {
-	http.HandleFunc("/postprocess", postprocessAndSetupHandler)
-	http.HandleFunc("/verify", verifyHandler)
+	// reject an invalid policy before serving
+	_, err := v.ReadPolicy()
+	if err != nil {
+		log.Fatal().Err(err).Msg("v.ReadPolicy()")
+	}
 
 	log.Info().Msg("HTTP Server started at " + proxyServerURL)
-	err := http.ListenAndServe(proxyServerURL, nil)
+	err = http.ListenAndServe(proxyServerURL, routes())
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to start the HTTP server")
 	}
 }
 
+// routes of the proxy http server
+func routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/session", sessionHandler)
+	mux.HandleFunc("/postprocess", postprocessAndSetupHandler)
+	mux.HandleFunc("/verify", verifyHandler)
+	mux.HandleFunc("/setup-request", setupRequestHandler)
+	mux.HandleFunc("/verify-request", verifyRequestHandler)
+	mux.HandleFunc("/ceremony", ceremonyHandler)
+	mux.HandleFunc("/solidity", solidityHandler)
+	mux.HandleFunc("/verifying-key", verifyingKeyHandler)
+	mux.HandleFunc("/verify-batch", verifyBatchHandler)
+	mux.HandleFunc("/verify-aggregate", verifyAggregateHandler)
+
+	return mux
+}
+
+// returns nonce and capture time of the last captured session
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := u.ReadSession()
+	if err != nil {
+		respondWithError(w, "u.ReadSession()", err)
+		return
+	}
+
+	body, err := json.Marshal(session)
+	if err != nil {
+		respondWithError(w, "json.Marshal(session)", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// runs a phase-2 ceremony action. participants contribute offline to the
+// parameters they received, the coordinator verifies and adds contributions.
+func runCeremony(action string, circuit string, phase1 string, contributor string, in string, out string) error {
+
+	switch action {
+	case "init":
+		return v.InitCeremony(circuit, phase1)
+
+	case "contribute":
+		r, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		w, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		return v.ContributePhase2(r, w)
+
+	case "add":
+		r, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		contribution, err := v.AddContribution(circuit, contributor, r)
+		if err != nil {
+			return err
+		}
+		log.Info().Int("index", contribution.Index).Str("hash", contribution.Hash).Msg("contribution added")
+		return nil
+
+	case "finalize":
+		return v.FinalizeCeremony(circuit)
+
+	case "verify":
+		// the ceremony directory may have been downloaded for auditing
+		dir := in
+		if dir == "" {
+			dir = v.CeremonyDir + "/" + circuit
+		}
+		err := v.VerifyCeremony(dir)
+		if err != nil {
+			return err
+		}
+		log.Info().Str("dir", dir).Msg("ceremony verified")
+		return nil
+	}
+
+	return fmt.Errorf("unknown ceremony action %q", action)
+}
+
+// returns the circuit named in the query, the oracle circuit by default
+func circuitParam(r *http.Request) (string, error) {
+	circuit := r.URL.Query().Get("circuit")
+	if circuit == "" {
+		return v.OracleCircuit, nil
+	}
+	return circuit, v.CheckCircuit(circuit)
+}
+
+// returns the latest ceremony parameters. contributions are added by the
+// coordinator with -ceremony add, the server takes none.
+func ceremonyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	circuit, err := circuitParam(r)
+	if err != nil {
+		respondWithError(w, "circuitParam()", err)
+		return
+	}
+
+	path, err := v.LatestContribution(circuit)
+	if err != nil {
+		respondWithError(w, "v.LatestContribution()", err)
+		return
+	}
+	http.ServeFile(w, r, path)
+}
+
 func postprocessAndSetupHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debug().Msg("Starting postprocessAndSetupHandler()!")
 
@@ -135,6 +413,22 @@ func postprocessHandler(r *http.Request) ([]byte, error) {
 		return nil, fmt.Errorf("Error unmarshalling combined JSON data")
 	}
 
+	// transcripts must stem from a recently captured session
+	session, err := u.ReadSession()
+	if err != nil {
+		return nil, fmt.Errorf("u.ReadSession()")
+	}
+	if combinedData.SessionNonce == "" {
+		return nil, fmt.Errorf("missing session nonce")
+	}
+	if combinedData.SessionNonce != session.Nonce {
+		return nil, fmt.Errorf("session nonce does not match the captured session")
+	}
+	err = u.CheckAge("session captured", session.CapturedAt, maxCaptureAge)
+	if err != nil {
+		return nil, err
+	}
+
 	// Save each component to a file in /local_storage
 	err = u.SaveJSONToFile("kdc_shared.json", combinedData.KDCShared)
 	if err != nil {
@@ -156,6 +450,53 @@ func postprocessHandler(r *http.Request) ([]byte, error) {
 		return nil, fmt.Errorf("Failed to save kdc_public_input.json")
 	}
 
+	// request proof inputs are optional
+	hasRequest := combinedData.RecordTagClientPublic != nil && combinedData.RecordDataClientPublic != nil
+	if hasRequest {
+		err = u.SaveJSONToFile("recordtag_client_public_input.json", combinedData.RecordTagClientPublic)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to save recordtag_client_public_input.json")
+		}
+
+		err = u.SaveJSONToFile("recorddata_client_public_input.json", combinedData.RecordDataClientPublic)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to save recorddata_client_public_input.json")
+		}
+	} else {
+		// request inputs of an earlier session must not be proven again
+		err = u.SaveOptionalJSONToFile("recordtag_client_public_input.json", nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to remove recordtag_client_public_input.json")
+		}
+
+		err = u.SaveOptionalJSONToFile("recorddata_client_public_input.json", nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to remove recorddata_client_public_input.json")
+		}
+	}
+
+	// the request record is confirmed again by the parser
+	err = u.SaveOptionalJSONToFile("record_client_confirmed.json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to remove record_client_confirmed.json")
+	}
+
+	// optional disclosures for response completeness checks
+	err = u.SaveOptionalJSONToFile("closenotify_public_input.json", combinedData.CloseNotifyPublic)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to save closenotify_public_input.json")
+	}
+
+	err = u.SaveOptionalJSONToFile("http_public_input.json", combinedData.HTTPPublic)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to save http_public_input.json")
+	}
+
+	err = u.SaveOptionalJSONToFile("ech_public_input.json", combinedData.ECHPublic)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to save ech_public_input.json")
+	}
+
 	log.Debug().Msg("All files sent by client stored successfully!")
 
 	// initialize parser
@@ -209,6 +550,40 @@ func postprocessHandler(r *http.Request) ([]byte, error) {
 		return nil, fmt.Errorf("parser.CheckAuthTag()")
 	}
 
+	// detect close_notify and check http framing against captured records
+	err = parser.CheckResponseComplete()
+	if err != nil {
+		return nil, fmt.Errorf("parser.CheckResponseComplete()")
+	}
+
+	// store results of transcript checks
+	err = parser.StoreSessionVerdict()
+	if err != nil {
+		return nil, fmt.Errorf("parser.StoreSessionVerdict()")
+	}
+
+	if hasRequest {
+		// read client record parameters of the request
+		crps, err := parser.ReadClientRecordParams()
+		if err != nil {
+			return nil, fmt.Errorf("parser.ReadClientRecordParams()")
+		}
+
+		// verify request authtag and store confirmed parameters
+		err = parser.CheckClientAuthTags(crps)
+		if err != nil {
+			return nil, fmt.Errorf("parser.CheckClientAuthTags()")
+		}
+	}
+
+	// start of the proof submission window
+	postprocessedAt := time.Now().UTC()
+	session.PostprocessedAt = &postprocessedAt
+	err = u.StoreSession(session)
+	if err != nil {
+		return nil, fmt.Errorf("u.StoreSession()")
+	}
+
 	elapsed := time.Since(start)
 	log.Debug().Str("elapsed", elapsed.String()).Msg("proxy postprocess time.")
 
@@ -221,26 +596,44 @@ func setupHandler(r *http.Request) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	return setupCircuit(v.OracleCircuit, circuit)
+}
+
+// compiles the request circuit and returns its proving key
+func setupRequestHandler(w http.ResponseWriter, r *http.Request) {
+	circuit, err := v.GetRequestCircuit()
+	if err != nil {
+		respondWithError(w, "v.GetRequestCircuit()", err)
+		return
+	}
 
-	backend := "groth16"
-	ccs, err := v.CompileCircuit(backend, circuit)
+	body, err := setupCircuit(v.RequestCircuit, circuit)
+	if err != nil {
+		respondWithError(w, "Setup Error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func setupCircuit(name string, circuit frontend.Circuit) ([]byte, error) {
+	ccs, err := v.CompileCircuit(backend, name, circuit)
 	if err != nil {
 		return nil, err
 	}
 
-	err = v.ComputeSetup(backend, ccs)
+	err = v.ComputeSetup(backend, name, ccs)
 	if err != nil {
 		return nil, err
 	}
 
-	pkpath := "local_storage/circuits/oracle_" + backend + ".pk"
-	_pk, err := os.ReadFile(pkpath)
+	_pk, err := os.ReadFile(v.ArtifactPath(name, backend, ".pk"))
 
 	return _pk, nil
 }
 
 func verifyHandler(w http.ResponseWriter, r *http.Request) {
-	backend := "groth16"
 
 	// Read the proof data from the request body
 	proofData, err := io.ReadAll(r.Body)
@@ -252,14 +645,40 @@ func verifyHandler(w http.ResponseWriter, r *http.Request) {
 	// NEW: Log the size of received proof data and its first few bytes
 	log.Debug().Int("bytesReceived", len(proofData)).Msg("Total size of proof received from client.")
 
+	// proofs may be submitted as snarkjs json
+	proofData, err = decodeProof(proofData)
+	if err != nil {
+		respondWithError(w, "decodeProof()", err)
+		return
+	}
+
+	// proofs must be submitted in time
+	session, err := readFreshSession()
+	if err != nil {
+		respondWithError(w, "readFreshSession()", err)
+		return
+	}
+
 	// Write the proof data to the desired file
-	proofFilePath := "local_storage/circuits/oracle_" + backend + ".proof"
+	proofFilePath := v.ArtifactPath(v.OracleCircuit, backend, ".proof")
 	err = os.WriteFile(proofFilePath, proofData, 0644)
 	if err != nil {
 		respondWithError(w, "Failed to write proof data to file", err)
 		return
 	}
 
+	// session must satisfy the verifier policy
+	policy, err := v.ReadPolicy()
+	if err != nil {
+		respondWithError(w, "v.ReadPolicy()", err)
+		return
+	}
+	err = v.CheckPolicy(policy)
+	if err != nil {
+		respondWithError(w, "v.CheckPolicy()", err)
+		return
+	}
+
 	// circuit should be parsed because it's compiled by a trusted third-party.
 	assignment, err := v.ComputeWitness()
 	if err != nil {
@@ -267,14 +686,488 @@ func verifyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = v.VerifyCircuit(backend, assignment)
+	vkHash, err := v.VerifyCircuit(backend, v.OracleCircuit, assignment)
+	if err != nil {
+		respondWithError(w, "v.VerifyCircuit()", err)
+		return
+	}
+
+	// mark session as consumed
+	nullifier, err := consumeSession(v.OracleCircuit, session, assignment, vkHash, policy)
+	if err != nil {
+		respondWithError(w, "consumeSession()", err)
+		return
+	}
+
+	respondWithResult(w, verificationResult{
+		Status:        "Verification completed",
+		Nullifier:     nullifier,
+		Session:       session,
+		Calldata:      solidityCalldata(v.OracleCircuit, assignment),
+		PublicSignals: publicSignals(assignment),
+	})
+}
+
+// verifies the proof over the client request and returns the bound request line
+func verifyRequestHandler(w http.ResponseWriter, r *http.Request) {
+
+	proofData, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondWithError(w, "Failed to read proof data from request", err)
+		return
+	}
+	proofData, err = decodeProof(proofData)
+	if err != nil {
+		respondWithError(w, "decodeProof()", err)
+		return
+	}
+
+	session, err := readFreshSession()
+	if err != nil {
+		respondWithError(w, "readFreshSession()", err)
+		return
+	}
+
+	// the request is bound to the response proof verified for this session,
+	// both prove over the same proxy derived session secrets
+	responseNullifier, ok, err := v.ConsumedWitness(v.OracleCircuit, session.Nonce)
+	if err != nil {
+		respondWithError(w, "v.ConsumedWitness()", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, "v.ConsumedWitness()", fmt.Errorf("response of session %s has not been verified", session.Nonce))
+		return
+	}
+
+	proofFilePath := v.ArtifactPath(v.RequestCircuit, backend, ".proof")
+	err = os.WriteFile(proofFilePath, proofData, 0644)
+	if err != nil {
+		respondWithError(w, "Failed to write proof data to file", err)
+		return
+	}
+
+	// the proven substring must be a well formed request line
+	requestLine, err := v.ReadRequestLine()
+	if err != nil {
+		respondWithError(w, "v.ReadRequestLine()", err)
+		return
+	}
+
+	assignment, err := v.ComputeRequestWitness()
+	if err != nil {
+		respondWithError(w, "v.ComputeRequestWitness()", err)
+		return
+	}
+
+	vkHash, err := v.VerifyCircuit(backend, v.RequestCircuit, assignment)
 	if err != nil {
 		respondWithError(w, "v.VerifyCircuit()", err)
 		return
 	}
 
+	policy, err := v.ReadPolicy()
+	if err != nil {
+		respondWithError(w, "v.ReadPolicy()", err)
+		return
+	}
+	nullifier, err := consumeSession(v.RequestCircuit, session, assignment, vkHash, policy)
+	if err != nil {
+		respondWithError(w, "consumeSession()", err)
+		return
+	}
+
+	respondWithResult(w, verificationResult{
+		Status:        "Verification completed",
+		RequestLine:   &requestLine,
+		Nullifier:     nullifier,
+		Response:      responseNullifier,
+		Session:       session,
+		Calldata:      solidityCalldata(v.RequestCircuit, assignment),
+		PublicSignals: publicSignals(assignment),
+	})
+}
+
+// verificationResult is returned by the verify endpoints, request line
+// and response nullifier are only set for proofs over the client request
+type verificationResult struct {
+	Status string `json:"status"`
+	*v.RequestLine
+	// hash of the public witness recorded in the nullifier registry
+	Nullifier string    `json:"nullifier"`
+	Session   u.Session `json:"session"`
+	// nullifier of the response proof a request proof is bound to
+	Response string `json:"response_nullifier,omitempty"`
+	// verifyProof call of the solidity verifier and the public inputs as
+	// snarkjs public signals, groth16 on bn254 only
+	Calldata      string   `json:"calldata,omitempty"`
+	PublicSignals []string `json:"public_signals,omitempty"`
+}
+
+// returns the hex encoded calldata of a verified proof if it can be
+// verified on-chain
+func solidityCalldata(circuit string, publicWitness witness.Witness) string {
+	if backend != "groth16" || v.Curve != ecc.BN254 {
+		return ""
+	}
+	calldata, err := v.Calldata(circuit, publicWitness)
+	if err != nil {
+		log.Debug().Err(err).Msg("v.Calldata()")
+		return ""
+	}
+	return "0x" + hex.EncodeToString(calldata)
+}
+
+// returns the public inputs as snarkjs public signals
+func publicSignals(publicWitness witness.Witness) []string {
+	if backend != "groth16" || v.Curve != ecc.BN254 {
+		return nil
+	}
+	signals, err := v.PublicSignals(publicWitness)
+	if err != nil {
+		log.Debug().Err(err).Msg("v.PublicSignals()")
+		return nil
+	}
+	return signals
+}
+
+// converts snarkjs json proofs to the gnark encoding, other proofs are
+// passed through
+func decodeProof(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return data, nil
+	}
+	if backend != "groth16" {
+		return nil, fmt.Errorf("snarkjs proofs require groth16, backend is %s", backend)
+	}
+	return v.ProofFromSnarkJS(data)
+}
+
+// converts verifying keys and proofs of the circuit from and to snarkjs json
+func runSnarkJS(action string, circuit string, in string, out string) error {
+	var data []byte
+	var err error
+	switch action {
+	case "exportvk":
+		data, err = v.VerifyingKeyToSnarkJS(circuit)
+	case "exportproof":
+		data, err = v.ProofToSnarkJS(circuit)
+	case "importvk":
+		data, err = os.ReadFile(in)
+		if err != nil {
+			return err
+		}
+		return v.VerifyingKeyFromSnarkJS(circuit, data)
+	default:
+		return fmt.Errorf("unknown snarkjs action %q", action)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, data, 0644)
+}
+
+// returns the verifying key of the circuit as snarkjs verification key
+func verifyingKeyHandler(w http.ResponseWriter, r *http.Request) {
+	circuit, err := circuitParam(r)
+	if err != nil {
+		respondWithError(w, "circuitParam()", err)
+		return
+	}
+
+	body, err := v.VerifyingKeyToSnarkJS(circuit)
+	if err != nil {
+		respondWithError(w, "v.VerifyingKeyToSnarkJS()", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// writes the solidity verifier of the circuit
+func exportSolidity(circuit string, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return v.ExportSolidity(circuit, f)
+}
+
+// verifies the stored proof of the postprocessed session and prints the
+// calldata of the verifyProof call
+func printCalldata(circuit string) error {
+	var assignment witness.Witness
+	var err error
+	if circuit == v.RequestCircuit {
+		assignment, err = v.ComputeRequestWitness()
+	} else {
+		assignment, err = v.ComputeWitness()
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = v.VerifyCircuit("groth16", circuit, assignment)
+	if err != nil {
+		return err
+	}
+
+	calldata, err := v.Calldata(circuit, assignment)
+	if err != nil {
+		return err
+	}
+	fmt.Println("0x" + hex.EncodeToString(calldata))
+	return nil
+}
+
+// returns the solidity verifier of the circuit
+func solidityHandler(w http.ResponseWriter, r *http.Request) {
+	circuit, err := circuitParam(r)
+	if err != nil {
+		respondWithError(w, "circuitParam()", err)
+		return
+	}
+
+	var contract bytes.Buffer
+	err = v.ExportSolidity(circuit, &contract)
+	if err != nil {
+		respondWithError(w, "v.ExportSolidity()", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Verification completed"))
+	w.Write(contract.Bytes())
+}
+
+// batchResults is returned by the batch verification endpoint
+type batchResults struct {
+	Status  string          `json:"status"`
+	Valid   int             `json:"valid"`
+	Invalid int             `json:"invalid"`
+	Results []v.BatchResult `json:"results"`
+}
+
+func verifyBatch(batch v.Batch, workers int) (batchResults, error) {
+	if batch.Circuit == "" {
+		batch.Circuit = v.OracleCircuit
+	}
+	results, err := v.VerifyBatch(backend, batch, workers)
+	if err != nil {
+		return batchResults{}, err
+	}
+
+	summary := batchResults{Status: "Batch verification completed", Results: results}
+	for _, result := range results {
+		if result.Valid {
+			summary.Valid++
+		} else {
+			summary.Invalid++
+		}
+	}
+	log.Info().Int("valid", summary.Valid).Int("invalid", summary.Invalid).Msg("batch verified")
+	return summary, nil
+}
+
+// verifies the proofs of a batch file and writes the results to out or stdout
+func runBatch(path string, workers int, out string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var batch v.Batch
+	err = json.Unmarshal(data, &batch)
+	if err != nil {
+		return err
+	}
+
+	summary, err := verifyBatch(batch, workers)
+	if err != nil {
+		return err
+	}
+	body, err := json.MarshalIndent(summary, "", " ")
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		fmt.Println(string(body))
+		return nil
+	}
+	return os.WriteFile(out, body, 0644)
+}
+
+// verifies stored proofs of a circuit without consuming sessions, invalid
+// proofs are reported per item. proofs are always checked against the
+// stored verifying key of the circuit.
+func verifyBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var batch v.Batch
+	err := json.NewDecoder(r.Body).Decode(&batch)
+	if err != nil {
+		respondWithError(w, "json.Decode(batch)", err)
+		return
+	}
+	if batch.VerifyingKey != "" {
+		respondWithError(w, "verifyBatchHandler()", errors.New("verifying_key is only accepted with -batch"))
+		return
+	}
+
+	summary, err := verifyBatch(batch, 0)
+	if err != nil {
+		respondWithError(w, "v.VerifyBatch()", err)
+		return
+	}
+
+	body, err := json.Marshal(summary)
+	if err != nil {
+		respondWithError(w, "json.Marshal(summary)", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// aggregates the proofs of a batch file and writes the aggregate to out or
+// stdout
+func runAggregate(path string, out string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var batch v.Batch
+	err = json.Unmarshal(data, &batch)
+	if err != nil {
+		return err
+	}
+	if batch.Circuit == "" {
+		batch.Circuit = v.OracleCircuit
+	}
+
+	aggregate, err := v.AggregateBatch(batch)
+	if err != nil {
+		return err
+	}
+	body, err := json.MarshalIndent(aggregate, "", " ")
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		fmt.Println(string(body))
+		return nil
+	}
+	return os.WriteFile(out, body, 0644)
+}
+
+func runVerifyAggregate(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var aggregate v.Aggregate
+	err = json.Unmarshal(data, &aggregate)
+	if err != nil {
+		return err
+	}
+
+	err = v.VerifyAggregate(aggregate)
+	if err != nil {
+		return err
+	}
+	log.Info().Int("proofs", len(aggregate.Inputs)).Msg("aggregate verified")
+	return nil
+}
+
+// proves a session with the reference prover and writes the inputs, proof
+// and verification result to out or stdout
+func runProve(target string, substring string, caPath string, listener string, server string, out string) error {
+	roots, err := pr.Roots(caPath)
+	if err != nil {
+		return err
+	}
+
+	result, err := pr.Run(pr.Options{
+		Listener:  listener,
+		Server:    server,
+		URL:       target,
+		Substring: substring,
+		Backend:   backend,
+		Config:    pr.Config{Roots: roots},
+	})
+	if err != nil {
+		return err
+	}
+	body, err := json.MarshalIndent(result, "", " ")
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		fmt.Println(string(body))
+		return nil
+	}
+	return os.WriteFile(out, body, 0644)
+}
+
+func verifyAggregateHandler(w http.ResponseWriter, r *http.Request) {
+	var aggregate v.Aggregate
+	err := json.NewDecoder(r.Body).Decode(&aggregate)
+	if err != nil {
+		respondWithError(w, "json.Decode(aggregate)", err)
+		return
+	}
+
+	err = v.VerifyAggregate(aggregate)
+	if err != nil {
+		respondWithError(w, "v.VerifyAggregate()", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status": "Aggregate verified"}`))
+}
+
+// records the verified session in the nullifier registry
+func consumeSession(circuit string, session u.Session, publicWitness witness.Witness, vkHash string, policy v.Policy) (string, error) {
+	witnessHash, err := v.WitnessHash(publicWitness)
+	if err != nil {
+		return "", err
+	}
+	err = v.ConsumeSession(circuit, session.Nonce, witnessHash, vkHash, policy.Reverify)
+	return witnessHash, err
+}
+
+// reads the postprocessed session and checks the proof submission window
+func readFreshSession() (u.Session, error) {
+	session, err := u.ReadSession()
+	if err != nil {
+		return session, err
+	}
+	if session.PostprocessedAt == nil {
+		return session, fmt.Errorf("session has not been postprocessed")
+	}
+	err = u.CheckAge("session postprocessed", *session.PostprocessedAt, maxProofAge)
+	return session, err
+}
+
+// stores the verification time and responds with the result
+func respondWithResult(w http.ResponseWriter, result verificationResult) {
+	verifiedAt := time.Now().UTC()
+	result.Session.VerifiedAt = &verifiedAt
+	err := u.StoreSession(result.Session)
+	if err != nil {
+		respondWithError(w, "u.StoreSession()", err)
+		return
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		respondWithError(w, "json.Marshal(result)", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
 }
 
 func respondWithError(w http.ResponseWriter, logMsg string, err error) {
@@ -282,3 +1175,14 @@ func respondWithError(w http.ResponseWriter, logMsg string, err error) {
 	w.WriteHeader(http.StatusInternalServerError)
 	w.Write([]byte(`{"status": "error", "message": "` + logMsg + `: ` + err.Error() + `"}`))
 }
+
+// serves the harness server until it fails, sessions are driven through
+// the proxy by the reference prover with -prove
+func serveHarness() error {
+	srv, err := h.Listen(h.Addr, h.Config{})
+	if err != nil {
+		return err
+	}
+	log.Info().Msg("harness server started at " + srv.Addr().String())
+	return srv.Serve()
+}
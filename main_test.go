@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	h "proxy/harness"
+	l "proxy/listen"
+	p "proxy/parser"
+	pr "proxy/prover"
+	u "proxy/utils"
+)
+
+// url requested through the proxy and the json field proven by the
+// reference prover, the harness server answers every request with
+// h.DefaultResponse
+const (
+	testURL       = "https://localhost/price"
+	testSubstring = `"price"`
+)
+
+// chdirProxy runs the test in an empty directory with the local storage
+// layout of the proxy and the harness certificates
+func chdirProxy(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = os.MkdirAll(filepath.Join(dir, "local_storage", "circuits"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(dir, h.CertPath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ca.crt", "localhost.crt", "localhost.key"} {
+		data, err := os.ReadFile(filepath.Join(wd, h.CertPath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(dir, h.CertPath, name), data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// startProxy starts the proxy listener, which routes the server name
+// localhost to harnessPort, and the proxy http server. it returns the
+// addresses of both.
+func startProxy(t *testing.T, harnessPort string) (string, string) {
+	t.Helper()
+
+	chdirProxy(t)
+	caPath, backendName := p.CAPath, backend
+	t.Cleanup(func() {
+		p.CAPath = caPath
+		backend = backendName
+	})
+	p.CAPath = filepath.Join(h.CertPath, "ca.crt")
+	backend = "groth16"
+
+	// the listener does not report its address, reserve a free port for it
+	addr := freeAddr(t)
+	listener := l.NewListener(addr)
+	listener.LocalhostPort = harnessPort
+	go listener.Listen()
+
+	// the listener stops at connections without a client hello, so it is
+	// given a moment to start as in main instead of being probed
+	time.Sleep(1 * time.Second)
+
+	server := httptest.NewServer(routes())
+	t.Cleanup(server.Close)
+
+	return addr, server.Listener.Addr().String()
+}
+
+// freeAddr returns a local address with a port no one listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startHarness serves the harness server on addr. stop closes the server
+// and waits for it to finish the current connection.
+func startHarness(t *testing.T, addr string, config h.Config) (srv *h.Server, stop func()) {
+	t.Helper()
+
+	srv, err := h.Listen(addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve()
+	}()
+
+	return srv, func() {
+		srv.Close()
+		<-served
+	}
+}
+
+// the reference prover drives a session through the proxy against the
+// harness server and the proxy accepts its proof
+func TestProve(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("proves the oracle circuit")
+	}
+
+	srv, stop := startHarness(t, "localhost:0", h.Config{})
+	defer stop()
+	_, port, err := net.SplitHostPort(srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, server := startProxy(t, port)
+
+	roots, err := h.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := pr.Run(pr.Options{
+		Listener:  listener,
+		Server:    server,
+		URL:       testURL,
+		Substring: testSubstring,
+		Backend:   backend,
+		Config:    pr.Config{Roots: roots},
+	})
+	if err != nil {
+		t.Fatalf("pr.Run: %v", err)
+	}
+
+	var verification verificationResult
+	err = json.Unmarshal(result.Verification, &verification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification.Status != "Verification completed" {
+		t.Fatalf("verification status %q", verification.Status)
+	}
+	if verification.Nullifier == "" || verification.Session.Nonce != result.Session.Nonce {
+		t.Fatalf("verification of session %s with nullifier %q, want session %s", verification.Session.Nonce, verification.Nullifier, result.Session.Nonce)
+	}
+}
+
+// circuit names in queries must not leave the storage directory and the
+// ceremony takes no contributions over http
+func TestCircuitQuery(t *testing.T) {
+
+	chdirProxy(t)
+	tests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodGet, "/ceremony?circuit=../../ceremony", http.StatusInternalServerError},
+		{http.MethodPost, "/ceremony?contributor=anyone", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/verifying-key?circuit=../oracle", http.StatusInternalServerError},
+		{http.MethodGet, "/solidity?circuit=/etc/passwd", http.StatusInternalServerError},
+		// aggregation runs a bw6-761 setup per batch size, it is command line only
+		{http.MethodPost, "/aggregate", http.StatusNotFound},
+	}
+
+	mux := routes()
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, w.Code, tt.status)
+		}
+		if tt.status == http.StatusInternalServerError && !strings.Contains(w.Body.String(), "unknown circuit") {
+			t.Errorf("%s %s: %s", tt.method, tt.target, w.Body.String())
+		}
+	}
+}
+
+// a postprocessed session without request proof inputs removes the request
+// inputs and the confirmed request of an earlier session
+func TestPostprocessRemovesStaleRequest(t *testing.T) {
+	chdirProxy(t)
+
+	session, err := u.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.StoreSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := []string{"recordtag_client_public_input.json", "recorddata_client_public_input.json", "record_client_confirmed.json"}
+	for _, name := range stale {
+		err = os.WriteFile(filepath.Join("local_storage", name), []byte("{}"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the parser fails on the empty transcript after the inputs are stored
+	body := `{"kdc_shared": {}, "recordtag_public": {}, "recorddata_public": {}, "kdc_public_input": {}, "session_nonce": "` + session.Nonce + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/postprocess", strings.NewReader(body))
+	_, err = postprocessHandler(r)
+	if err == nil {
+		t.Fatal("postprocessed an empty transcript")
+	}
+
+	for _, name := range stale {
+		_, err = os.Stat(filepath.Join("local_storage", name))
+		if !os.IsNotExist(err) {
+			t.Errorf("stale %s was kept", name)
+		}
+	}
+}
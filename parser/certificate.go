@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"proxy/internal/tls13"
+)
+
+// tls 1.3 signature schemes
+const (
+	ecdsaP256SHA256  uint16 = 0x0403
+	ecdsaP384SHA384  uint16 = 0x0503
+	ecdsaP521SHA512  uint16 = 0x0603
+	rsaPSSRSAESHA256 uint16 = 0x0804
+	rsaPSSRSAESHA384 uint16 = 0x0805
+	rsaPSSRSAESHA512 uint16 = 0x0806
+	schemeEd25519    uint16 = 0x0807
+	rsaPSSPSSSHA256  uint16 = 0x0809
+	rsaPSSPSSSHA384  uint16 = 0x080a
+	rsaPSSPSSSHA512  uint16 = 0x080b
+)
+
+// in tls 1.3 the ecdsa signature schemes also fix the curve of the key
+var ecdsaSchemeCurves = map[uint16]elliptic.Curve{
+	ecdsaP256SHA256: elliptic.P256(),
+	ecdsaP384SHA384: elliptic.P384(),
+	ecdsaP521SHA512: elliptic.P521(),
+}
+
+// context string of server certificate verify signatures, see RFC 8446 4.4.3
+const serverSignatureContext = "TLS 1.3, server CertificateVerify\x00"
+
+// verifyCertificateChain verifies the server certificate chain against the
+// trusted roots and the server name indicated by the client
+func verifyCertificateChain(certs []*x509.Certificate, roots *x509.CertPool, serverName string) error {
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       serverName,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+// verifyCertificateVerify checks the server signature over the handshake
+// transcript hash up to and including the certificate message
+func verifyCertificateVerify(leaf *x509.Certificate, scheme uint16, signature []byte, transcriptHash []byte) error {
+
+	signed := make([]byte, 0, 64+len(serverSignatureContext)+len(transcriptHash))
+	for i := 0; i < 64; i++ {
+		signed = append(signed, 0x20)
+	}
+	signed = append(signed, serverSignatureContext...)
+	signed = append(signed, transcriptHash...)
+
+	if scheme == schemeEd25519 {
+		pub, ok := leaf.PublicKey.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(pub, signed, signature) {
+			return errors.New("invalid ed25519 certificate verify signature")
+		}
+		return nil
+	}
+
+	var h crypto.Hash
+	switch scheme {
+	case ecdsaP256SHA256, rsaPSSRSAESHA256, rsaPSSPSSSHA256:
+		h = crypto.SHA256
+	case ecdsaP384SHA384, rsaPSSRSAESHA384, rsaPSSPSSSHA384:
+		h = crypto.SHA384
+	case ecdsaP521SHA512, rsaPSSRSAESHA512, rsaPSSPSSSHA512:
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature scheme %#04x", scheme)
+	}
+	digest := h.New()
+	digest.Write(signed)
+	hashed := digest.Sum(nil)
+
+	switch pub := leaf.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		curve, ok := ecdsaSchemeCurves[scheme]
+		if !ok || pub.Curve != curve {
+			return errors.New("signature scheme does not match ecdsa certificate key")
+		}
+		if !ecdsa.VerifyASN1(pub, hashed, signature) {
+			return errors.New("invalid ecdsa certificate verify signature")
+		}
+	case *rsa.PublicKey:
+		if _, ok := ecdsaSchemeCurves[scheme]; ok {
+			return errors.New("signature scheme does not match rsa certificate key")
+		}
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		err := rsa.VerifyPSS(pub, h, hashed, signature, opts)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported certificate public key")
+	}
+
+	return nil
+}
+
+// verifyFinished checks the server finished verify_data, which is the HMAC of
+// the transcript hash up to certificate verify under the finished key
+func verifyFinished(trafficSecret []byte, transcriptHash []byte, finished []byte) bool {
+
+	mac := suite.FinishedMAC(trafficSecret, transcriptHash)
+	return hmac.Equal(mac, finished[tls13.HandshakeHeaderLen:])
+}
@@ -0,0 +1,199 @@
+package parser
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCertificate issues a certificate for the names, a ca certificate if
+// names is empty. the certificate is self-signed if parent is nil.
+func testCertificate(t *testing.T, names []string, notAfter time.Time, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if len(names) == 0 {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestVerifyCertificateChain(t *testing.T) {
+
+	valid := time.Now().Add(time.Hour)
+	root, rootKey := testCertificate(t, nil, valid, nil, nil)
+	intermediate, intermediateKey := testCertificate(t, nil, valid, root, rootKey)
+	other, otherKey := testCertificate(t, nil, valid, nil, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	names := []string{"example.com"}
+	leaf, _ := testCertificate(t, names, valid, root, rootKey)
+	issued, _ := testCertificate(t, names, valid, intermediate, intermediateKey)
+	expired, _ := testCertificate(t, names, time.Now().Add(-time.Hour), root, rootKey)
+	untrusted, _ := testCertificate(t, names, valid, other, otherKey)
+
+	tests := []struct {
+		name       string
+		certs      []*x509.Certificate
+		serverName string
+		wantErr    bool
+	}{
+		{"valid leaf", []*x509.Certificate{leaf}, "example.com", false},
+		{"leaf issued by intermediate", []*x509.Certificate{issued, intermediate}, "example.com", false},
+		{"missing intermediate", []*x509.Certificate{issued}, "example.com", true},
+		{"wrong server name", []*x509.Certificate{leaf}, "example.org", true},
+		{"expired leaf", []*x509.Certificate{expired}, "example.com", true},
+		{"untrusted root", []*x509.Certificate{untrusted}, "example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyCertificateChain(tt.certs, roots, tt.serverName)
+			if tt.wantErr && err == nil {
+				t.Fatal("chain verified")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// signCertificateVerify signs the transcript hash as the server does, with
+// the given context string
+func signCertificateVerify(t *testing.T, key crypto.Signer, scheme uint16, context string, transcriptHash []byte) []byte {
+	t.Helper()
+
+	signed := append(bytes.Repeat([]byte{0x20}, 64), context...)
+	signed = append(signed, transcriptHash...)
+
+	var (
+		signature []byte
+		err       error
+	)
+	switch scheme {
+	case schemeEd25519:
+		signature, err = key.Sign(rand.Reader, signed, crypto.Hash(0))
+	case ecdsaP256SHA256, rsaPSSRSAESHA256:
+		h := sha256.Sum256(signed)
+		var opts crypto.SignerOpts = crypto.SHA256
+		if scheme == rsaPSSRSAESHA256 {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		}
+		signature, err = key.Sign(rand.Reader, h[:], opts)
+	case ecdsaP384SHA384:
+		h := crypto.SHA384.New()
+		h.Write(signed)
+		signature, err = key.Sign(rand.Reader, h.Sum(nil), crypto.SHA384)
+	default:
+		t.Fatalf("scheme %#04x", scheme)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func TestVerifyCertificateVerify(t *testing.T) {
+
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transcriptHash := bytes.Repeat([]byte{3}, 32)
+	clientContext := "TLS 1.3, client CertificateVerify\x00"
+
+	tests := []struct {
+		name string
+		key  crypto.Signer
+		// scheme the signature is created with and the scheme announced
+		signScheme uint16
+		scheme     uint16
+		context    string
+		tamper     bool
+		wantErr    bool
+	}{
+		{"ecdsa p256", p256, ecdsaP256SHA256, ecdsaP256SHA256, serverSignatureContext, false, false},
+		{"ecdsa p384", p384, ecdsaP384SHA384, ecdsaP384SHA384, serverSignatureContext, false, false},
+		{"rsa pss", rsaKey, rsaPSSRSAESHA256, rsaPSSRSAESHA256, serverSignatureContext, false, false},
+		{"ed25519", edKey, schemeEd25519, schemeEd25519, serverSignatureContext, false, false},
+		{"ecdsa scheme for rsa key", rsaKey, rsaPSSRSAESHA256, ecdsaP256SHA256, serverSignatureContext, false, true},
+		{"rsa scheme for ecdsa key", p256, ecdsaP256SHA256, rsaPSSRSAESHA256, serverSignatureContext, false, true},
+		{"ed25519 scheme for ecdsa key", p256, ecdsaP256SHA256, schemeEd25519, serverSignatureContext, false, true},
+		{"p384 scheme for p256 key", p256, ecdsaP256SHA256, ecdsaP384SHA384, serverSignatureContext, false, true},
+		{"unknown scheme", p256, ecdsaP256SHA256, 0x0201, serverSignatureContext, false, true},
+		{"client context string", p256, ecdsaP256SHA256, ecdsaP256SHA256, clientContext, false, true},
+		{"bad ecdsa signature", p256, ecdsaP256SHA256, ecdsaP256SHA256, serverSignatureContext, true, true},
+		{"bad rsa signature", rsaKey, rsaPSSRSAESHA256, rsaPSSRSAESHA256, serverSignatureContext, true, true},
+		{"bad ed25519 signature", edKey, schemeEd25519, schemeEd25519, serverSignatureContext, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature := signCertificateVerify(t, tt.key, tt.signScheme, tt.context, transcriptHash)
+			if tt.tamper {
+				signature[len(signature)-1] ^= 1
+			}
+			leaf := &x509.Certificate{PublicKey: tt.key.Public()}
+
+			err := verifyCertificateVerify(leaf, tt.scheme, signature, transcriptHash)
+			if tt.wantErr && err == nil {
+				t.Fatal("signature verified")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
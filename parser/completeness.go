@@ -0,0 +1,245 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"proxy/internal/tls13"
+	u "proxy/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// an encrypted close_notify carries alert level, alert description and the
+// inner content type, followed by the authentication tag
+var closeNotifyPlaintext = []byte{tls13.AlertLevelWarning, tls13.AlertCloseNotify, tls13.RecordTypeAlert}
+
+const closeNotifyRecordLen = 3 + gcmTagSize
+
+// close_notify verdicts. keystream and tag parameters of the alert record
+// are not bound to the proven key, so a decrypted alert is only asserted by
+// the client and a record of alert length is merely present.
+const (
+	CloseNotifyAsserted = "client_asserted"
+	CloseNotifyPresent  = "present"
+	CloseNotifyAbsent   = "absent"
+)
+
+// source of the completeness verdicts, which rest on values disclosed by the
+// client rather than on proven plaintext
+const completenessSource = "client_asserted"
+
+// CheckResponseComplete detects whether the server closed the connection
+// with a close_notify alert and checks the http framing declared by the
+// client against the captured response records. results are stored in
+// the session verdict and labelled as client asserted, they are
+// informational and no verifier policy can require them.
+func (p *Parser) CheckResponseComplete() error {
+
+	var err error
+	p.closeNotifyPI, err = readOptionalPI(p.closeNotifyPath)
+	if err != nil {
+		return err
+	}
+	p.httpPI, err = readOptionalPI(p.httpPath)
+	if err != nil {
+		return err
+	}
+
+	return p.checkResponseComplete()
+}
+
+// reads public input the client may omit, nil if the file does not exist
+func readOptionalPI(path string) (map[string]string, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return u.ReadM(path)
+}
+
+func (p *Parser) checkResponseComplete() error {
+
+	p.responseOffsets = make(map[string]string)
+
+	records := applicationRecords(p.serverRecords, p.handshake.serverHandshakeRecords)
+	if len(records) == 0 {
+		return errors.New("no server application records")
+	}
+
+	// the last server record may be a close_notify alert
+	closeNotify, err := p.checkCloseNotify(records[len(records)-1])
+	if err != nil {
+		log.Error().Err(err).Msg("p.checkCloseNotify()")
+		return err
+	}
+	p.verdict["close_notify"] = closeNotify
+
+	dataRecords := records
+	if closeNotify != CloseNotifyAbsent {
+		dataRecords = records[:len(records)-1]
+	}
+
+	// http framing as declared by the client
+	framing, complete, err := p.checkHTTPFraming(dataRecords)
+	if err != nil {
+		log.Error().Err(err).Msg("p.checkHTTPFraming()")
+		return err
+	}
+	p.verdict["http_framing"] = framing
+
+	// without framing only a decrypted close_notify marks the end of the
+	// response, a record of alert length proves nothing
+	if framing == "none" {
+		complete = closeNotify == CloseNotifyAsserted
+	}
+	p.verdict["response_complete"] = strconv.FormatBool(complete)
+	p.verdict["response_complete_source"] = completenessSource
+
+	return nil
+}
+
+// checkCloseNotify reports whether a record is a close_notify alert. without
+// disclosed keystream only the record length can be checked, with the client
+// disclosing tag parameters and the first keystream block of the record the
+// alert is decrypted and its tag checked. neither is bound to the proven
+// server key, so the result is client asserted.
+func (p *Parser) checkCloseNotify(record u.Record) (string, error) {
+
+	if len(record.Ciphertext) != closeNotifyRecordLen {
+		return CloseNotifyAbsent, nil
+	}
+
+	pi := p.closeNotifyPI
+	if pi == nil {
+		return CloseNotifyPresent, nil
+	}
+
+	// verify authtag of the alert record
+	ciphertext := record.CipherChunks()
+	tag := AuthTag13(pi["ECB0"], hex.EncodeToString(ciphertext), pi["ECBK"], hex.EncodeToString(record.AdditionalData))
+	if tag != hex.EncodeToString(record.Ciphertext) {
+		return "", errors.New("close_notify authtag verification failed")
+	}
+
+	// decrypt alert with disclosed keystream
+	keystream, err := hex.DecodeString(pi["ECB1"])
+	if err != nil || len(keystream) < len(ciphertext) {
+		return "", errors.New("invalid close_notify keystream")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	xorBytes(plaintext, ciphertext, keystream)
+
+	if !bytes.Equal(plaintext, closeNotifyPlaintext) {
+		return "", fmt.Errorf("final record is alert %x, not close_notify", plaintext)
+	}
+
+	return CloseNotifyAsserted, nil
+}
+
+// checkHTTPFraming compares the http/1.1 message length declared by the
+// client with the plaintext length of the response records. header length,
+// content length, body length and response_start_seq are declared by the
+// client and records are assumed to be unpadded, so the framing verdict is
+// client asserted. for http/2 the declared DATA frames of the stream must
+// end the stream.
+func (p *Parser) checkHTTPFraming(records []u.Record) (string, bool, error) {
+
+	pi := p.httpPI
+	if pi == nil {
+		return "none", false, nil
+	}
+
+	var err error
+	start := 0
+	if s, ok := pi["response_start_seq"]; ok {
+		start, err = strconv.Atoi(s)
+		if err != nil || start < 0 || start >= len(records) {
+			return "", false, errors.New("invalid response_start_seq")
+		}
+	}
+
+	// inner plaintext length without content type byte and tag,
+	// record offsets locate proven values within the response
+	captured := 0
+	for _, r := range records[start:] {
+		p.responseOffsets[r.Seq.String()] = strconv.Itoa(captured)
+		captured += len(r.Ciphertext) - 1 - gcmTagSize
+	}
+
+	if pi["protocol"] == "h2" {
+		complete, err := checkH2Framing(pi, captured)
+		return "h2", complete, err
+	}
+	if p.alpn == "h2" {
+		return "", false, errors.New("http/1.1 framing declared on an h2 connection")
+	}
+
+	headerLength, err := strconv.Atoi(pi["header_length"])
+	if err != nil {
+		return "", false, errors.New("invalid header_length")
+	}
+
+	// body length from content-length or the encoded chunked body
+	var framing string
+	var bodyLength int
+	switch {
+	case pi["transfer_encoding"] == "chunked":
+		framing = "chunked"
+		bodyLength, err = strconv.Atoi(pi["body_length"])
+	case pi["content_length"] != "":
+		framing = "content-length"
+		bodyLength, err = strconv.Atoi(pi["content_length"])
+	default:
+		return "none", false, nil
+	}
+	if err != nil {
+		return "", false, errors.New("invalid " + framing + " body length")
+	}
+
+	expected := headerLength + bodyLength
+	if captured != expected {
+		log.Debug().Int("captured", captured).Int("expected", expected).Msg("http response length mismatch")
+	}
+
+	return framing, captured == expected, nil
+}
+
+// checkH2Framing checks that the declared DATA frames lie within the captured
+// application data and that the last frame of the stream sets END_STREAM
+func checkH2Framing(pi map[string]string, captured int) (bool, error) {
+
+	streamID, err := strconv.Atoi(pi["stream_id"])
+	if err != nil || streamID%2 == 0 {
+		return false, errors.New("invalid stream_id, expected a client initiated stream")
+	}
+	frames, err := u.ParseDataFrames(pi["data_frames"])
+	if err != nil {
+		return false, err
+	}
+
+	endStream := false
+	for _, f := range frames {
+		if f.End() > captured {
+			return false, errors.New("data frame exceeds captured application data")
+		}
+		if f.Stream == streamID {
+			endStream = f.Flags&u.H2FlagEndStream != 0
+		}
+	}
+
+	return endStream, nil
+}
+
+// StoreSessionVerdict stores the results of transcript checks
+// and the offsets of response records within the http response
+func (p *Parser) StoreSessionVerdict() error {
+	err := u.StoreM(p.verdict, "session_verdict")
+	if err != nil {
+		return err
+	}
+	return u.StoreM(p.responseOffsets, "response_offsets")
+}
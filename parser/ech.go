@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+
+	"proxy/internal/tls13"
+)
+
+// encrypted_client_hello extension and its inner variant marker
+const (
+	extensionECH          uint16 = 0xfe0d
+	echClientHelloInner   uint8  = 1
+	echAcceptConfirmation        = "ech accept confirmation"
+)
+
+// ech verdicts
+const (
+	ECHNone     = "none"
+	ECHAccepted = "accepted"
+	ECHRejected = "rejected"
+)
+
+// applyECH handles sessions in which the client offered ech. the captured
+// transcript only holds the outer client hello, if the server accepted ech
+// the handshake is bound to the inner client hello disclosed by the client.
+// a rejection is not signalled and only confirmed by the certificate verify
+// signature over the outer transcript.
+func (p *Parser) applyECH() error {
+
+	outer, err := tls13.ParseClientHello(p.handshake.clientHello)
+	if err != nil {
+		return err
+	}
+	if _, ok := outer.Extensions[extensionECH]; !ok {
+		if p.clientHelloInner != nil {
+			return errors.New("client hello inner disclosed for a session without ech")
+		}
+		p.verdict["ech"] = ECHNone
+		return nil
+	}
+	p.verdict["outer_server_name"] = outer.ServerName
+
+	if p.clientHelloInner == nil {
+		p.verdict["ech"] = ECHRejected
+		return nil
+	}
+	if p.handshake.helloRetryRequest != nil {
+		return errors.New("ech with hello retry request is not supported")
+	}
+
+	inner, err := tls13.ParseClientHello(p.clientHelloInner)
+	if err != nil {
+		return err
+	}
+	if ext := inner.Extensions[extensionECH]; !bytes.Equal(ext, []byte{echClientHelloInner}) {
+		return errors.New("disclosed client hello is not an ech inner client hello")
+	}
+
+	if !echAccepted(p.clientHelloInner, inner.Random, p.handshake.serverHello) {
+		p.verdict["ech"] = ECHRejected
+		return nil
+	}
+
+	// the handshake transcript starts with the inner client hello
+	p.handshake.clientHello = p.clientHelloInner
+	p.verdict["ech"] = ECHAccepted
+
+	return nil
+}
+
+// echAccepted checks the acceptance confirmation in the last 8 bytes of
+// ServerHello.random, see the ech specification section 7.2
+func echAccepted(clientHelloInner []byte, innerRandom []byte, serverHello []byte) bool {
+
+	// header, legacy_version and random
+	if len(serverHello) < tls13.HandshakeHeaderLen+2+32 {
+		return false
+	}
+	confirmation := serverHello[tls13.HandshakeHeaderLen+2+24 : tls13.HandshakeHeaderLen+2+32]
+
+	// server hello with zeroed confirmation
+	sh := append([]byte(nil), serverHello...)
+	copy(sh[tls13.HandshakeHeaderLen+2+24:tls13.HandshakeHeaderLen+2+32], make([]byte, 8))
+
+	transcript := sha256.New()
+	transcript.Write(clientHelloInner)
+	transcript.Write(sh)
+
+	secret := suite.Extract(nil, innerRandom)
+	expected := suite.ExpandLabel(secret, echAcceptConfirmation, transcript.Sum(nil), 8)
+
+	return bytes.Equal(confirmation, expected)
+}
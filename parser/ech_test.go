@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"proxy/internal/tls13"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// echClientHello encodes a client hello for the server name, with the given
+// encrypted_client_hello extension data unless it is nil
+func echClientHello(random byte, serverName string, ech []byte) []byte {
+
+	var b cryptobyte.Builder
+	b.AddUint16(tls13.VersionTLS12)
+	b.AddBytes(bytes.Repeat([]byte{random}, 32))
+	b.AddUint8(0)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint16(0x1301) })
+	b.AddBytes([]byte{1, 0})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(tls13.ExtensionServerName)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8(0)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(serverName)) })
+			})
+		})
+		if ech != nil {
+			b.AddUint16(extensionECH)
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(ech) })
+		}
+	})
+
+	return testMessage(tls13.TypeClientHello, string(b.BytesOrPanic()))
+}
+
+// echServerHello returns a server hello whose random confirms the inner
+// client hello as the server does when it accepts ech
+func echServerHello(clientHelloInner []byte) []byte {
+
+	sh := testServerHello(append(bytes.Repeat([]byte{2}, 24), make([]byte, 8)...))
+	transcript := sha256.New()
+	transcript.Write(clientHelloInner)
+	transcript.Write(sh)
+	secret := suite.Extract(nil, clientHelloInner[tls13.HandshakeHeaderLen+2:tls13.HandshakeHeaderLen+2+32])
+	confirmation := suite.ExpandLabel(secret, echAcceptConfirmation, transcript.Sum(nil), 8)
+	copy(sh[tls13.HandshakeHeaderLen+2+24:], confirmation)
+
+	return sh
+}
+
+func TestApplyECH(t *testing.T) {
+
+	outer := echClientHello(1, "public.example", []byte{0, 1, 2})
+	inner := echClientHello(2, "private.example", []byte{echClientHelloInner})
+	otherInner := echClientHello(3, "private.example", []byte{echClientHelloInner})
+	plain := echClientHello(1, "private.example", nil)
+
+	tests := []struct {
+		name        string
+		clientHello []byte
+		inner       []byte
+		serverHello []byte
+		retry       bool
+		verdict     string
+		wantErr     bool
+	}{
+		{"ech not offered", plain, nil, testSH, false, ECHNone, false},
+		{"inner disclosed without ech", plain, inner, echServerHello(inner), false, "", true},
+		{"inner not disclosed", outer, nil, echServerHello(inner), false, ECHRejected, false},
+		{"accepted", outer, inner, echServerHello(inner), false, ECHAccepted, false},
+		{"not confirmed", outer, inner, testSH, false, ECHRejected, false},
+		{"confirmed for another inner", outer, inner, echServerHello(otherInner), false, ECHRejected, false},
+		{"hello retry request", outer, inner, echServerHello(inner), true, "", true},
+		{"outer disclosed as inner", outer, outer, echServerHello(outer), false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Parser{
+				handshake: &handshakeTranscript{
+					clientHello: tt.clientHello,
+					serverHello: tt.serverHello,
+				},
+				clientHelloInner: tt.inner,
+				verdict:          make(map[string]string),
+			}
+			if tt.retry {
+				p.handshake.helloRetryRequest = testHRR
+			}
+
+			err := p.applyECH()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ech verdict %q", p.verdict["ech"])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.verdict["ech"] != tt.verdict {
+				t.Errorf("ech verdict %q, want %q", p.verdict["ech"], tt.verdict)
+			}
+
+			// only an accepted inner client hello replaces the outer one
+			want := tt.clientHello
+			if tt.verdict == ECHAccepted {
+				want = tt.inner
+			}
+			if !bytes.Equal(p.handshake.clientHello, want) {
+				t.Error("unexpected client hello in the handshake transcript")
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+
+	"proxy/internal/tls13"
+)
+
+// cipher suite of the oracle circuit
+var suite = tls13.CipherSuiteByID(tls13.TLS_AES_128_GCM_SHA256)
+
+// handshakeTranscript holds the raw handshake messages of a captured session
+type handshakeTranscript struct {
+
+	// plaintext hello messages, the first client hello and
+	// hello retry request are only set if the server sent a retry
+	firstClientHello  []byte
+	helloRetryRequest []byte
+	clientHello       []byte
+	serverHello       []byte
+
+	// encrypted server handshake messages
+	encryptedExtensions []byte
+	certificate         []byte
+	certificateVerify   []byte
+	finished            []byte
+
+	// number of encrypted server records carrying handshake messages
+	serverHandshakeRecords int
+}
+
+// reassembleHandshake reconstructs the handshake messages of both transcripts.
+// server handshake records are decrypted with the server handshake traffic secret.
+func reassembleHandshake(clientRecords []tls13.Record, serverRecords []tls13.Record, shts []byte) (*handshakeTranscript, error) {
+
+	hs := &handshakeTranscript{}
+
+	// client hello messages are sent in plaintext
+	var clientHellos [][]byte
+	chBuf := &tls13.HandshakeBuffer{}
+	for _, r := range clientRecords {
+		if r.ContentType == tls13.RecordTypeApplicationData {
+			break
+		}
+		if r.ContentType != tls13.RecordTypeHandshake {
+			continue
+		}
+		chBuf.Write(r.Payload)
+		for msg, ok := chBuf.Next(); ok; msg, ok = chBuf.Next() {
+			if msg[0] != tls13.TypeClientHello {
+				return nil, fmt.Errorf("unexpected plaintext client handshake message %d", msg[0])
+			}
+			clientHellos = append(clientHellos, msg)
+		}
+	}
+	if !chBuf.Empty() {
+		return nil, errors.New("incomplete client hello")
+	}
+
+	// server hello messages are sent in plaintext, everything after
+	// is protected with the server handshake traffic secret
+	dec, err := tls13.NewHalfConn(suite, shts)
+	if err != nil {
+		return nil, err
+	}
+
+	var serverHellos [][]byte
+	shBuf := &tls13.HandshakeBuffer{}
+	encBuf := &tls13.HandshakeBuffer{}
+	for _, r := range serverRecords {
+		if hs.finished != nil {
+			break
+		}
+
+		switch r.ContentType {
+		case tls13.RecordTypeChangeCipherSpec:
+			continue
+
+		case tls13.RecordTypeHandshake:
+			if hs.serverHandshakeRecords > 0 {
+				return nil, errors.New("plaintext handshake record after encrypted handshake records")
+			}
+			shBuf.Write(r.Payload)
+			for msg, ok := shBuf.Next(); ok; msg, ok = shBuf.Next() {
+				if msg[0] != tls13.TypeServerHello {
+					return nil, fmt.Errorf("unexpected plaintext server handshake message %d", msg[0])
+				}
+				serverHellos = append(serverHellos, msg)
+			}
+
+		case tls13.RecordTypeApplicationData:
+			if len(serverHellos) == 0 || !shBuf.Empty() {
+				return nil, errors.New("encrypted server record before server hello")
+			}
+			contentType, plaintext, err := dec.Open(r.Header, r.Payload)
+			if err != nil {
+				return nil, err
+			}
+			if contentType != tls13.RecordTypeHandshake {
+				return nil, fmt.Errorf("unexpected inner content type %d in server handshake", contentType)
+			}
+			hs.serverHandshakeRecords++
+
+			encBuf.Write(plaintext)
+			for msg, ok := encBuf.Next(); ok; msg, ok = encBuf.Next() {
+				err = hs.setEncryptedMessage(msg)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if hs.finished != nil && !encBuf.Empty() {
+				return nil, errors.New("trailing data after server finished")
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected server record type %d during handshake", r.ContentType)
+		}
+	}
+
+	if hs.finished == nil {
+		return nil, errors.New("server handshake incomplete")
+	}
+
+	// pair hello messages, a retry requires a second client hello
+	switch {
+	case len(clientHellos) == 1 && len(serverHellos) == 1:
+		hs.clientHello = clientHellos[0]
+		hs.serverHello = serverHellos[0]
+	case len(clientHellos) == 2 && len(serverHellos) == 2:
+		hs.firstClientHello = clientHellos[0]
+		hs.helloRetryRequest = serverHellos[0]
+		hs.clientHello = clientHellos[1]
+		hs.serverHello = serverHellos[1]
+	default:
+		return nil, fmt.Errorf("unexpected number of hello messages, client %d, server %d", len(clientHellos), len(serverHellos))
+	}
+
+	if hs.helloRetryRequest != nil && !tls13.IsHelloRetryRequest(hs.helloRetryRequest) {
+		return nil, errors.New("second server hello without hello retry request")
+	}
+	if tls13.IsHelloRetryRequest(hs.serverHello) {
+		return nil, errors.New("server hello is a hello retry request")
+	}
+
+	return hs, nil
+}
+
+// setEncryptedMessage stores an encrypted server handshake message in protocol order
+func (hs *handshakeTranscript) setEncryptedMessage(msg []byte) error {
+
+	switch {
+	case msg[0] == tls13.TypeEncryptedExtensions && hs.encryptedExtensions == nil:
+		hs.encryptedExtensions = msg
+	case msg[0] == tls13.TypeCertificateRequest:
+		return errors.New("client authentication is not supported")
+	case msg[0] == tls13.TypeCertificate && hs.encryptedExtensions != nil && hs.certificate == nil:
+		hs.certificate = msg
+	case msg[0] == tls13.TypeCertificateVerify && hs.certificate != nil && hs.certificateVerify == nil:
+		hs.certificateVerify = msg
+	case msg[0] == tls13.TypeFinished && hs.certificateVerify != nil && hs.finished == nil:
+		hs.finished = msg
+	default:
+		return fmt.Errorf("unexpected server handshake message %d", msg[0])
+	}
+	return nil
+}
+
+// writeHellos writes the hello messages to a transcript hash, replacing the
+// first client hello by a message_hash message after a retry (RFC 8446 4.4.1)
+func (hs *handshakeTranscript) writeHellos(transcript hash.Hash) {
+
+	if hs.helloRetryRequest != nil {
+		h := sha256.Sum256(hs.firstClientHello)
+		transcript.Write(tls13.HandshakeMessage(tls13.TypeMessageHash, h[:]))
+		transcript.Write(hs.helloRetryRequest)
+	}
+	transcript.Write(hs.clientHello)
+	transcript.Write(hs.serverHello)
+}
@@ -0,0 +1,232 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"proxy/internal/tls13"
+)
+
+// handshake message with its 4 byte header
+func testMessage(msgType uint8, body string) []byte {
+	n := len(body)
+	return append([]byte{msgType, byte(n >> 16), byte(n >> 8), byte(n)}, body...)
+}
+
+// server hello with the given random, the parser only reads the random
+// while reassembling
+func testServerHello(random []byte) []byte {
+	body := append([]byte{3, 3}, random...)
+	body = append(body, 0, 0x13, 0x01, 0)
+	return testMessage(tls13.TypeServerHello, string(body))
+}
+
+func testRecord(contentType uint8, payload []byte) []byte {
+	header := []byte{contentType, 3, 3, 0, 0}
+	binary.BigEndian.PutUint16(header[3:], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// newRecordEncrypter protects handshake records as the server does
+func newRecordEncrypter(t *testing.T, trafficSecret []byte) *tls13.HalfConn {
+	enc, err := tls13.NewHalfConn(suite, trafficSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc
+}
+
+// fragments splits data into pieces of at most n bytes
+func fragments(data []byte, n int) [][]byte {
+	var out [][]byte
+	for len(data) > n {
+		out = append(out, data[:n])
+		data = data[n:]
+	}
+	return append(out, data)
+}
+
+var (
+	testSecret      = bytes.Repeat([]byte{7}, 32)
+	testRandom      = bytes.Repeat([]byte{1}, 32)
+	testCH          = testMessage(tls13.TypeClientHello, "client hello")
+	testCH2         = testMessage(tls13.TypeClientHello, "second client hello")
+	testSH          = testServerHello(testRandom)
+	testHRR         = testServerHello(tls13.HelloRetryRequestRandom)
+	testEE          = testMessage(tls13.TypeEncryptedExtensions, "\x00\x00")
+	testCert        = testMessage(tls13.TypeCertificate, strings.Repeat("c", 700))
+	testCV          = testMessage(tls13.TypeCertificateVerify, "signature")
+	testFin         = testMessage(tls13.TypeFinished, strings.Repeat("f", 32))
+	testCCS         = testRecord(tls13.RecordTypeChangeCipherSpec, []byte{1})
+	testServerFlow  = [][]byte{testEE, testCert, testCV, testFin}
+	testEncryptedHS = bytes.Join(testServerFlow, nil)
+)
+
+// transcript layout of a session, each inner slice is one record
+type handshakeLayout struct {
+	client    [][]byte
+	plaintext [][]byte
+	encrypted [][]byte
+	// records following the handshake
+	trailing [][]byte
+}
+
+func (l handshakeLayout) records(t *testing.T) ([]tls13.Record, []tls13.Record) {
+	var client, server []byte
+	for _, p := range l.client {
+		client = append(client, testRecord(tls13.RecordTypeHandshake, p)...)
+	}
+	for _, p := range l.plaintext {
+		if bytes.Equal(p, []byte{1}) {
+			server = append(server, testCCS...)
+			continue
+		}
+		server = append(server, testRecord(tls13.RecordTypeHandshake, p)...)
+	}
+	enc := newRecordEncrypter(t, testSecret)
+	for _, p := range l.encrypted {
+		server = append(server, enc.Seal(tls13.RecordTypeHandshake, p)...)
+	}
+	for _, p := range l.trailing {
+		server = append(server, enc.Seal(tls13.RecordTypeApplicationData, p)...)
+	}
+
+	clientRecords, err := splitRecords(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverRecords, err := splitRecords(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientRecords, serverRecords
+}
+
+func TestReassembleHandshake(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		layout  handshakeLayout
+		retry   bool
+		records int
+	}{
+		{
+			name:    "one message per record",
+			layout:  handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testSH}, encrypted: testServerFlow},
+			records: 4,
+		},
+		{
+			name:    "coalesced",
+			layout:  handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testSH}, encrypted: [][]byte{testEncryptedHS}},
+			records: 1,
+		},
+		{
+			name:    "fragmented",
+			layout:  handshakeLayout{client: fragments(testCH, 5), plaintext: fragments(testSH, 3), encrypted: fragments(testEncryptedHS, 256)},
+			records: 3,
+		},
+		{
+			name:    "fragment boundary inside message header",
+			layout:  handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testSH}, encrypted: [][]byte{testEncryptedHS[:len(testEE)+2], testEncryptedHS[len(testEE)+2:]}},
+			records: 2,
+		},
+		{
+			name:    "change cipher spec before encrypted records",
+			layout:  handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testSH, {1}}, encrypted: [][]byte{testEncryptedHS}},
+			records: 1,
+		},
+		{
+			name:    "application data after finished",
+			layout:  handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testSH}, encrypted: [][]byte{testEncryptedHS}, trailing: [][]byte{[]byte("HTTP/1.1 200 OK")}},
+			records: 1,
+		},
+		{
+			name:    "hello retry request",
+			layout:  handshakeLayout{client: [][]byte{testCH, testCH2}, plaintext: [][]byte{testHRR, {1}, testSH}, encrypted: [][]byte{testEncryptedHS}},
+			retry:   true,
+			records: 1,
+		},
+		{
+			name:    "hello retry request coalesced with server hello",
+			layout:  handshakeLayout{client: [][]byte{testCH, testCH2}, plaintext: [][]byte{append(append([]byte{}, testHRR...), testSH...)}, encrypted: [][]byte{testEncryptedHS}},
+			retry:   true,
+			records: 1,
+		},
+		{
+			name:    "hello retry request split across records",
+			layout:  handshakeLayout{client: fragments(append(append([]byte{}, testCH...), testCH2...), 7), plaintext: fragments(append(append([]byte{}, testHRR...), testSH...), 10), encrypted: fragments(testEncryptedHS, 100)},
+			retry:   true,
+			records: 8,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := tt.layout.records(t)
+			hs, err := reassembleHandshake(client, server, testSecret)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string][2][]byte{
+				"server hello":         {hs.serverHello, testSH},
+				"encrypted extensions": {hs.encryptedExtensions, testEE},
+				"certificate":          {hs.certificate, testCert},
+				"certificate verify":   {hs.certificateVerify, testCV},
+				"finished":             {hs.finished, testFin},
+			}
+			if tt.retry {
+				want["first client hello"] = [2][]byte{hs.firstClientHello, testCH}
+				want["hello retry request"] = [2][]byte{hs.helloRetryRequest, testHRR}
+				want["client hello"] = [2][]byte{hs.clientHello, testCH2}
+			} else {
+				want["client hello"] = [2][]byte{hs.clientHello, testCH}
+				if hs.helloRetryRequest != nil {
+					t.Error("hello retry request without retry")
+				}
+			}
+			for name, m := range want {
+				if !bytes.Equal(m[0], m[1]) {
+					t.Errorf("%s %x, want %x", name, m[0], m[1])
+				}
+			}
+			if hs.serverHandshakeRecords != tt.records {
+				t.Errorf("%d server handshake records, want %d", hs.serverHandshakeRecords, tt.records)
+			}
+		})
+	}
+}
+
+func TestReassembleHandshakeErrors(t *testing.T) {
+
+	certRequest := testMessage(tls13.TypeCertificateRequest, "\x00\x00\x00")
+
+	tests := []struct {
+		name   string
+		layout handshakeLayout
+	}{
+		{"incomplete client hello", handshakeLayout{client: [][]byte{testCH[:8]}, plaintext: [][]byte{testSH}, encrypted: [][]byte{testEncryptedHS}}},
+		{"server message in client hello", handshakeLayout{client: [][]byte{testSH}, plaintext: [][]byte{testSH}, encrypted: [][]byte{testEncryptedHS}}},
+		{"incomplete server handshake", handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testSH}, encrypted: [][]byte{testEE, testCert, testCV}}},
+		{"trailing data after finished", handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testSH}, encrypted: [][]byte{append(append([]byte{}, testEncryptedHS...), testEE[:2]...)}}},
+		{"out of order", handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testSH}, encrypted: [][]byte{testCert, testEE, testCV, testFin}}},
+		{"client authentication", handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testSH}, encrypted: [][]byte{testEE, certRequest, testCert, testCV, testFin}}},
+		{"encrypted record before server hello", handshakeLayout{client: [][]byte{testCH}, encrypted: [][]byte{testEncryptedHS}}},
+		{"server hello split by encrypted record", handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testSH[:10]}, encrypted: [][]byte{testEncryptedHS}}},
+		{"second server hello without retry", handshakeLayout{client: [][]byte{testCH, testCH2}, plaintext: [][]byte{testSH, testSH}, encrypted: [][]byte{testEncryptedHS}}},
+		{"retry without second client hello", handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testHRR, testSH}, encrypted: [][]byte{testEncryptedHS}}},
+		{"retry as server hello", handshakeLayout{client: [][]byte{testCH}, plaintext: [][]byte{testHRR}, encrypted: [][]byte{testEncryptedHS}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := tt.layout.records(t)
+			_, err := reassembleHandshake(client, server, testSecret)
+			if err == nil {
+				t.Fatal("reassembled an invalid handshake")
+			}
+		})
+	}
+}
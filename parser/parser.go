@@ -5,13 +5,19 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"proxy/internal/tls13"
 	tls "proxy/tls-fork"
 	u "proxy/utils"
 
 	"github.com/rs/zerolog/log"
 )
 
+// CAPath is the ca certificate trusted besides the system roots when
+// verifying server certificates
+var CAPath = "./certs/certificates/ca.crt"
+
 type Parser struct {
 
 	// tls cipher suite data
@@ -21,18 +27,35 @@ type Parser struct {
 	tlsParams TLSParameters
 
 	// raw data
-	tdClient tls.TrafficData
-	tdServer tls.TrafficData
+	clientRecords []tls13.Record
+	serverRecords []tls13.Record
+	roots         *x509.CertPool
+
+	// reassembled handshake messages
+	handshake   *handshakeTranscript
+	clientHello *tls13.ClientHello
+	serverHello *tls13.ServerHello
+	// application protocol negotiated via alpn, empty if none
+	alpn string
+
+	// optional parameters disclosed by the client, nil if not disclosed
+	closeNotifyPI    map[string]string
+	httpPI           map[string]string
+	clientHelloInner []byte
 
 	// file handling
-	clientFilePath   string
-	serverFilePath   string
-	storagePath      string
-	secretPath       string
-	authtagPath      string
-	caPath           string
-	serverRecordPath string
-	clientRecordPath string
+	clientFilePath    string
+	serverFilePath    string
+	storagePath       string
+	secretPath        string
+	authtagPath       string
+	clientAuthtagPath string
+	closeNotifyPath   string
+	httpPath          string
+	echPath           string
+	caPath            string
+	serverRecordPath  string
+	clientRecordPath  string
 
 	// parsing/compute results
 	h0       []byte
@@ -46,6 +69,10 @@ type Parser struct {
 	ivSappIn []byte
 	tkCappIn []byte
 	ivCappIn []byte
+
+	// results of transcript checks
+	verdict         map[string]string
+	responseOffsets map[string]string
 }
 
 func NewParser() (*Parser, error) {
@@ -55,11 +82,15 @@ func NewParser() (*Parser, error) {
 	parser.storagePath = "./local_storage/"
 	parser.serverRecordPath = "ServerSentRecords.raw"
 	parser.clientRecordPath = "ClientSentRecords.raw"
-	parser.caPath = "./certs/certificates/ca.crt"
+	parser.caPath = CAPath
 	parser.clientFilePath = parser.storagePath + parser.clientRecordPath
 	parser.serverFilePath = parser.storagePath + parser.serverRecordPath
 	parser.secretPath = "./local_storage/kdc_shared.json"
 	parser.authtagPath = "./local_storage/recordtag_public_input.json"
+	parser.clientAuthtagPath = "./local_storage/recordtag_client_public_input.json"
+	parser.closeNotifyPath = "./local_storage/closenotify_public_input.json"
+	parser.httpPath = "./local_storage/http_public_input.json"
+	parser.echPath = "./local_storage/ech_public_input.json"
 
 	// configure tls 1.3 parameters
 	parser.cipherID = tls.TLS_AES_128_GCM_SHA256
@@ -76,10 +107,9 @@ func NewParser() (*Parser, error) {
 		return nil, err
 	}
 	caCertPool.AppendCertsFromPEM(caCert)
+	parser.roots = caCertPool
 
-	// initialize client/server transcript traffic parsers
-	parser.tdClient = tls.NewTrafficData(parser.clientFilePath, tls.VersionTLS13, parser.cipherID, caCertPool)
-	parser.tdServer = tls.NewTrafficData(parser.serverFilePath, tls.VersionTLS13, parser.cipherID, caCertPool)
+	parser.verdict = make(map[string]string)
 
 	return parser, nil
 }
@@ -99,58 +129,120 @@ func (p *Parser) ReadTLSParams() error {
 // sets all tls messages
 func (p *Parser) ReadTranscript() error {
 
-	// sets client rawInput data
-	err := p.tdClient.ReadTransmissionBitstream()
+	clientRaw, err := ioutil.ReadFile(p.clientFilePath)
+	if err != nil {
+		log.Error().Err(err).Msg("ioutil.ReadFile(p.clientFilePath)")
+		return err
+	}
+	serverRaw, err := ioutil.ReadFile(p.serverFilePath)
+	if err != nil {
+		log.Error().Err(err).Msg("ioutil.ReadFile(p.serverFilePath)")
+		return err
+	}
+
+	// inner client hello of ech sessions
+	echPI, err := readOptionalPI(p.echPath)
 	if err != nil {
-		log.Error().Err(err).Msg("p.tdClient.ReadTransmissionBitstream()")
 		return err
 	}
+	if echPI != nil {
+		p.clientHelloInner, err = hex.DecodeString(echPI["client_hello_inner"])
+		if err != nil {
+			log.Error().Err(err).Msg("hex.DecodeString(client_hello_inner)")
+			return err
+		}
+	}
 
-	// set server rawInput data
-	err = p.tdServer.ReadTransmissionBitstream()
+	return p.setTranscript(clientRaw, serverRaw)
+}
+
+// setTranscript parses raw client and server traffic
+func (p *Parser) setTranscript(clientRaw []byte, serverRaw []byte) error {
+
+	// split client rawInput data into records
+	var err error
+	p.clientRecords, err = splitRecords(clientRaw)
 	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.ReadTransmissionBitstream()")
+		log.Error().Err(err).Msg("splitRecords(clientRaw)")
 		return err
 	}
 
-	// set client hello
-	err = p.tdClient.ParseClientHello()
+	// split server rawInput data into records
+	p.serverRecords, err = splitRecords(serverRaw)
 	if err != nil {
-		log.Error().Err(err).Msg("p.tdClient.parseHello()")
+		log.Error().Err(err).Msg("splitRecords(serverRaw)")
 		return err
 	}
 
-	// set server hello
-	err = p.tdServer.ParseServerHello()
+	// reject non tls and interleaved plaintext traffic
+	err = checkRecordLayer(p.clientRecords, true)
 	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.parseHello()")
+		log.Error().Err(err).Msg("checkRecordLayer(p.clientRecords)")
 		return err
 	}
+	err = checkRecordLayer(p.serverRecords, false)
+	if err != nil {
+		log.Error().Err(err).Msg("checkRecordLayer(p.serverRecords)")
+		return err
+	}
+	p.verdict["record_layer"] = "ok"
 
-	// derive encryption keys from SHTS
-	p.tdServer.SetCipherParameters(p.tlsParams.shts)
+	// reassemble handshake messages, server handshake
+	// records are decrypted with keys derived from SHTS
+	p.handshake, err = reassembleHandshake(p.clientRecords, p.serverRecords, p.tlsParams.shts)
+	if err != nil {
+		log.Error().Err(err).Msg("reassembleHandshake()")
+		return err
+	}
+
+	// bind the handshake to the inner client hello if ech was accepted
+	err = p.applyECH()
+	if err != nil {
+		log.Error().Err(err).Msg("p.applyECH()")
+		return err
+	}
 
-	// continue parsing server encrypted extension
-	err = p.tdServer.ParseServerEncryptedExtension()
+	// set client hello
+	p.clientHello, err = tls13.ParseClientHello(p.handshake.clientHello)
 	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.parseServerEncryptedExtension()")
+		log.Error().Err(err).Msg("tls13.ParseClientHello()")
 		return err
 	}
 
-	// parse server certificate
-	// attention: the function verifies the server side certificate
-	err = p.tdServer.ParseServerCertificate(p.tdClient.GetClientHello())
+	// set server hello
+	p.serverHello, err = tls13.ParseServerHello(p.handshake.serverHello)
 	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.parseServerCertificate(p.tdClient.clientHello)")
+		log.Error().Err(err).Msg("tls13.ParseServerHello()")
+		return err
+	}
+	err = checkNegotiatedVersion(p.serverHello)
+	if err != nil {
+		log.Error().Err(err).Msg("checkNegotiatedVersion()")
+		return err
+	}
+	p.verdict["tls_version"] = "1.3"
+	p.verdict["downgrade_sentinel"] = "absent"
+
+	if p.serverHello.CipherSuite != p.cipherID {
+		err = fmt.Errorf("unsupported cipher suite %#04x", p.serverHello.CipherSuite)
+		log.Error().Err(err).Msg("p.serverHello.CipherSuite")
 		return err
 	}
 
-	// parse server finished message
-	err = p.tdServer.ParseFinishedMsg()
+	// set negotiated application protocol
+	extensions, err := tls13.ParseEncryptedExtensions(p.handshake.encryptedExtensions)
 	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.parseFinishedMsg()")
+		log.Error().Err(err).Msg("tls13.ParseEncryptedExtensions()")
 		return err
 	}
+	if data, ok := extensions[tls13.ExtensionALPN]; ok {
+		p.alpn, err = tls13.ParseALPN(data)
+		if err != nil {
+			log.Error().Err(err).Msg("tls13.ParseALPN()")
+			return err
+		}
+	}
+	p.verdict["alpn"] = p.alpn
 
 	// set transcript digests
 	err = p.setTranscriptDigests()
@@ -159,37 +251,68 @@ func (p *Parser) ReadTranscript() error {
 		return err
 	}
 
+	// parse and verify server certificate
+	err = p.verifyServerCertificate()
+	if err != nil {
+		log.Error().Err(err).Msg("p.verifyServerCertificate()")
+		return err
+	}
+
 	return nil
 }
 
-func (p *Parser) setTranscriptDigests() error {
+// verifies the server certificate chain and the certificate verify signature
+func (p *Parser) verifyServerCertificate() error {
 
-	// h0
-	p.h0 = p.getH0()
+	certs, err := tls13.ParseCertificate(p.handshake.certificate)
+	if err != nil {
+		log.Error().Err(err).Msg("tls13.ParseCertificate()")
+		return err
+	}
 
-	// h2
-	h2, err := p.getH2()
+	err = verifyCertificateChain(certs, p.roots, p.clientHello.ServerName)
 	if err != nil {
-		log.Error().Err(err).Msg("p.GetH2()")
+		log.Error().Err(err).Msg("verifyCertificateChain()")
 		return err
 	}
-	p.h2 = h2
 
-	// h3
-	h3, err := p.getH3()
+	scheme, signature, err := tls13.ParseCertificateVerify(p.handshake.certificateVerify)
 	if err != nil {
-		log.Error().Err(err).Msg("p.GetH3()")
+		log.Error().Err(err).Msg("tls13.ParseCertificateVerify()")
 		return err
 	}
-	p.h3 = h3
 
-	// h7
-	h7, err := p.getH7()
+	// signature covers the transcript up to the certificate message
+	transcript := tls.NewHashCipherSuiteTLS13ByID(p.cipherID)
+	p.handshake.writeHellos(transcript)
+	transcript.Write(p.handshake.encryptedExtensions)
+	transcript.Write(p.handshake.certificate)
+
+	err = verifyCertificateVerify(certs[0], scheme, signature, transcript.Sum(nil))
 	if err != nil {
-		log.Error().Err(err).Msg("p.GetH7()")
+		log.Error().Err(err).Msg("verifyCertificateVerify()")
 		return err
 	}
-	p.h7 = h7
+
+	// server identity established by the verified certificate
+	p.verdict["server_name"] = p.clientHello.ServerName
+
+	return nil
+}
+
+func (p *Parser) setTranscriptDigests() error {
+
+	// h0
+	p.h0 = p.getH0()
+
+	// h2
+	p.h2 = p.getH2()
+
+	// h3
+	p.h3 = p.getH3()
+
+	// h7
+	p.h7 = p.getH7()
 
 	return nil
 }
@@ -201,110 +324,37 @@ func (p *Parser) getH0() []byte {
 	return transcript.Sum(nil)
 }
 
-func (p *Parser) getH2() ([]byte, error) {
-
-	// deserialize transcripts
-	chTranscript, err := p.tdClient.GetClientHelloMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdClient.GetClientHelloMarshal()")
-		return nil, err
-	}
-	shTranscript, err := p.tdServer.GetServerHelloMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.GetServerHelloMarshal()")
-		return nil, err
-	}
+func (p *Parser) getH2() []byte {
 
 	// compute transcript hash
 	transcript := tls.NewHashCipherSuiteTLS13ByID(p.cipherID)
-	transcript.Write(chTranscript)
-	transcript.Write(shTranscript)
-	return transcript.Sum(nil), nil
+	p.handshake.writeHellos(transcript)
+	return transcript.Sum(nil)
 }
 
-func (p *Parser) getH3() ([]byte, error) {
-
-	// deserialize transcripts
-	chTranscript, err := p.tdClient.GetClientHelloMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdClient.GetClientHelloMarshal()")
-		return nil, err
-	}
-	shTranscript, err := p.tdServer.GetServerHelloMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.GetServerHelloMarshal()")
-		return nil, err
-	}
-	eeTranscript, err := p.tdServer.GetEncryptedExtensionsMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.GetEncryptedExtensionsMarshal()")
-		return nil, err
-	}
-	cmTranscript, err := p.tdServer.GetCertMsgMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.GetCertMsgMarshal()")
-		return nil, err
-	}
-	cvTranscript, err := p.tdServer.GetCertVerifyMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.GetCertVerifyMarshal()")
-		return nil, err
-	}
-	sfTranscript, err := p.tdServer.GetFinishedMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.GetFinishedMarshal()")
-		return nil, err
-	}
+func (p *Parser) getH3() []byte {
 
 	// compute transcript hash
 	transcript := tls.NewHashCipherSuiteTLS13ByID(p.cipherID)
-	transcript.Write(chTranscript)
-	transcript.Write(shTranscript)
-	transcript.Write(eeTranscript)
-	transcript.Write(cmTranscript)
-	transcript.Write(cvTranscript)
-	transcript.Write(sfTranscript)
-
-	return transcript.Sum(nil), nil
+	p.handshake.writeHellos(transcript)
+	transcript.Write(p.handshake.encryptedExtensions)
+	transcript.Write(p.handshake.certificate)
+	transcript.Write(p.handshake.certificateVerify)
+	transcript.Write(p.handshake.finished)
+
+	return transcript.Sum(nil)
 }
 
-func (p *Parser) getH7() ([]byte, error) {
-	// deserialize transcripts
-	chTranscript, err := p.tdClient.GetClientHelloMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdClient.GetClientHelloMarshal()")
-		return nil, err
-	}
-	shTranscript, err := p.tdServer.GetServerHelloMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.GetServerHelloMarshal()")
-		return nil, err
-	}
-	eeTranscript, err := p.tdServer.GetEncryptedExtensionsMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.GetEncryptedExtensionsMarshal()")
-		return nil, err
-	}
-	cmTranscript, err := p.tdServer.GetCertMsgMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.GetCertMsgMarshal()")
-		return nil, err
-	}
-	cvTranscript, err := p.tdServer.GetCertVerifyMarshal()
-	if err != nil {
-		log.Error().Err(err).Msg("p.tdServer.GetCertVerifyMarshal()")
-		return nil, err
-	}
+func (p *Parser) getH7() []byte {
 
 	// compute transcript hash
 	transcript := tls.NewHashCipherSuiteTLS13ByID(p.cipherID)
-	transcript.Write(chTranscript)
-	transcript.Write(shTranscript)
-	transcript.Write(eeTranscript)
-	transcript.Write(cmTranscript)
-	transcript.Write(cvTranscript)
+	p.handshake.writeHellos(transcript)
+	transcript.Write(p.handshake.encryptedExtensions)
+	transcript.Write(p.handshake.certificate)
+	transcript.Write(p.handshake.certificateVerify)
 
-	return transcript.Sum(nil), nil
+	return transcript.Sum(nil)
 }
 
 func (p *Parser) CreateKdcPublicInput() error {
@@ -321,23 +371,22 @@ func (p *Parser) CreateKdcPublicInput() error {
 	return nil
 }
 
+// KdcParameters returns the kdc public inputs computed by CreateKdcPublicInput
+func (p *Parser) KdcParameters() KdcParameters {
+	return KdcParameters{
+		IntermediateHashHSopad: p.tlsParams.intermediateHashHSopad,
+		MSin:                   p.msIn,
+		SATSin:                 p.satsIn,
+		TkSappIn:               p.tkSappIn,
+		CATSin:                 p.catsIn,
+		TkCappIn:               p.tkCappIn,
+	}
+}
+
 func (p *Parser) StoreConfirmedKdcParameters() error {
 
 	// json structure
-	jsonData := make(map[string]string)
-	// jsonData["H0"] = hex.EncodeToString(p.h0)
-	// jsonData["H2"] = hex.EncodeToString(p.h2)
-	// jsonData["H3"] = hex.EncodeToString(p.h3)
-	// jsonData["H7"] = hex.EncodeToString(p.h7)
-	// jsonData["SHTSin"] = hex.EncodeToString(p.tlsParams.shtsIn)
-	jsonData["intermediateHashHSopad"] = hex.EncodeToString(p.tlsParams.intermediateHashHSopad)
-	jsonData["MSin"] = hex.EncodeToString(p.msIn)
-	jsonData["SATSin"] = hex.EncodeToString(p.satsIn)
-	jsonData["tkSappIn"] = hex.EncodeToString(p.tkSappIn)
-	// jsonData["ivSappIn"] = hex.EncodeToString(p.ivSappIn)
-	jsonData["CATSin"] = hex.EncodeToString(p.catsIn)
-	jsonData["tkCappIn"] = hex.EncodeToString(p.tkCappIn)
-	// jsonData["ivCappIn"] = hex.EncodeToString(p.ivCappIn)
+	jsonData := p.KdcParameters().hexMap()
 
 	// Log the intention to store the data
 	log.Debug().Msg("Storing confirmed KDC parameters to ./local_storage/kdc_confirmed.json")
@@ -374,10 +423,9 @@ func (p *Parser) VerifyServerFinished() error {
 	}
 
 	// derive SF from SHTS and check against plaintextSF
-	// plaintextSFBytes, _ := hex.DecodeString(p.tdServer.GetFinishedRaw())
-	ok1 := tls.VDeriveSF(p.tlsParams.shts, p.h7, p.tdServer.GetFinishedRaw())
+	ok1 := verifyFinished(p.tlsParams.shts, p.h7, p.handshake.finished)
 	if !ok1 {
-		log.Error().Msg("tls.VDeriveSF")
+		log.Error().Msg("verifyFinished")
 	}
 
 	// make sure both verifications work
@@ -389,71 +437,99 @@ func (p *Parser) VerifyServerFinished() error {
 
 }
 
-func (p *Parser) ReadRecordParams() (map[string]map[string]string, error) {
+func (p *Parser) ReadRecordParams() ([]u.Record, error) {
+
+	// application records follow the encrypted server handshake records
+	rps := applicationRecords(p.serverRecords, p.handshake.serverHandshakeRecords)
+	return rps, nil
+}
+
+// reads client record parameters (ciphertext chunks + tag) of the request
+func (p *Parser) ReadClientRecordParams() ([]u.Record, error) {
+
+	// read raw client transcript
+	transcript, err := ioutil.ReadFile(p.clientFilePath)
+	if err != nil {
+		log.Error().Err(err).Msg("ioutil.ReadFile(p.clientFilePath)")
+		return nil, err
+	}
 
-	// parse remaining chunks
-	rps, err := p.tdServer.ParseRecordData()
+	// parse client application records
+	rps, err := clientRecordParams(transcript)
 	if err != nil {
 		return nil, err
 	}
 	return rps, nil
 }
-func (p *Parser) CheckAuthTags(rps map[string]map[string]string) error {
+
+// verifies authtags of server records and stores confirmed record parameters
+func (p *Parser) CheckAuthTags(rps []u.Record) error {
+	return p.checkAuthTags(rps, p.authtagPath, "record_confirmed")
+}
+
+// verifies authtags of client records and stores confirmed record parameters
+func (p *Parser) CheckClientAuthTags(rps []u.Record) error {
+	return p.checkAuthTags(rps, p.clientAuthtagPath, "record_client_confirmed")
+}
+
+func (p *Parser) checkAuthTags(rps []u.Record, authtagPath string, filename string) error {
 
 	// read public input for record tag computation
-	authPI, err := ReadRecordTagPI(p.authtagPath)
+	authPI, err := ReadRecordTagPI(authtagPath)
 	if err != nil {
 		return err
 	}
 
-	// init confirmed data
-	confirmedJson := make(map[string]map[string]string)
+	confirmed := confirmRecord(rps, authPI)
+	if confirmed == nil {
+		log.Error().Msg("No sequences were successfully verified.")
+		return nil
+	}
+
+	return u.StoreConfirmedRecords([]u.ConfirmedRecord{*confirmed}, filename)
+}
+
+// confirmRecord verifies the authtag of the first record for which the client
+// disclosed tag parameters, nil if no authtag could be verified
+func confirmRecord(rps []u.Record, authPI []u.RecordTagInput) *u.ConfirmedRecord {
 
-	var isVerified bool // To check if we've successfully verified any sequence
+	tags := make(map[u.Seq]u.RecordTagInput)
+	for _, t := range authPI {
+		tags[t.Seq] = t
+	}
 
-	// loop over all sequence numbers and verify authentication tags
-	for seq, record := range rps {
-		if isVerified {
-			break // Exit loop once we've found a verified sequence
+	// loop over records in order and verify authentication tags
+	for _, record := range rps {
+		t, ok := tags[record.Seq]
+		if !ok {
+			continue
+		}
+		if len(record.Ciphertext) < gcmTagSize {
+			continue
 		}
 
-		r, ok := authPI[seq]
-		if ok {
-			ecb0 := r["ECB0"]
-			ecbk := r["ECBK"]
-
-			c := record["ciphertext"]
-			ad := record["additionalData"]
-			cipherChunks := c[:len(c)-(16*2)] // last 16 bytes
-
-			// compute authtag
-			tag := AuthTag13(ecb0, cipherChunks, ecbk, ad)
-
-			// verify authtag
-			if tag != c {
-				log.Error().Str("authtag verification", seq).Msg("authtag13 verification failed for sequence number: " + seq)
-				continue
-			}
-
-			// create data structure of confirmed parameters
-			// which are to be stored as confirmed
-			jsonData := make(map[string]string)
-			jsonData["tag"] = c[len(c)-(16*2):]
-			jsonData["cipherChunks"] = cipherChunks
-			jsonData["ecb0"] = ecb0
-			jsonData["ecbk"] = ecbk
-			confirmedJson[seq] = jsonData
-			isVerified = true
+		// compute authtag
+		tag := AuthTag13(
+			hex.EncodeToString(t.ECB0),
+			hex.EncodeToString(record.CipherChunks()),
+			hex.EncodeToString(t.ECBK),
+			hex.EncodeToString(record.AdditionalData),
+		)
+
+		// verify authtag
+		if tag != hex.EncodeToString(record.Ciphertext) {
+			log.Error().Str("authtag verification", record.Seq.String()).Msg("authtag13 verification failed for sequence number: " + record.Seq.String())
+			continue
 		}
-	}
 
-	if isVerified {
-		err = u.StoreMM(confirmedJson, "record_confirmed")
-		if err != nil {
-			return err
+		// confirmed parameters of the first verified sequence
+		return &u.ConfirmedRecord{
+			Seq:          record.Seq,
+			Tag:          record.Tag(),
+			CipherChunks: record.CipherChunks(),
+			ECB0:         t.ECB0,
+			ECBK:         t.ECBK,
 		}
-	} else {
-		log.Error().Msg("No sequences were successfully verified.")
 	}
 
 	return nil
@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"proxy/internal/tls13"
+)
+
+// last 8 bytes of ServerHello.random of a tls 1.3 capable server which
+// negotiates tls 1.2 or below, see RFC 8446 4.1.3
+var (
+	downgradeCanaryTLS12 = []byte("DOWNGRD\x01")
+	downgradeCanaryTLS11 = []byte("DOWNGRD\x00")
+)
+
+// checkNegotiatedVersion checks that the server selected tls 1.3 via
+// supported_versions and that the random carries no downgrade sentinel
+func checkNegotiatedVersion(sh *tls13.ServerHello) error {
+
+	if sh.LegacyVersion != tls13.VersionTLS12 {
+		return fmt.Errorf("unexpected server hello legacy_version %#04x", sh.LegacyVersion)
+	}
+
+	if sh.SupportedVersion == 0 {
+		return errors.New("server hello without supported_versions, tls 1.2 or below negotiated")
+	}
+	if sh.SupportedVersion != tls13.VersionTLS13 {
+		return fmt.Errorf("server selected version %#04x", sh.SupportedVersion)
+	}
+
+	canary := sh.Random[len(sh.Random)-8:]
+	if bytes.Equal(canary, downgradeCanaryTLS12) || bytes.Equal(canary, downgradeCanaryTLS11) {
+		return errors.New("server hello random carries a downgrade sentinel")
+	}
+
+	return nil
+}
+
+// checkRecordLayer rejects transcripts which are not a plain tls 1.3 record
+// stream: unexpected record versions, malformed change_cipher_spec records,
+// and plaintext records interleaved with protected records
+func checkRecordLayer(records []tls13.Record, isClient bool) error {
+
+	if len(records) == 0 || records[0].ContentType != tls13.RecordTypeHandshake {
+		return errors.New("transcript does not start with a handshake record")
+	}
+
+	encrypted := false
+	for i, r := range records {
+
+		// only the initial client hello may use the tls 1.0 record version
+		version := binary.BigEndian.Uint16(r.Header[1:3])
+		if version != tls13.VersionTLS12 && !(isClient && version == tls13.VersionTLS10 && !encrypted) {
+			return fmt.Errorf("record %d has version %#04x", i, version)
+		}
+
+		switch r.ContentType {
+		case tls13.RecordTypeApplicationData:
+			encrypted = true
+			continue
+		case tls13.RecordTypeChangeCipherSpec:
+			if !bytes.Equal(r.Payload, []byte{1}) {
+				return fmt.Errorf("malformed change_cipher_spec record %d", i)
+			}
+		case tls13.RecordTypeAlert:
+			return fmt.Errorf("plaintext alert record %d", i)
+		}
+
+		if encrypted {
+			return fmt.Errorf("plaintext record %d of type %d after protected records", i, r.ContentType)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+
+	"proxy/internal/tls13"
+)
+
+func TestCheckNegotiatedVersion(t *testing.T) {
+
+	random := func(canary []byte) []byte {
+		return append(bytes.Repeat([]byte{1}, 24), canary...)
+	}
+
+	tests := []struct {
+		name    string
+		sh      tls13.ServerHello
+		wantErr bool
+	}{
+		{"tls 1.3", tls13.ServerHello{LegacyVersion: tls13.VersionTLS12, SupportedVersion: tls13.VersionTLS13, Random: random(bytes.Repeat([]byte{1}, 8))}, false},
+		{"tls 1.2 sentinel", tls13.ServerHello{LegacyVersion: tls13.VersionTLS12, SupportedVersion: tls13.VersionTLS13, Random: random(downgradeCanaryTLS12)}, true},
+		{"tls 1.1 sentinel", tls13.ServerHello{LegacyVersion: tls13.VersionTLS12, SupportedVersion: tls13.VersionTLS13, Random: random(downgradeCanaryTLS11)}, true},
+		{"without supported_versions", tls13.ServerHello{LegacyVersion: tls13.VersionTLS12, Random: random(downgradeCanaryTLS12)}, true},
+		{"tls 1.2 selected", tls13.ServerHello{LegacyVersion: tls13.VersionTLS12, SupportedVersion: tls13.VersionTLS12, Random: random(nil)}, true},
+		{"tls 1.3 legacy_version", tls13.ServerHello{LegacyVersion: tls13.VersionTLS13, SupportedVersion: tls13.VersionTLS13, Random: random(bytes.Repeat([]byte{1}, 8))}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNegotiatedVersion(&tt.sh)
+			if tt.wantErr && err == nil {
+				t.Fatal("accepted server hello")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCheckRecordLayer(t *testing.T) {
+
+	record := func(contentType uint8, version uint16, payload []byte) tls13.Record {
+		return tls13.Record{
+			ContentType: contentType,
+			Header:      []byte{contentType, byte(version >> 8), byte(version), 0, byte(len(payload))},
+			Payload:     payload,
+		}
+	}
+	hs := func(version uint16) tls13.Record { return record(tls13.RecordTypeHandshake, version, []byte("hello")) }
+	ccs := record(tls13.RecordTypeChangeCipherSpec, tls13.VersionTLS12, []byte{1})
+	data := record(tls13.RecordTypeApplicationData, tls13.VersionTLS12, []byte("data"))
+
+	tests := []struct {
+		name     string
+		records  []tls13.Record
+		isClient bool
+		wantErr  bool
+	}{
+		{"server flight", []tls13.Record{hs(tls13.VersionTLS12), ccs, data, data}, false, false},
+		{"client hello with tls 1.0 record version", []tls13.Record{hs(tls13.VersionTLS10), ccs, data}, true, false},
+		{"server record with tls 1.0 version", []tls13.Record{hs(tls13.VersionTLS10), data}, false, true},
+		{"protected client record with tls 1.0 version", []tls13.Record{hs(tls13.VersionTLS10), data, record(tls13.RecordTypeApplicationData, tls13.VersionTLS10, []byte("data"))}, true, true},
+		{"tls 1.3 record version", []tls13.Record{hs(tls13.VersionTLS13), data}, false, true},
+		{"ssl 3.0 record version", []tls13.Record{hs(0x0300), data}, true, true},
+		{"malformed change_cipher_spec", []tls13.Record{hs(tls13.VersionTLS12), record(tls13.RecordTypeChangeCipherSpec, tls13.VersionTLS12, []byte{2}), data}, false, true},
+		{"plaintext alert", []tls13.Record{hs(tls13.VersionTLS12), record(tls13.RecordTypeAlert, tls13.VersionTLS12, []byte{2, 40})}, false, true},
+		{"plaintext handshake after protected records", []tls13.Record{hs(tls13.VersionTLS12), data, hs(tls13.VersionTLS12)}, false, true},
+		{"no handshake first", []tls13.Record{data}, false, true},
+		{"empty transcript", nil, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRecordLayer(tt.records, tt.isClient)
+			if tt.wantErr && err == nil {
+				t.Fatal("accepted record layer")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -3,11 +3,7 @@ package parser
 import (
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
-	"io/ioutil"
-	"os"
-
-	"github.com/rs/zerolog/log"
+	u "proxy/utils"
 )
 
 const (
@@ -286,30 +282,7 @@ func AuthTag13(tagMaskCipher string, plaintextCipher string, galoisKexCipher str
 	return hex.EncodeToString(out)
 }
 
-func ReadRecordTagPI(filepath string) (map[string]map[string]string, error) {
-
-	// open file
-	file, err := os.Open(filepath)
-	if err != nil {
-		log.Error().Err(err).Msg("os.Open")
-		return nil, err
-	}
-	defer file.Close()
-
-	// read in data
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
-		log.Error().Err(err).Msg("ioutil.ReadAll(file)")
-		return nil, err
-	}
-
-	// parse json
-	var objmap map[string]map[string]string
-	err = json.Unmarshal(data, &objmap)
-	if err != nil {
-		log.Error().Err(err).Msg("json.Unmarshal(data, &objmap)")
-		return nil, err
-	}
-
-	return objmap, nil
+// reads record tag parameters disclosed by the client
+func ReadRecordTagPI(filepath string) ([]u.RecordTagInput, error) {
+	return u.ReadRecordTagInputs(filepath)
 }
@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"proxy/internal/tls13"
+	u "proxy/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// recordBuffer reassembles tls records from a byte stream which may be split
+// at arbitrary positions, e.g. by the chunking of connection reads
+type recordBuffer struct {
+	buf []byte
+}
+
+func (rb *recordBuffer) Write(p []byte) (int, error) {
+	rb.buf = append(rb.buf, p...)
+	return len(p), nil
+}
+
+// next returns the next complete record, ok is false if more data is needed
+func (rb *recordBuffer) next() (r tls13.Record, ok bool, err error) {
+
+	if len(rb.buf) < tls13.RecordHeaderLen {
+		return r, false, nil
+	}
+
+	contentType := rb.buf[0]
+	if contentType < tls13.RecordTypeChangeCipherSpec || contentType > tls13.RecordTypeApplicationData {
+		return r, false, fmt.Errorf("invalid record content type %d", contentType)
+	}
+	length := int(binary.BigEndian.Uint16(rb.buf[3:5]))
+	if length > tls13.MaxRecordLen {
+		return r, false, fmt.Errorf("record length %d exceeds maximum", length)
+	}
+	if len(rb.buf) < tls13.RecordHeaderLen+length {
+		return r, false, nil
+	}
+
+	r = tls13.Record{
+		ContentType: contentType,
+		Header:      rb.buf[:tls13.RecordHeaderLen:tls13.RecordHeaderLen],
+		Payload:     rb.buf[tls13.RecordHeaderLen : tls13.RecordHeaderLen+length : tls13.RecordHeaderLen+length],
+	}
+	rb.buf = rb.buf[tls13.RecordHeaderLen+length:]
+
+	return r, true, nil
+}
+
+// splitRecords splits a raw transcript into tls records
+func splitRecords(data []byte) ([]tls13.Record, error) {
+
+	rb := &recordBuffer{}
+	rb.Write(data)
+
+	var records []tls13.Record
+	for {
+		r, ok, err := rb.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		records = append(records, r)
+	}
+
+	if len(rb.buf) > 0 {
+		return nil, fmt.Errorf("transcript ends with %d bytes of an incomplete record", len(rb.buf))
+	}
+
+	return records, nil
+}
+
+// applicationRecords returns all encrypted records after the first skip
+// encrypted records in order. sequence numbers restart at zero with the
+// application traffic keys.
+func applicationRecords(records []tls13.Record, skip int) []u.Record {
+
+	var rps []u.Record
+
+	var seq u.Seq
+	for _, r := range records {
+		if r.ContentType != tls13.RecordTypeApplicationData {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		rps = append(rps, u.Record{
+			Seq:            seq,
+			Ciphertext:     r.Payload,
+			AdditionalData: r.Header,
+		})
+		seq++
+	}
+
+	return rps
+}
+
+// clientRecordParams parses the client transcript into application records.
+// the first encrypted client record carries the client finished message,
+// which is protected with handshake traffic keys and hence skipped.
+func clientRecordParams(transcript []byte) ([]u.Record, error) {
+
+	records, err := splitRecords(transcript)
+	if err != nil {
+		log.Error().Err(err).Msg("splitRecords(transcript)")
+		return nil, err
+	}
+
+	return applicationRecords(records, 1), nil
+}
@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+
+	"proxy/internal/tls13"
+)
+
+// the proxy stores transcripts as written by connection reads, records
+// may be split at any position
+func TestRecordBufferReadBoundaries(t *testing.T) {
+
+	var stream []byte
+	var want [][]byte
+	for i, payload := range [][]byte{{1}, []byte("hello"), bytes.Repeat([]byte{9}, 300), {}, []byte("end")} {
+		contentType := []uint8{tls13.RecordTypeChangeCipherSpec, tls13.RecordTypeHandshake, tls13.RecordTypeApplicationData, tls13.RecordTypeApplicationData, tls13.RecordTypeAlert}[i]
+		r := testRecord(contentType, payload)
+		stream = append(stream, r...)
+		want = append(want, r)
+	}
+
+	for _, size := range []int{1, 2, 4, 5, 6, 7, 64, 305, len(stream)} {
+		rb := &recordBuffer{}
+		var got [][]byte
+		for _, chunk := range fragments(stream, size) {
+			rb.Write(chunk)
+			for {
+				r, ok, err := rb.next()
+				if err != nil {
+					t.Fatalf("read size %d: %v", size, err)
+				}
+				if !ok {
+					break
+				}
+				got = append(got, append(append([]byte{}, r.Header...), r.Payload...))
+			}
+		}
+		if len(rb.buf) != 0 {
+			t.Errorf("read size %d: %d bytes left", size, len(rb.buf))
+		}
+		if len(got) != len(want) {
+			t.Fatalf("read size %d: %d records, want %d", size, len(got), len(want))
+		}
+		for i := range want {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Errorf("read size %d: record %d %x, want %x", size, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestSplitRecordsErrors(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"invalid content type", testRecord(24, []byte("x"))},
+		{"oversized record", append([]byte{tls13.RecordTypeApplicationData, 3, 3, 0x48, 0x01}, make([]byte, 0x4801)...)},
+		{"incomplete record", testRecord(tls13.RecordTypeHandshake, []byte("hello"))[:7]},
+		{"incomplete header", testRecord(tls13.RecordTypeHandshake, nil)[:3]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := splitRecords(tt.data)
+			if err == nil {
+				t.Fatal("split an invalid transcript")
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	tls "proxy/tls-fork"
+	u "proxy/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Input holds a captured session and the parameters disclosed by the
+// client, it allows to use the parser without the local storage layout
+type Input struct {
+
+	// raw traffic sent by client and server
+	ClientTranscript io.Reader
+	ServerTranscript io.Reader
+
+	// secrets shared by the client, see DecodeTLSParams
+	Secrets TLSParameters
+
+	// trusted roots, the system pool is used if nil
+	Roots *x509.CertPool
+
+	// record tag parameters, client record tags
+	// are only required to confirm a request
+	RecordTags       []u.RecordTagInput
+	ClientRecordTags []u.RecordTagInput
+
+	// optional close_notify and http framing parameters
+	CloseNotify map[string]string
+	HTTP        map[string]string
+
+	// inner client hello message, required if the server accepted ech
+	ClientHelloInner []byte
+}
+
+// KdcParameters are the kdc public inputs confirmed by the parser
+type KdcParameters struct {
+	IntermediateHashHSopad []byte
+	MSin                   []byte
+	SATSin                 []byte
+	TkSappIn               []byte
+	CATSin                 []byte
+	TkCappIn               []byte
+}
+
+func (k KdcParameters) hexMap() map[string]string {
+	jsonData := make(map[string]string)
+	jsonData["intermediateHashHSopad"] = hex.EncodeToString(k.IntermediateHashHSopad)
+	jsonData["MSin"] = hex.EncodeToString(k.MSin)
+	jsonData["SATSin"] = hex.EncodeToString(k.SATSin)
+	jsonData["tkSappIn"] = hex.EncodeToString(k.TkSappIn)
+	jsonData["CATSin"] = hex.EncodeToString(k.CATSin)
+	jsonData["tkCappIn"] = hex.EncodeToString(k.TkCappIn)
+	return jsonData
+}
+
+// Result holds everything the parser confirmed about a session
+type Result struct {
+	Kdc KdcParameters
+
+	// confirmed response and request records, nil if none was confirmed
+	Record       *u.ConfirmedRecord
+	ClientRecord *u.ConfirmedRecord
+
+	// results of transcript checks and response record offsets
+	Verdict         map[string]string
+	ResponseOffsets map[string]string
+}
+
+// Parse confirms a session in memory, it runs the same checks as the
+// postprocessing of the proxy without reading or writing local storage
+func Parse(in Input) (*Result, error) {
+
+	if in.ClientTranscript == nil || in.ServerTranscript == nil {
+		return nil, errors.New("missing transcript")
+	}
+
+	p := &Parser{
+		cipherID:      tls.TLS_AES_128_GCM_SHA256,
+		tlsParams:     in.Secrets,
+		roots:         in.Roots,
+		closeNotifyPI: in.CloseNotify,
+		httpPI:        in.HTTP,
+		verdict:       make(map[string]string),
+
+		clientHelloInner: in.ClientHelloInner,
+	}
+	if p.roots == nil {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			log.Error().Err(err).Msg("x509.SystemCertPool()")
+			return nil, err
+		}
+		p.roots = roots
+	}
+
+	clientRaw, err := io.ReadAll(in.ClientTranscript)
+	if err != nil {
+		log.Error().Err(err).Msg("io.ReadAll(in.ClientTranscript)")
+		return nil, err
+	}
+	serverRaw, err := io.ReadAll(in.ServerTranscript)
+	if err != nil {
+		log.Error().Err(err).Msg("io.ReadAll(in.ServerTranscript)")
+		return nil, err
+	}
+
+	// handshake, certificate and server finished
+	err = p.setTranscript(clientRaw, serverRaw)
+	if err != nil {
+		return nil, err
+	}
+	err = p.VerifyServerFinished()
+	if err != nil {
+		return nil, err
+	}
+	err = p.CreateKdcPublicInput()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{Kdc: p.KdcParameters()}
+
+	// response record
+	rps, err := p.ReadRecordParams()
+	if err != nil {
+		return nil, err
+	}
+	res.Record = confirmRecord(rps, in.RecordTags)
+
+	err = p.checkResponseComplete()
+	if err != nil {
+		log.Error().Err(err).Msg("p.checkResponseComplete()")
+		return nil, err
+	}
+	res.Verdict = p.verdict
+	res.ResponseOffsets = p.responseOffsets
+
+	// request record
+	if in.ClientRecordTags != nil {
+		crps, err := clientRecordParams(clientRaw)
+		if err != nil {
+			return nil, err
+		}
+		res.ClientRecord = confirmRecord(crps, in.ClientRecordTags)
+	}
+
+	return res, nil
+}
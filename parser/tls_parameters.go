@@ -3,6 +3,7 @@ package parser
 import (
 	"encoding/hex"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"os"
 
@@ -25,21 +26,27 @@ type TLSParameters struct {
 
 func NewTLSParams(filePath string) (TLSParameters, error) {
 
-	// init new struct
-	hss := TLSParameters{}
-
 	// open file
 	file, err := os.Open(filePath)
 	if err != nil {
 		log.Error().Err(err).Msg("os.Open")
-		return hss, err
+		return TLSParameters{}, err
 	}
 	defer file.Close()
 
+	return DecodeTLSParams(file)
+}
+
+// DecodeTLSParams reads the secrets shared by the client as hex encoded json
+func DecodeTLSParams(r io.Reader) (TLSParameters, error) {
+
+	// init new struct
+	hss := TLSParameters{}
+
 	// read in data
-	data, err := ioutil.ReadAll(file)
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
-		log.Error().Err(err).Msg("ioutil.ReadAll(file)")
+		log.Error().Err(err).Msg("ioutil.ReadAll(r)")
 		return hss, err
 	}
 
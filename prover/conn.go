@@ -0,0 +1,49 @@
+package prover
+
+import (
+	"net"
+
+	"proxy/internal/tls13"
+)
+
+// conn is the record layer of a client connection
+type conn struct {
+	raw   net.Conn
+	read  *tls13.HalfConn
+	write *tls13.HalfConn
+	// records are sent with the tls 1.0 record version until the server hello
+	helloVersion bool
+}
+
+// readRecord reads the next record from the connection
+func (c *conn) readRecord() (tls13.Record, error) {
+	return tls13.ReadRecord(c.raw)
+}
+
+// writeRecords sends data as records of the content type, protected if
+// write keys are set
+func (c *conn) writeRecords(contentType uint8, data []byte) error {
+
+	var out []byte
+	for len(data) > 0 {
+		n := len(data)
+		if n > tls13.MaxPlaintext {
+			n = tls13.MaxPlaintext
+		}
+
+		if c.write != nil {
+			out = append(out, c.write.Seal(contentType, data[:n])...)
+		} else {
+			version := tls13.VersionTLS12
+			if c.helloVersion {
+				version = tls13.VersionTLS10
+			}
+			out = append(out, contentType, byte(version>>8), byte(version), byte(n>>8), byte(n))
+			out = append(out, data[:n]...)
+		}
+		data = data[n:]
+	}
+
+	_, err := c.raw.Write(out)
+	return err
+}
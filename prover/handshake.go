@@ -0,0 +1,455 @@
+package prover
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"proxy/internal/tls13"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// key exchange groups
+const (
+	GroupP256   = tls13.GroupP256
+	GroupX25519 = tls13.GroupX25519
+)
+
+// signature schemes accepted in certificate verify messages
+const (
+	ecdsaP256SHA256  uint16 = 0x0403
+	ecdsaP384SHA384  uint16 = 0x0503
+	rsaPSSRSAESHA256 uint16 = 0x0804
+	rsaPSSRSAESHA384 uint16 = 0x0805
+	rsaPSSRSAESHA512 uint16 = 0x0806
+	schemeEd25519    uint16 = 0x0807
+	// pkcs1 is only offered for certificate signatures
+	rsaPKCS1SHA256 uint16 = 0x0401
+)
+
+var signatureSchemes = []uint16{
+	ecdsaP256SHA256, rsaPSSRSAESHA256, ecdsaP384SHA384, rsaPSSRSAESHA384,
+	rsaPSSRSAESHA512, schemeEd25519, rsaPKCS1SHA256,
+}
+
+// context string of server certificate verify signatures, see RFC 8446 4.4.3
+const serverSignatureContext = "TLS 1.3, server CertificateVerify\x00"
+
+// clientHello encodes a client hello offering tls 1.3 with the cipher
+// suite of the circuit and the given key shares
+func (s *Session) clientHello(shares []*tls13.KeyShare, cookie []byte) []byte {
+
+	var b cryptobyte.Builder
+	b.AddUint16(tls13.VersionTLS12)
+	b.AddBytes(s.random)
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(s.sessionID)
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(suite.ID)
+	})
+	// null compression
+	b.AddUint8(1)
+	b.AddUint8(0)
+
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		extension := func(id uint16, body func(b *cryptobyte.Builder)) {
+			b.AddUint16(id)
+			b.AddUint16LengthPrefixed(body)
+		}
+
+		extension(tls13.ExtensionServerName, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8(0)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes([]byte(s.config.ServerName))
+				})
+			})
+		})
+		extension(tls13.ExtensionSupportedGroups, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				for _, g := range s.config.groups() {
+					b.AddUint16(g)
+				}
+			})
+		})
+		extension(tls13.ExtensionSignatureAlgorithms, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				for _, scheme := range signatureSchemes {
+					b.AddUint16(scheme)
+				}
+			})
+		})
+		extension(tls13.ExtensionALPN, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes([]byte("http/1.1"))
+				})
+			})
+		})
+		extension(tls13.ExtensionSupportedVersions, func(b *cryptobyte.Builder) {
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint16(tls13.VersionTLS13)
+			})
+		})
+		if cookie != nil {
+			extension(tls13.ExtensionCookie, func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(cookie)
+				})
+			})
+		}
+		extension(tls13.ExtensionKeyShare, func(b *cryptobyte.Builder) {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				for _, share := range shares {
+					b.AddUint16(share.Group)
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+						b.AddBytes(share.Public)
+					})
+				}
+			})
+		})
+	})
+
+	return tls13.HandshakeMessage(tls13.TypeClientHello, b.BytesOrPanic())
+}
+
+// parseServerHello parses a server hello or hello retry request, which must
+// select tls 1.3 and the cipher suite of the circuit
+func parseServerHello(msg []byte) (*tls13.ServerHello, error) {
+
+	sh, err := tls13.ParseServerHello(msg)
+	if err != nil {
+		return nil, err
+	}
+	if sh.SupportedVersion != tls13.VersionTLS13 {
+		return nil, errors.New("server did not negotiate tls 1.3")
+	}
+	if sh.CipherSuite != suite.ID {
+		return nil, fmt.Errorf("server selected cipher suite %#04x", sh.CipherSuite)
+	}
+
+	return sh, nil
+}
+
+// verifyCertificates verifies the chain against the roots and the server name
+func verifyCertificates(certs []*x509.Certificate, roots *x509.CertPool, serverName string) error {
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       serverName,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+// verifyCertificateVerify checks the server signature over the transcript
+// hash up to the certificate message
+func verifyCertificateVerify(leaf *x509.Certificate, msg []byte, transcriptHash []byte) error {
+
+	scheme, signature, err := tls13.ParseCertificateVerify(msg)
+	if err != nil {
+		return err
+	}
+
+	signed := bytes.Repeat([]byte{0x20}, 64)
+	signed = append(signed, serverSignatureContext...)
+	signed = append(signed, transcriptHash...)
+
+	if scheme == schemeEd25519 {
+		pub, ok := leaf.PublicKey.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(pub, signed, signature) {
+			return errors.New("invalid ed25519 certificate verify signature")
+		}
+		return nil
+	}
+
+	var h crypto.Hash
+	switch scheme {
+	case ecdsaP256SHA256, rsaPSSRSAESHA256:
+		h = crypto.SHA256
+	case ecdsaP384SHA384, rsaPSSRSAESHA384:
+		h = crypto.SHA384
+	case rsaPSSRSAESHA512:
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature scheme %#04x", scheme)
+	}
+	digest := h.New()
+	digest.Write(signed)
+	hashed := digest.Sum(nil)
+
+	switch pub := leaf.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if (scheme != ecdsaP256SHA256 && scheme != ecdsaP384SHA384) || !ecdsa.VerifyASN1(pub, hashed, signature) {
+			return errors.New("invalid ecdsa certificate verify signature")
+		}
+	case *rsa.PublicKey:
+		if scheme == ecdsaP256SHA256 || scheme == ecdsaP384SHA384 {
+			return errors.New("signature scheme does not match rsa certificate key")
+		}
+		err = rsa.VerifyPSS(pub, h, hashed, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported certificate public key")
+	}
+
+	return nil
+}
+
+// handshake runs the tls 1.3 handshake, a hello retry request is answered
+// with a key share of the selected group
+func (s *Session) handshake() error {
+
+	s.random = make([]byte, 32)
+	s.sessionID = make([]byte, 32)
+	_, err := io.ReadFull(s.config.rand(), s.random)
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(s.config.rand(), s.sessionID)
+	if err != nil {
+		return err
+	}
+
+	var shares []*tls13.KeyShare
+	for _, group := range s.config.keyShares() {
+		share, err := tls13.NewKeyShare(group, s.config.rand())
+		if err != nil {
+			return err
+		}
+		shares = append(shares, share)
+	}
+
+	transcript := sha256.New()
+	hello := s.clientHello(shares, nil)
+	transcript.Write(hello)
+	s.conn.helloVersion = true
+	err = s.conn.writeRecords(tls13.RecordTypeHandshake, hello)
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.readHandshake(tls13.TypeServerHello)
+	if err != nil {
+		return err
+	}
+	sh, err := parseServerHello(msg)
+	if err != nil {
+		return err
+	}
+
+	if sh.HelloRetryRequest {
+		// the first client hello is replaced by its hash, RFC 8446 4.4.1
+		first := transcript.Sum(nil)
+		transcript.Reset()
+		transcript.Write(tls13.HandshakeMessage(tls13.TypeMessageHash, first))
+		transcript.Write(msg)
+
+		if !tls13.Contains(s.config.groups(), sh.Group) {
+			return fmt.Errorf("hello retry request selected group %d which was not offered", sh.Group)
+		}
+		share, err := tls13.NewKeyShare(sh.Group, s.config.rand())
+		if err != nil {
+			return err
+		}
+		shares = []*tls13.KeyShare{share}
+		s.Retried = true
+
+		hello = s.clientHello(shares, sh.Cookie)
+		transcript.Write(hello)
+		s.conn.helloVersion = false
+		err = s.conn.writeRecords(tls13.RecordTypeHandshake, hello)
+		if err != nil {
+			return err
+		}
+
+		msg, err = s.readHandshake(tls13.TypeServerHello)
+		if err != nil {
+			return err
+		}
+		sh, err = parseServerHello(msg)
+		if err != nil {
+			return err
+		}
+		if sh.HelloRetryRequest {
+			return errors.New("second hello retry request")
+		}
+	}
+	transcript.Write(msg)
+	s.conn.helloVersion = false
+	if !s.handshakeBuf.Empty() {
+		return errors.New("handshake message after server hello in plaintext")
+	}
+
+	var share *tls13.KeyShare
+	for _, k := range shares {
+		if k.Group == sh.Group {
+			share = k
+		}
+	}
+	if share == nil {
+		return fmt.Errorf("server selected group %d without a key share", sh.Group)
+	}
+	shared, err := share.SharedSecret(sh.KeyShare)
+	if err != nil {
+		return err
+	}
+
+	// server handshake flight is protected with the handshake traffic keys
+	s.keys.handshakeSecrets(shared, transcript.Sum(nil))
+	s.conn.read, err = tls13.NewHalfConn(suite, s.keys.shts)
+	if err != nil {
+		return err
+	}
+
+	return s.serverFlight(transcript)
+}
+
+// serverFlight processes the encrypted server handshake messages and sends
+// the client finished
+func (s *Session) serverFlight(transcript hash.Hash) error {
+
+	msg, err := s.readHandshake(tls13.TypeEncryptedExtensions)
+	if err != nil {
+		return err
+	}
+	transcript.Write(msg)
+	extensions, err := tls13.ParseEncryptedExtensions(msg)
+	if err != nil {
+		return err
+	}
+	if alpn, ok := extensions[tls13.ExtensionALPN]; ok {
+		s.ALPN, err = tls13.ParseALPN(alpn)
+		if err != nil {
+			return err
+		}
+	}
+
+	msg, err = s.readHandshake(tls13.TypeCertificate)
+	if err != nil {
+		return err
+	}
+	transcript.Write(msg)
+	certs, err := tls13.ParseCertificate(msg)
+	if err != nil {
+		return err
+	}
+	err = verifyCertificates(certs, s.config.Roots, s.config.ServerName)
+	if err != nil {
+		return err
+	}
+
+	msg, err = s.readHandshake(tls13.TypeCertificateVerify)
+	if err != nil {
+		return err
+	}
+	err = verifyCertificateVerify(certs[0], msg, transcript.Sum(nil))
+	if err != nil {
+		return err
+	}
+	transcript.Write(msg)
+
+	msg, err = s.readHandshake(tls13.TypeFinished)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(msg[tls13.HandshakeHeaderLen:], suite.FinishedMAC(s.keys.shts, transcript.Sum(nil))) {
+		return errors.New("invalid server finished")
+	}
+	transcript.Write(msg)
+	if !s.handshakeBuf.Empty() {
+		return errors.New("trailing data after server finished")
+	}
+
+	h3 := transcript.Sum(nil)
+	s.keys.applicationSecrets(h3)
+
+	// client finished is protected with the client handshake traffic keys
+	s.conn.write, err = tls13.NewHalfConn(suite, s.keys.chts)
+	if err != nil {
+		return err
+	}
+	finished := tls13.HandshakeMessage(tls13.TypeFinished, suite.FinishedMAC(s.keys.chts, h3))
+	err = s.conn.writeRecords(tls13.RecordTypeHandshake, finished)
+	if err != nil {
+		return err
+	}
+
+	s.conn.write, err = tls13.NewHalfConn(suite, s.keys.cats)
+	if err != nil {
+		return err
+	}
+	s.conn.read, err = tls13.NewHalfConn(suite, s.keys.sats)
+	return err
+}
+
+// readHandshake returns the next handshake message, which must be of the
+// given type. change cipher spec records of middlebox compatibility mode
+// are skipped.
+func (s *Session) readHandshake(msgType uint8) ([]byte, error) {
+
+	for {
+		msg, ok := s.handshakeBuf.Next()
+		if ok {
+			if msg[0] == tls13.TypeCertificateRequest {
+				return nil, errors.New("client authentication is not supported")
+			}
+			if msg[0] != msgType {
+				return nil, fmt.Errorf("unexpected handshake message %d, expected %d", msg[0], msgType)
+			}
+			return msg, nil
+		}
+
+		r, err := s.conn.readRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case r.ContentType == tls13.RecordTypeChangeCipherSpec:
+			// middlebox compatibility, may arrive until the server finished
+			if len(r.Payload) != 1 || r.Payload[0] != 1 {
+				return nil, errors.New("malformed change cipher spec")
+			}
+			continue
+		case r.ContentType == tls13.RecordTypeAlert && s.conn.read == nil:
+			return nil, fmt.Errorf("server sent alert %x", r.Payload)
+		case r.ContentType == tls13.RecordTypeHandshake && s.conn.read == nil:
+			s.handshakeBuf.Write(r.Payload)
+		case r.ContentType == tls13.RecordTypeApplicationData && s.conn.read != nil:
+			contentType, plaintext, err := s.conn.read.Open(r.Header, r.Payload)
+			if err != nil {
+				return nil, err
+			}
+			if contentType == tls13.RecordTypeAlert {
+				return nil, fmt.Errorf("server sent alert %x", plaintext)
+			}
+			if contentType != tls13.RecordTypeHandshake {
+				return nil, fmt.Errorf("unexpected inner content type %d during handshake", contentType)
+			}
+			s.handshakeBuf.Write(plaintext)
+		default:
+			return nil, fmt.Errorf("unexpected record type %d during handshake", r.ContentType)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package prover
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"proxy/internal/tls13"
+	u "proxy/utils"
+)
+
+const (
+	blockSize = 16
+	tagSize   = 16
+)
+
+// Inputs holds the public inputs sent to the proxy and the values of the
+// oracle circuit over the proven record
+type Inputs struct {
+	Combined u.CombinedData
+	oracle   oracleInputs
+}
+
+// oracleInputs are the values of the oracle circuit, offsets are relative to
+// the first chunk
+type oracleInputs struct {
+	kdc            kdcInputs
+	nonce          []byte
+	ecb0           []byte
+	ecbk           []byte
+	cipherChunks   []byte
+	plainChunks    []byte
+	chunkIndex     int
+	substring      string
+	substringStart int
+	substringEnd   int
+	valueStart     int
+	valueEnd       int
+	threshold      int
+}
+
+// encrypts a single block with the record key
+func encryptBlock(key []byte, in []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	out := make([]byte, blockSize)
+	block.Encrypt(out, in)
+	return out
+}
+
+// counterBlock returns the gcm counter block of a record nonce
+func counterBlock(nonce []byte, counter uint32) []byte {
+	b := make([]byte, blockSize)
+	copy(b, nonce)
+	binary.BigEndian.PutUint32(b[12:], counter)
+	return b
+}
+
+// innerPlaintext returns the decrypted record including inner content type
+// and padding, which is what the cipher chunks decrypt to
+func (ar appRecord) innerPlaintext() []byte {
+	inner := make([]byte, len(ar.record.Payload)-tagSize)
+	copy(inner, ar.plaintext)
+	inner[len(ar.plaintext)] = ar.contentType
+	return inner
+}
+
+// locate returns the offsets of substring and of the number following it,
+// e.g. the value of a json field
+func locate(plaintext []byte, substring string) (int, int, int, bool) {
+
+	from := 0
+	for {
+		i := bytes.Index(plaintext[from:], []byte(substring))
+		if i < 0 {
+			return 0, 0, 0, false
+		}
+		start := from + i
+		from = start + 1
+
+		valueStart := start + len(substring)
+		for valueStart < len(plaintext) && strings.IndexByte(": \t", plaintext[valueStart]) >= 0 {
+			valueStart++
+		}
+		valueEnd := valueStart
+		for valueEnd < len(plaintext) && plaintext[valueEnd] >= '0' && plaintext[valueEnd] <= '9' {
+			valueEnd++
+		}
+		if valueEnd > valueStart {
+			return start, valueStart, valueEnd, true
+		}
+	}
+}
+
+// Inputs derives the inputs of the oracle circuit over the first response
+// record which holds the substring followed by a number, and the public
+// inputs the proxy needs to confirm the record
+func (s *Session) Inputs(substring string, threshold int) (*Inputs, error) {
+
+	for _, ar := range s.records {
+
+		// the kdc circuit derives the first application traffic keys only
+		if ar.contentType != tls13.RecordTypeApplicationData || !bytes.Equal(ar.secret, s.keys.sats) {
+			continue
+		}
+		start, valueStart, valueEnd, ok := locate(ar.plaintext, substring)
+		if !ok {
+			continue
+		}
+
+		// chunk window covering substring and value
+		first := start / blockSize * blockSize
+		end := (valueEnd + blockSize - 1) / blockSize * blockSize
+		inner := ar.innerPlaintext()
+		if end > len(inner) {
+			return nil, fmt.Errorf("value ends within the last partial block of record %d", ar.seq)
+		}
+
+		in := &Inputs{oracle: oracleInputs{
+			kdc:            s.kdcInputs(),
+			nonce:          ar.nonce,
+			ecb0:           encryptBlock(ar.key, counterBlock(ar.nonce, 1)),
+			ecbk:           encryptBlock(ar.key, make([]byte, blockSize)),
+			cipherChunks:   ar.record.Payload[first:end],
+			plainChunks:    inner[first:end],
+			chunkIndex:     first/blockSize + 2,
+			substring:      substring,
+			substringStart: start - first,
+			substringEnd:   start - first + len(substring),
+			valueStart:     valueStart - first,
+			valueEnd:       valueEnd - first,
+			threshold:      threshold,
+		}}
+		in.Combined = s.combinedData(ar, start, in.oracle)
+
+		return in, nil
+	}
+
+	return nil, fmt.Errorf("substring %s followed by a number not found in the response", substring)
+}
+
+// combinedData collects the public inputs posted to /postprocess
+func (s *Session) combinedData(ar appRecord, start int, oracle oracleInputs) u.CombinedData {
+
+	o := oracle
+	recordData := map[string]interface{}{
+		"chunk_index":           strconv.Itoa(o.chunkIndex),
+		"cipher_chunks":         hex.EncodeToString(o.cipherChunks),
+		"number_chunks":         strconv.Itoa(len(o.cipherChunks) / blockSize),
+		"substring":             o.substring,
+		"substring_start":       strconv.Itoa(o.substringStart),
+		"substring_end":         strconv.Itoa(o.substringEnd),
+		"substring_start_idx":   strconv.Itoa(start),
+		"value_start":           strconv.Itoa(o.valueStart),
+		"value_end":             strconv.Itoa(o.valueEnd),
+		"size_value":            strconv.Itoa(o.valueEnd - o.valueStart),
+		"size_area_of_interest": strconv.Itoa(o.valueEnd - o.substringStart + 1),
+	}
+
+	recordTag := map[string]interface{}{
+		u.Seq(ar.seq).String(): map[string]interface{}{
+			"ECB0": hex.EncodeToString(o.ecb0),
+			"ECBK": hex.EncodeToString(o.ecbk),
+		},
+	}
+
+	return u.CombinedData{
+		KDCShared:         s.KDCShared(),
+		RecordTagPublic:   recordTag,
+		RecordDataPublic:  recordData,
+		KDCPublicInput:    s.kdcPublicInput(o.kdc, o.nonce),
+		CloseNotifyPublic: s.closeNotifyPublic(),
+		HTTPPublic:        s.httpPublic(),
+	}
+}
+
+// closeNotifyPublic discloses the tag parameters and the first keystream
+// block of the close_notify record, nil if the server sent none
+func (s *Session) closeNotifyPublic() map[string]interface{} {
+
+	ar := s.closeNotify
+	if ar == nil {
+		return nil
+	}
+	return hexValues(map[string][]byte{
+		"ECB0": encryptBlock(ar.key, counterBlock(ar.nonce, 1)),
+		"ECBK": encryptBlock(ar.key, make([]byte, blockSize)),
+		"ECB1": encryptBlock(ar.key, counterBlock(ar.nonce, 2)),
+	})
+}
+
+// httpPublic declares the framing of an http/1.1 response which starts with
+// the first application data record and spans all following records. nil
+// if the response is not framed by content-length or chunked encoding.
+func (s *Session) httpPublic() map[string]interface{} {
+
+	if s.ALPN != "" && s.ALPN != "http/1.1" {
+		return nil
+	}
+
+	start := -1
+	var response []byte
+	for _, ar := range s.records {
+		if s.closeNotify != nil && ar.seq == s.closeNotify.seq {
+			break
+		}
+		if ar.contentType != tls13.RecordTypeApplicationData {
+			if start >= 0 {
+				return nil
+			}
+			continue
+		}
+		if start < 0 {
+			start = int(ar.seq)
+		}
+		response = append(response, ar.plaintext...)
+	}
+	if start < 0 {
+		return nil
+	}
+
+	layout, err := parseResponseLayout(response)
+	if err != nil {
+		return nil
+	}
+	layout["response_start_seq"] = strconv.Itoa(start)
+	return layout
+}
+
+// parseResponseLayout returns the framing of an http/1.1 response as
+// declared in http_public_input.json
+func parseResponseLayout(response []byte) (map[string]interface{}, error) {
+
+	statusLineEnd := bytes.Index(response, []byte("\r\n"))
+	headerEnd := bytes.Index(response, []byte("\r\n\r\n"))
+	if statusLineEnd < 0 || headerEnd < 0 {
+		return nil, errors.New("incomplete response header")
+	}
+	bodyStart := headerEnd + 4
+
+	layout := map[string]interface{}{
+		"status_line_length": strconv.Itoa(statusLineEnd + 2),
+		"header_length":      strconv.Itoa(bodyStart),
+	}
+
+	contentLength := ""
+	chunked := false
+	for _, line := range strings.Split(string(response[statusLineEnd+2:headerEnd]), "\r\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(name) {
+		case "content-length":
+			contentLength = value
+		case "transfer-encoding":
+			chunked = strings.EqualFold(value, "chunked")
+		}
+	}
+
+	if !chunked {
+		if contentLength == "" {
+			return nil, errors.New("response without content-length")
+		}
+		layout["content_length"] = contentLength
+		return layout, nil
+	}
+
+	// chunk markers are the size lines preceded by the crlf ending the
+	// previous chunk, the last marker includes the final crlf
+	var markers []string
+	pos := bodyStart
+	for {
+		offset := pos
+		if len(markers) > 0 {
+			if !bytes.HasPrefix(response[pos:], []byte("\r\n")) {
+				return nil, errors.New("malformed chunk")
+			}
+			pos += 2
+		}
+		lineEnd := bytes.Index(response[pos:], []byte("\r\n"))
+		if lineEnd < 0 {
+			return nil, errors.New("incomplete chunk size line")
+		}
+		sizeField, _, _ := strings.Cut(string(response[pos:pos+lineEnd]), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 32)
+		if err != nil {
+			return nil, errors.New("malformed chunk size")
+		}
+		pos += lineEnd + 2
+
+		if size == 0 {
+			if !bytes.HasPrefix(response[pos:], []byte("\r\n")) {
+				return nil, errors.New("chunked body with trailers")
+			}
+			pos += 2
+			markers = append(markers, fmt.Sprintf("%d:%d:0", offset, pos-offset))
+			break
+		}
+		markers = append(markers, fmt.Sprintf("%d:%d:%d", offset, pos-offset, size))
+		pos += int(size)
+		if pos > len(response) {
+			return nil, errors.New("incomplete chunk")
+		}
+	}
+
+	layout["transfer_encoding"] = "chunked"
+	layout["body_length"] = strconv.Itoa(pos - bodyStart)
+	layout["chunk_markers"] = strings.Join(markers, ";")
+	return layout, nil
+}
@@ -0,0 +1,96 @@
+package prover
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// KDCShared returns the secrets shared with the proxy in kdc_shared.json.
+// the proxy decrypts the server handshake with SHTS and derives the kdc
+// public inputs from the intermediate hashes, the application keys stay
+// with the prover.
+func (s *Session) KDCShared() map[string]interface{} {
+
+	ks := s.keys
+	keySapp, ivSapp := suite.ExpandLabel(ks.sats, "key", nil, 16), suite.ExpandLabel(ks.sats, "iv", nil, 12)
+	keyCapp, ivCapp := suite.ExpandLabel(ks.cats, "key", nil, 16), suite.ExpandLabel(ks.cats, "iv", nil, 12)
+
+	return hexValues(map[string][]byte{
+		"SHTS":                     ks.shts,
+		"SHTSin":                   innerHash(ks.hs, expandInput("s hs traffic", ks.h2, sha256.Size)),
+		"intermediateHashHSopad":   intermediateHash(ks.hs, opad),
+		"intermediateHashdHSipad":  intermediateHash(ks.dhs, ipad),
+		"intermediateHashMSipad":   intermediateHash(ks.ms, ipad),
+		"intermediateHashSATSipad": intermediateHash(ks.sats, ipad),
+		"intermediateHashCATSipad": intermediateHash(ks.cats, ipad),
+		"hashKeySapp":              sum256(keySapp),
+		"hashIvSapp":               sum256(ivSapp),
+		"hashKeyCapp":              sum256(keyCapp),
+		"hashIvCapp":               sum256(ivCapp),
+	})
+}
+
+// kdcInputs are the public kdc inputs of the oracle circuit, the inner
+// hashes of the hmacs deriving MS, the application traffic secrets and keys
+type kdcInputs struct {
+	hsOpad   []byte
+	msIn     []byte
+	satsIn   []byte
+	catsIn   []byte
+	tkSappIn []byte
+	tkCappIn []byte
+	// private input, the inner hash of the hmac deriving dHS from HS
+	// followed by the padding of the outer hash. the circuit resumes the
+	// outer hash from intermediateHashHSopad with this block to obtain dHS.
+	dhsIn []byte
+}
+
+func (s *Session) kdcInputs() kdcInputs {
+
+	ks := s.keys
+	return kdcInputs{
+		hsOpad:   intermediateHash(ks.hs, opad),
+		msIn:     innerHash(ks.dhs, make([]byte, sha256.Size)),
+		satsIn:   innerHash(ks.ms, expandInput("s ap traffic", ks.h3, sha256.Size)),
+		catsIn:   innerHash(ks.ms, expandInput("c ap traffic", ks.h3, sha256.Size)),
+		tkSappIn: innerHash(ks.sats, expandInput("key", nil, 16)),
+		tkCappIn: innerHash(ks.cats, expandInput("key", nil, 16)),
+		dhsIn:    paddedBlock(innerHash(ks.hs, expandInput("derived", emptyHash[:], sha256.Size))),
+	}
+}
+
+// kdcPublicInput returns the kdc inputs declared in kdc_public_input.json,
+// ivSapp is the nonce of the proven record
+func (s *Session) kdcPublicInput(kdc kdcInputs, nonce []byte) map[string]interface{} {
+
+	shared := s.KDCShared()
+	ivCapp := suite.ExpandLabel(s.keys.cats, "iv", nil, 12)
+
+	values := hexValues(map[string][]byte{
+		"intermediateHashHSopad": kdc.hsOpad,
+		"MSin":                   kdc.msIn,
+		"SATSin":                 kdc.satsIn,
+		"CATSin":                 kdc.catsIn,
+		"tkSAPPin":               kdc.tkSappIn,
+		"tkCAPPin":               kdc.tkCappIn,
+		"ivSapp":                 nonce,
+		"ivCapp":                 ivCapp,
+	})
+	values["hashKeySapp"] = shared["hashKeySapp"]
+	values["hashKeyCapp"] = shared["hashKeyCapp"]
+
+	return values
+}
+
+func sum256(data []byte) []byte {
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+func hexValues(values map[string][]byte) map[string]interface{} {
+	out := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		out[k] = hex.EncodeToString(v)
+	}
+	return out
+}
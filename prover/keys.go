@@ -0,0 +1,107 @@
+package prover
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+
+	"proxy/internal/tls13"
+)
+
+// sha256 of the empty string, the context of derived secrets
+var emptyHash = sha256.Sum256(nil)
+
+// cipher suite of the oracle circuit
+var suite = tls13.CipherSuiteByID(tls13.TLS_AES_128_GCM_SHA256)
+
+// keySchedule holds the secrets of a tls 1.3 handshake without psk, see
+// RFC 8446 7.1. h2 is the transcript hash up to the server hello, h3 up to
+// the server finished.
+type keySchedule struct {
+	hs   []byte
+	chts []byte
+	shts []byte
+	dhs  []byte
+	ms   []byte
+	cats []byte
+	sats []byte
+	h2   []byte
+	h3   []byte
+}
+
+// handshakeSecrets derives the handshake traffic secrets from the ecdhe
+// shared secret
+func (ks *keySchedule) handshakeSecrets(shared []byte, h2 []byte) {
+	zeros := make([]byte, sha256.Size)
+	early := suite.Extract(zeros, zeros)
+	derived := suite.ExpandLabel(early, "derived", emptyHash[:], sha256.Size)
+
+	ks.h2 = h2
+	ks.hs = suite.Extract(derived, shared)
+	ks.chts = suite.ExpandLabel(ks.hs, "c hs traffic", h2, sha256.Size)
+	ks.shts = suite.ExpandLabel(ks.hs, "s hs traffic", h2, sha256.Size)
+}
+
+// applicationSecrets derives the master secret and the first application
+// traffic secrets
+func (ks *keySchedule) applicationSecrets(h3 []byte) {
+	ks.h3 = h3
+	ks.dhs = suite.ExpandLabel(ks.hs, "derived", emptyHash[:], sha256.Size)
+	ks.ms = suite.Extract(ks.dhs, make([]byte, sha256.Size))
+	ks.cats = suite.ExpandLabel(ks.ms, "c ap traffic", h3, sha256.Size)
+	ks.sats = suite.ExpandLabel(ks.ms, "s ap traffic", h3, sha256.Size)
+}
+
+// hmac pads, see RFC 2104
+const (
+	ipad = 0x36
+	opad = 0x5c
+)
+
+// intermediateHash returns the sha256 chaining value after the first block
+// of an hmac under key, which is the key xored with ipad or opad. the kdc
+// circuit resumes the hash from this value.
+func intermediateHash(key []byte, pad byte) []byte {
+	block := make([]byte, sha256.BlockSize)
+	copy(block, key)
+	for i := range block {
+		block[i] ^= pad
+	}
+
+	h := sha256.New()
+	h.Write(block)
+	state, _ := h.(encoding.BinaryMarshaler).MarshalBinary()
+
+	// marshalled state is a 4 byte magic followed by the chaining value
+	return state[4 : 4+sha256.Size]
+}
+
+// innerHash returns the inner hash of the hmac of data under key
+func innerHash(key []byte, data []byte) []byte {
+	block := make([]byte, sha256.BlockSize)
+	copy(block, key)
+	for i := range block {
+		block[i] ^= ipad
+	}
+
+	h := sha256.New()
+	h.Write(block)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// expandInput returns the single block hkdf expand input of a label, the
+// encoded label followed by the counter byte
+func expandInput(label string, context []byte, length int) []byte {
+	return append(tls13.HKDFLabel(label, context, length), 1)
+}
+
+// paddedBlock appends the sha256 padding to an inner hash, which completes
+// the second block of an hmac outer hash
+func paddedBlock(innerHash []byte) []byte {
+	block := make([]byte, sha256.BlockSize)
+	copy(block, innerHash)
+	block[len(innerHash)] = 0x80
+	binary.BigEndian.PutUint64(block[sha256.BlockSize-8:], uint64(sha256.BlockSize+len(innerHash))*8)
+	return block
+}
@@ -0,0 +1,124 @@
+package prover
+
+import (
+	"bytes"
+
+	glg "proxy/tls-zkp/circuits/gadgets"
+	v "proxy/verifier"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/cs/scs"
+)
+
+// circuit returns the oracle circuit sized to the inputs, which compiles to
+// the constraint system the proxy sets up for the session
+func (o *oracleInputs) circuit() *glg.Tls13OracleWrapper {
+	return &glg.Tls13OracleWrapper{
+		PlainChunks:    make([]frontend.Variable, len(o.cipherChunks)),
+		CipherChunks:   make([]frontend.Variable, len(o.cipherChunks)),
+		Substring:      make([]frontend.Variable, len(o.substring)),
+		SubstringStart: o.substringStart,
+		SubstringEnd:   o.substringEnd,
+		ValueStart:     o.valueStart,
+		ValueEnd:       o.valueEnd,
+	}
+}
+
+// assignment returns the full witness of the oracle circuit
+func (o *oracleInputs) assignment() *glg.Tls13OracleWrapper {
+
+	a := o.circuit()
+	assign(a.DHSin[:], o.kdc.dhsIn)
+	assign(a.IntermediateHashHSopad[:], o.kdc.hsOpad)
+	assign(a.MSin[:], o.kdc.msIn)
+	assign(a.SATSin[:], o.kdc.satsIn)
+	assign(a.TkSAPPin[:], o.kdc.tkSappIn)
+	assign(a.IvCounter[:], counterBlock(o.nonce, 1))
+	assign(a.Zeros[:], make([]byte, blockSize))
+	assign(a.ECB0[:], o.ecb0)
+	assign(a.ECBK[:], o.ecbk)
+	assign(a.Iv[:], o.nonce)
+	assign(a.CipherChunks, o.cipherChunks)
+	assign(a.PlainChunks, o.plainChunks)
+	assign(a.Substring, []byte(o.substring))
+	a.ChunkIndex = o.chunkIndex
+	a.Threshold = o.threshold
+
+	return a
+}
+
+func assign(dst []frontend.Variable, src []byte) {
+	for i := range dst {
+		dst[i] = int(src[i])
+	}
+}
+
+// Prove proves the oracle circuit with the proving key returned by the
+// proxy and returns the serialized proof
+func (in *Inputs) Prove(backend string, pk []byte) ([]byte, error) {
+
+	var builder frontend.NewBuilder
+	switch backend {
+	case "groth16":
+		builder = r1cs.NewBuilder
+	case "plonk":
+		builder = scs.NewBuilder
+	default:
+		return nil, v.CheckBackend(backend)
+	}
+
+	ccs, err := frontend.Compile(v.Curve.ScalarField(), builder, in.oracle.circuit())
+	if err != nil {
+		log.Error().Err(err).Msg("frontend.Compile")
+		return nil, err
+	}
+	witness, err := frontend.NewWitness(in.oracle.assignment(), v.Curve.ScalarField())
+	if err != nil {
+		log.Error().Err(err).Msg("frontend.NewWitness")
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	switch backend {
+	case "groth16":
+		key := groth16.NewProvingKey(v.Curve)
+		_, err = key.ReadFrom(bytes.NewReader(pk))
+		if err != nil {
+			log.Error().Err(err).Msg("key.ReadFrom(pk)")
+			return nil, err
+		}
+		proof, err := groth16.Prove(ccs, key, witness)
+		if err != nil {
+			log.Error().Err(err).Msg("groth16.Prove")
+			return nil, err
+		}
+		_, err = proof.WriteTo(&buf)
+		if err != nil {
+			return nil, err
+		}
+
+	case "plonk":
+		key := plonk.NewProvingKey(v.Curve)
+		_, err = key.ReadFrom(bytes.NewReader(pk))
+		if err != nil {
+			log.Error().Err(err).Msg("key.ReadFrom(pk)")
+			return nil, err
+		}
+		proof, err := plonk.Prove(ccs, key, witness)
+		if err != nil {
+			log.Error().Err(err).Msg("plonk.Prove")
+			return nil, err
+		}
+		_, err = proof.WriteTo(&buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
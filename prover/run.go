@@ -0,0 +1,192 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	u "proxy/utils"
+	v "proxy/verifier"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Options of a proven session
+type Options struct {
+	// address of the proxy listener and of the proxy http server
+	Listener string
+	Server   string
+	// https url requested through the proxy
+	URL string
+	// json field name whose number value is proven
+	Substring string
+	// proof system of the proxy
+	Backend string
+	// tls client settings, the server name defaults to the url host
+	Config Config
+}
+
+// Result holds the inputs and the proof submitted for a session and the
+// verification result of the proxy
+type Result struct {
+	Session      u.Session       `json:"session"`
+	Inputs       u.CombinedData  `json:"inputs"`
+	Proof        string          `json:"proof"`
+	Verification json.RawMessage `json:"verification"`
+}
+
+// how long to wait for the listener to finish capturing the session
+var captureTimeout = 10 * time.Second
+
+// stages of a proven session
+const (
+	StageSession     = "session"
+	StageCapture     = "capture"
+	StageInputs      = "inputs"
+	StagePostprocess = "postprocess"
+	StageProve       = "prove"
+	StageVerify      = "verify"
+)
+
+// StageError is an error of Run together with the stage it failed at
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Stage + ": " + e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Run requests the url through the proxy listener, posts the public inputs
+// of the response to /postprocess, proves the oracle circuit with the
+// returned proving key and submits the proof to /verify. errors of the
+// session and later are returned as *StageError.
+func Run(opts Options) (*Result, error) {
+
+	target, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "https" {
+		return nil, errors.New("url must use https")
+	}
+	config := opts.Config
+	if config.ServerName == "" {
+		config.ServerName = target.Hostname()
+	}
+
+	// tls session through the listener
+	dialedAt := time.Now().UTC()
+	s, err := Dial(opts.Listener, config)
+	if err != nil {
+		log.Error().Err(err).Msg("Dial()")
+		return nil, &StageError{StageSession, err}
+	}
+	request := "GET " + target.RequestURI() + " HTTP/1.1\r\n" +
+		"Host: " + target.Host + "\r\n" +
+		"Accept: */*\r\n" +
+		"Connection: close\r\n\r\n"
+	err = s.Write([]byte(request))
+	if err != nil {
+		s.Close()
+		return nil, &StageError{StageSession, err}
+	}
+	err = s.ReadAll()
+	s.Close()
+	if err != nil {
+		log.Error().Err(err).Msg("s.ReadAll()")
+		return nil, &StageError{StageSession, err}
+	}
+
+	api := "http://" + opts.Server
+	session, err := CapturedSession(api, dialedAt)
+	if err != nil {
+		return nil, &StageError{StageCapture, err}
+	}
+
+	inputs, err := s.Inputs(opts.Substring, v.ResponseThreshold)
+	if err != nil {
+		return nil, &StageError{StageInputs, err}
+	}
+	inputs.Combined.SessionNonce = session.Nonce
+
+	body, err := json.Marshal(inputs.Combined)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := post(api+"/postprocess", "application/json", body)
+	if err != nil {
+		return nil, &StageError{StagePostprocess, err}
+	}
+
+	proof, err := inputs.Prove(opts.Backend, pk)
+	if err != nil {
+		return nil, &StageError{StageProve, err}
+	}
+
+	verification, err := post(api+"/verify", "application/octet-stream", proof)
+	if err != nil {
+		return nil, &StageError{StageVerify, err}
+	}
+
+	return &Result{
+		Session:      session,
+		Inputs:       inputs.Combined,
+		Proof:        hex.EncodeToString(proof),
+		Verification: verification,
+	}, nil
+}
+
+// CapturedSession waits until the listener closed the capture of the
+// session started after since and returns it
+func CapturedSession(api string, since time.Time) (u.Session, error) {
+
+	var session u.Session
+	deadline := time.Now().Add(captureTimeout)
+	for time.Now().Before(deadline) {
+
+		resp, err := http.Get(api + "/session")
+		if err == nil {
+			err = json.NewDecoder(resp.Body).Decode(&session)
+			resp.Body.Close()
+		}
+		if err == nil && session.ClosedAt != nil && !session.CapturedAt.Before(since.Add(-time.Second)) {
+			return session, nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return session, errors.New("listener did not capture the session")
+}
+
+// post sends data to the proxy and returns the response body
+func post(url string, contentType string, data []byte) ([]byte, error) {
+
+	resp, err := http.Post(url, contentType, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy responded %s: %s", resp.Status, body)
+	}
+
+	return body, nil
+}
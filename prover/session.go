@@ -0,0 +1,246 @@
+package prover
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"proxy/internal/tls13"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Config configures the tls client of the prover
+type Config struct {
+	// server name indicated to the proxy and verified in the certificate
+	ServerName string
+	// trusted roots, the system pool if nil
+	Roots *x509.CertPool
+	// groups of the key shares of the first client hello, x25519 by
+	// default. the server may select another of Groups by a retry request.
+	KeyShares []uint16
+	Groups    []uint16
+	// source of the client random, session id and key shares, crypto/rand
+	// by default. a fixed source makes the client flight reproducible.
+	Rand io.Reader
+}
+
+func (c Config) rand() io.Reader {
+	if c.Rand == nil {
+		return rand.Reader
+	}
+	return c.Rand
+}
+
+func (c Config) keyShares() []uint16 {
+	if len(c.KeyShares) == 0 {
+		return []uint16{GroupX25519}
+	}
+	return c.KeyShares
+}
+
+func (c Config) groups() []uint16 {
+	if len(c.Groups) == 0 {
+		return []uint16{GroupX25519, GroupP256}
+	}
+	return c.Groups
+}
+
+// appRecord is a protected server record after the handshake. sequence
+// numbers start at zero with the application traffic keys and count all
+// records, as the parser does.
+type appRecord struct {
+	seq         uint64
+	record      tls13.Record
+	contentType uint8
+	plaintext   []byte
+	// nonce, key and secret the record is protected with
+	nonce  []byte
+	key    []byte
+	secret []byte
+}
+
+// Session is a tls 1.3 client session of the prover, which knows all
+// secrets of the session and derives the inputs of the oracle circuit
+type Session struct {
+	config       Config
+	conn         *conn
+	random       []byte
+	sessionID    []byte
+	keys         keySchedule
+	handshakeBuf tls13.HandshakeBuffer
+
+	// negotiated application protocol
+	ALPN string
+	// the server sent a hello retry request
+	Retried bool
+	// the server sent a key update, later records cannot be proven
+	KeyUpdated bool
+
+	// server application records in order
+	records []appRecord
+	// the server closed the connection with a close_notify alert
+	closeNotify *appRecord
+}
+
+// Dial connects to addr, usually the proxy listener, and runs the handshake
+// with the server named in the config
+func Dial(addr string, config Config) (*Session, error) {
+
+	raw, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := Client(raw, config)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Client runs the handshake over an established connection
+func Client(raw net.Conn, config Config) (*Session, error) {
+
+	if config.ServerName == "" {
+		return nil, errors.New("missing server name")
+	}
+
+	s := &Session{config: config, conn: &conn{raw: raw}}
+	err := s.handshake()
+	if err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	return s, nil
+}
+
+// Write sends application data
+func (s *Session) Write(data []byte) error {
+	return s.conn.writeRecords(tls13.RecordTypeApplicationData, data)
+}
+
+// ReadAll reads server records until the server closes the connection
+func (s *Session) ReadAll() error {
+
+	for s.closeNotify == nil {
+		r, err := s.conn.readRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r.ContentType != tls13.RecordTypeApplicationData {
+			return fmt.Errorf("unexpected record type %d after handshake", r.ContentType)
+		}
+
+		read := s.conn.read
+		ar := appRecord{
+			seq:    uint64(len(s.records)),
+			record: r,
+			nonce:  read.Nonce(read.Seq),
+			key:    read.Key,
+			secret: read.Secret,
+		}
+		ar.contentType, ar.plaintext, err = read.Open(r.Header, r.Payload)
+		if err != nil {
+			return err
+		}
+		s.records = append(s.records, ar)
+
+		switch ar.contentType {
+		case tls13.RecordTypeHandshake:
+			err = s.postHandshake(ar.plaintext)
+			if err != nil {
+				return err
+			}
+		case tls13.RecordTypeAlert:
+			if len(ar.plaintext) != 2 || ar.plaintext[1] != tls13.AlertCloseNotify {
+				return fmt.Errorf("server sent alert %x", ar.plaintext)
+			}
+			s.closeNotify = &s.records[len(s.records)-1]
+		}
+	}
+
+	return nil
+}
+
+// postHandshake processes session tickets, which are ignored, and key
+// updates of the server
+func (s *Session) postHandshake(data []byte) error {
+
+	var hb tls13.HandshakeBuffer
+	hb.Write(data)
+	for {
+		msg, ok := hb.Next()
+		if !ok {
+			break
+		}
+
+		switch msg[0] {
+		case tls13.TypeNewSessionTicket:
+		case tls13.TypeKeyUpdate:
+			if len(msg) != tls13.HandshakeHeaderLen+1 {
+				return errors.New("malformed key update")
+			}
+			s.KeyUpdated = true
+			read, err := s.conn.read.Next()
+			if err != nil {
+				return err
+			}
+			s.conn.read = read
+
+			// answer requested updates and update the own keys
+			if msg[tls13.HandshakeHeaderLen] == 1 {
+				err = s.conn.writeRecords(tls13.RecordTypeHandshake, tls13.HandshakeMessage(tls13.TypeKeyUpdate, []byte{0}))
+				if err != nil {
+					return err
+				}
+				s.conn.write, err = s.conn.write.Next()
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unexpected post handshake message %d", msg[0])
+		}
+	}
+
+	if !hb.Empty() {
+		return errors.New("post handshake message fragmented across records")
+	}
+	return nil
+}
+
+// Close sends a close_notify alert and closes the connection
+func (s *Session) Close() error {
+	s.conn.writeRecords(tls13.RecordTypeAlert, []byte{tls13.AlertLevelWarning, tls13.AlertCloseNotify})
+	return s.conn.raw.Close()
+}
+
+// Roots returns the system roots and the ca certificate at caPath, the
+// roots the proxy verifies server certificates against
+func Roots(caPath string) (*x509.CertPool, error) {
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		log.Error().Err(err).Msg("x509.SystemCertPool()")
+		return nil, err
+	}
+	ca, err := os.ReadFile(caPath)
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(caPath)")
+		return nil, err
+	}
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate in %s", caPath)
+	}
+
+	return roots, nil
+}
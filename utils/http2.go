@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"fmt"
+)
+
+// http/2 frame constants, see RFC 9113 6.1
+const (
+	H2FrameHeaderLen = 9
+	H2MaxFrameSize   = 1<<24 - 1
+	H2MaxStreamID    = 1<<31 - 1
+	H2FlagEndStream  = 0x1
+	H2FlagPadded     = 0x8
+)
+
+// DataFrame is an http/2 DATA frame declared by the client, offset points at
+// the 9 byte frame header and length is the payload length including padding
+type DataFrame struct {
+	Offset    int
+	Length    int
+	Stream    int
+	Flags     int
+	PadLength int
+}
+
+// End returns the offset following the frame
+func (f DataFrame) End() int {
+	return f.Offset + H2FrameHeaderLen + f.Length
+}
+
+// ParseDataFrames reads DATA frames of the form
+// "offset:length:stream:flags:padding;..." and checks that frames are
+// ordered without overlap, belong to client initiated streams, carry
+// consistent padding and that no stream continues after END_STREAM
+func ParseDataFrames(s string) ([]DataFrame, error) {
+
+	tuples, err := ParseTuples(s, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []DataFrame
+	ended := make(map[int]bool)
+	end := 0
+	for i, t := range tuples {
+		f := DataFrame{Offset: t[0], Length: t[1], Stream: t[2], Flags: t[3], PadLength: t[4]}
+
+		if f.Offset < end {
+			return nil, fmt.Errorf("data frame %d overlaps the previous frame", i)
+		}
+		if f.Length > H2MaxFrameSize {
+			return nil, fmt.Errorf("data frame %d exceeds the maximum frame size", i)
+		}
+		if f.Stream%2 == 0 || f.Stream > H2MaxStreamID {
+			return nil, fmt.Errorf("data frame %d is not on a client initiated stream", i)
+		}
+		if ended[f.Stream] {
+			return nil, fmt.Errorf("data frame %d follows the end of stream %d", i, f.Stream)
+		}
+		if f.Flags&H2FlagPadded != 0 && f.PadLength+1 > f.Length {
+			return nil, fmt.Errorf("padding exceeds data frame %d", i)
+		}
+		if f.Flags&H2FlagPadded == 0 && f.PadLength != 0 {
+			return nil, fmt.Errorf("padding on unpadded data frame %d", i)
+		}
+
+		ended[f.Stream] = f.Flags&H2FlagEndStream != 0
+		end = f.End()
+		frames = append(frames, f)
+	}
+
+	return frames, nil
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseDataFrames(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		frames  string
+		want    int
+		wantErr bool
+	}{
+		{"single frame", "0:100:1:1:0", 1, false},
+		{"interleaved streams", "0:10:1:0:0;19:10:3:1:0;38:5:1:1:0", 3, false},
+		{"padded frame", "0:10:1:9:4", 1, false},
+		{"empty declaration", "", 0, true},
+		{"malformed tuple", "0:10:1:0", 0, true},
+		{"negative offset", "-1:10:1:0:0", 0, true},
+		{"overlapping frames", "0:10:1:0:0;18:10:1:0:0", 0, true},
+		{"oversized frame", "0:16777216:1:0:0", 0, true},
+		{"server initiated stream", "0:10:2:0:0", 0, true},
+		{"stream id out of range", "0:10:2147483649:0:0", 0, true},
+		{"frame after end of stream", "0:10:1:1:0;19:10:1:0:0", 0, true},
+		{"padding exceeds frame", "0:4:1:8:4", 0, true},
+		{"padding on unpadded frame", "0:10:1:0:2", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, err := ParseDataFrames(tt.frames)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("accepted frames %q", tt.frames)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frames) != tt.want {
+				t.Errorf("%d frames, want %d", len(frames), tt.want)
+			}
+		})
+	}
+}
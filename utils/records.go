@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Seq is a tls record sequence number, json encodes it as 16 hex characters
+type Seq uint64
+
+func (s Seq) String() string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(s))
+	return hex.EncodeToString(b[:])
+}
+
+func (s Seq) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Seq) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil || len(b) != 8 {
+		return fmt.Errorf("invalid sequence number %q", text)
+	}
+	*s = Seq(binary.BigEndian.Uint64(b))
+	return nil
+}
+
+// HexBytes is a byte slice which json encodes as hex string
+type HexBytes []byte
+
+func (h HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+func (h *HexBytes) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*h = b
+	return nil
+}
+
+// Record is a protected application data record of a captured transcript
+type Record struct {
+	Seq Seq `json:"seq"`
+	// encrypted inner plaintext followed by the 16 byte authtag
+	Ciphertext HexBytes `json:"ciphertext"`
+	// 5 byte record header
+	AdditionalData HexBytes `json:"additionalData"`
+}
+
+const tagSize = 16
+
+// CipherChunks returns the ciphertext without authtag
+func (r Record) CipherChunks() []byte {
+	return r.Ciphertext[:len(r.Ciphertext)-tagSize]
+}
+
+// Tag returns the authtag of the record
+func (r Record) Tag() []byte {
+	return r.Ciphertext[len(r.Ciphertext)-tagSize:]
+}
+
+// RecordTagInput holds the tag parameters disclosed by the client for a
+// record: the encrypted counter block zero and the galois key
+type RecordTagInput struct {
+	Seq  Seq      `json:"seq"`
+	ECB0 HexBytes `json:"ECB0"`
+	ECBK HexBytes `json:"ECBK"`
+}
+
+// ConfirmedRecord holds the parameters of a record whose authtag
+// has been verified against the captured ciphertext
+type ConfirmedRecord struct {
+	Seq          Seq      `json:"seq"`
+	Tag          HexBytes `json:"tag"`
+	CipherChunks HexBytes `json:"cipherChunks"`
+	ECB0         HexBytes `json:"ecb0"`
+	ECBK         HexBytes `json:"ecbk"`
+}
+
+// decodes record tag inputs, which clients send keyed by hex sequence number,
+// into a list ordered by sequence number
+func DecodeRecordTagInputs(data []byte) ([]RecordTagInput, error) {
+
+	var objmap map[Seq]RecordTagInput
+	err := json.Unmarshal(data, &objmap)
+	if err != nil {
+		log.Error().Err(err).Msg("json.Unmarshal(data, &objmap)")
+		return nil, err
+	}
+
+	inputs := make([]RecordTagInput, 0, len(objmap))
+	for seq, in := range objmap {
+		in.Seq = seq
+		inputs = append(inputs, in)
+	}
+	sort.Slice(inputs, func(i, j int) bool { return inputs[i].Seq < inputs[j].Seq })
+
+	return inputs, nil
+}
+
+func ReadRecordTagInputs(filePath string) ([]RecordTagInput, error) {
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(filePath)")
+		return nil, err
+	}
+
+	return DecodeRecordTagInputs(data)
+}
+
+// stores confirmed records ordered by sequence number
+func StoreConfirmedRecords(records []ConfirmedRecord, filename string) error {
+
+	sorted := make([]ConfirmedRecord, len(records))
+	copy(sorted, records)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Seq < sorted[j].Seq })
+
+	file, err := json.MarshalIndent(sorted, "", " ")
+	if err != nil {
+		log.Error().Err(err).Msg("json.MarshalIndent")
+		return err
+	}
+
+	err = os.WriteFile("./local_storage/"+filename+".json", file, 0644)
+	if err != nil {
+		log.Error().Err(err).Msg("os.WriteFile")
+		return err
+	}
+	return nil
+}
+
+func ReadConfirmedRecords(filePath string) ([]ConfirmedRecord, error) {
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(filePath)")
+		return nil, err
+	}
+
+	var records []ConfirmedRecord
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		log.Error().Err(err).Msg("json.Unmarshal(data, &records)")
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// reads the single record confirmed by the parser
+func ReadConfirmedRecord(filePath string) (ConfirmedRecord, error) {
+
+	records, err := ReadConfirmedRecords(filePath)
+	if err != nil {
+		return ConfirmedRecord{}, err
+	}
+	if len(records) != 1 {
+		return ConfirmedRecord{}, errors.New("expected exactly one confirmed record")
+	}
+
+	return records[0], nil
+}
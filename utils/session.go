@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var sessionPath = "./local_storage/session.json"
+
+// Session holds the capture metadata written by the listener. the nonce is
+// generated by the proxy, later timestamps are set as the session passes
+// postprocessing and verification.
+type Session struct {
+	Nonce           string     `json:"nonce"`
+	CapturedAt      time.Time  `json:"captured_at"`
+	ClosedAt        *time.Time `json:"closed_at,omitempty"`
+	PostprocessedAt *time.Time `json:"postprocessed_at,omitempty"`
+	VerifiedAt      *time.Time `json:"verified_at,omitempty"`
+	// address of the prover, as announced by a load balancer if any
+	SourceAddr string `json:"source_addr,omitempty"`
+	// client hello as seen by the listener
+	ClientHello *ClientHelloMeta `json:"client_hello,omitempty"`
+}
+
+// ClientHelloMeta holds the client hello offers and fingerprints of a
+// session, grease values are omitted
+type ClientHelloMeta struct {
+	// with ech the server name is the public name of the outer client hello
+	ServerName       string   `json:"server_name"`
+	ECH              bool     `json:"ech"`
+	Versions         []uint16 `json:"versions"`
+	CipherSuites     []uint16 `json:"cipher_suites"`
+	Extensions       []uint16 `json:"extensions"`
+	ALPN             []string `json:"alpn,omitempty"`
+	SupportedGroups  []uint16 `json:"supported_groups,omitempty"`
+	PointFormats     []int    `json:"point_formats,omitempty"`
+	SignatureSchemes []uint16 `json:"signature_schemes,omitempty"`
+	JA3              string   `json:"ja3"`
+	JA3Hash          string   `json:"ja3_hash"`
+	JA4              string   `json:"ja4"`
+}
+
+// starts a session with a fresh nonce
+func NewSession() (Session, error) {
+
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		log.Error().Err(err).Msg("rand.Read(nonce)")
+		return Session{}, err
+	}
+
+	return Session{
+		Nonce:      hex.EncodeToString(nonce),
+		CapturedAt: time.Now().UTC(),
+	}, nil
+}
+
+func ReadSession() (Session, error) {
+
+	var session Session
+
+	data, err := os.ReadFile(sessionPath)
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(sessionPath)")
+		return session, err
+	}
+
+	err = json.Unmarshal(data, &session)
+	if err != nil {
+		log.Error().Err(err).Msg("json.Unmarshal(data, &session)")
+		return session, err
+	}
+
+	return session, nil
+}
+
+func StoreSession(session Session) error {
+
+	file, err := json.MarshalIndent(session, "", " ")
+	if err != nil {
+		log.Error().Err(err).Msg("json.MarshalIndent")
+		return err
+	}
+
+	err = os.WriteFile(sessionPath, file, 0644)
+	if err != nil {
+		log.Error().Err(err).Msg("os.WriteFile")
+		return err
+	}
+	return nil
+}
+
+// checks that at most maxAge passed since t, a zero maxAge disables the check
+func CheckAge(what string, t time.Time, maxAge time.Duration) error {
+
+	if maxAge == 0 {
+		return nil
+	}
+
+	age := time.Since(t)
+	if age > maxAge {
+		return fmt.Errorf("%s %s ago exceeds maximum age %s", what, age.Round(time.Second), maxAge)
+	}
+	if age < 0 {
+		return fmt.Errorf("%s lies in the future", what)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckAge(t *testing.T) {
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		t       time.Time
+		maxAge  time.Duration
+		wantErr bool
+	}{
+		{"fresh session", now.Add(-time.Minute), 10 * time.Minute, false},
+		{"stale session", now.Add(-11 * time.Minute), 10 * time.Minute, true},
+		{"session from the future", now.Add(time.Minute), 10 * time.Minute, true},
+		{"check disabled", now.Add(-24 * time.Hour), 0, false},
+		{"zero capture time", time.Time{}, 10 * time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckAge("session captured", tt.t, tt.maxAge)
+			if tt.wantErr && err == nil {
+				t.Fatal("accepted session age")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"io"
 	"os"
     "path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
@@ -18,6 +20,16 @@ type CombinedData struct {
     RecordTagPublic   map[string]interface{} `json:"recordtag_public"`
     RecordDataPublic  map[string]interface{} `json:"recorddata_public"`
     KDCPublicInput    map[string]interface{} `json:"kdc_public_input"`
+    // optional public input of the client request proof
+    RecordTagClientPublic  map[string]interface{} `json:"recordtag_client_public,omitempty"`
+    RecordDataClientPublic map[string]interface{} `json:"recorddata_client_public,omitempty"`
+    // optional disclosures for response completeness checks
+    CloseNotifyPublic map[string]interface{} `json:"close_notify_public,omitempty"`
+    HTTPPublic        map[string]interface{} `json:"http_public,omitempty"`
+    // inner client hello of sessions with encrypted client hello
+    ECHPublic map[string]interface{} `json:"ech_public,omitempty"`
+    // nonce of the captured session as returned by the proxy, required
+    SessionNonce string `json:"session_nonce"`
 }
 
 func ReadM(filePath string) (map[string]string, error) {
@@ -48,52 +60,6 @@ func ReadM(filePath string) (map[string]string, error) {
 	return objmap, nil
 }
 
-func ReadMM(filePath string) (map[string]string, error) {
-
-	// open file
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Error().Err(err).Msg("os.Open")
-		return nil, err
-	}
-	defer file.Close()
-
-	// read in data
-	data, err := io.ReadAll(file)
-	if err != nil {
-		log.Error().Err(err).Msg("io.ReadAll(file)")
-		return nil, err
-	}
-
-	// parse json
-	var objmap map[string]json.RawMessage
-	err = json.Unmarshal(data, &objmap)
-	if err != nil {
-		log.Error().Err(err).Msg("json.Unmarshal(data, &objmap)")
-		return nil, err
-	}
-
-	// catch server record data
-	innerMapFinal := make(map[string]string)
-	for _, v := range objmap {
-
-		// inner map parsing
-		innerMap := make(map[string]string)
-		err = json.Unmarshal(v, &innerMap)
-		if err != nil {
-			log.Error().Err(err).Msg("json.Unmarshal(v, &innerMap)")
-			return nil, err
-		}
-
-		// copy
-		for k2, v2 := range innerMap {
-			innerMapFinal[k2] = v2
-		}
-	}
-
-	return innerMapFinal, nil
-}
-
 func StoreM(jsonData map[string]string, filename string) error {
 
 	file, err := json.MarshalIndent(jsonData, "", " ")
@@ -109,22 +75,6 @@ func StoreM(jsonData map[string]string, filename string) error {
 	return nil
 }
 
-func StoreMM(mapmap map[string]map[string]string, filename string) error {
-
-	file, err := json.MarshalIndent(mapmap, "", " ")
-	if err != nil {
-		log.Error().Err(err).Msg("json.MarshalIndent")
-		return err
-	}
-
-	err = os.WriteFile("./local_storage/"+filename+".json", file, 0644)
-	if err != nil {
-		log.Error().Err(err).Msg("os.WriteFile")
-		return err
-	}
-	return nil
-}
-
 // serialize gnark object to given file
 func Serialize(gnarkObject io.WriterTo, fileName string) {
 	f, err := os.Create(fileName)
@@ -190,7 +140,33 @@ func StrToIntSlice(inputData string, hexRepresentation bool) []int {
 	return data
 }
 
-func TrascriptStats() error {
+// parses lists of non negative integer tuples of the form "a:b:c;d:e:f",
+// as used by clients to declare http framing, each tuple must have n fields
+func ParseTuples(inputData string, n int) ([][]int, error) {
+
+	var tuples [][]int
+	for _, t := range strings.Split(inputData, ";") {
+		fields := strings.Split(t, ":")
+		if len(fields) != n {
+			return nil, fmt.Errorf("malformed tuple %q", t)
+		}
+		tuple := make([]int, n)
+		for i, f := range fields {
+			v, err := strconv.Atoi(f)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("malformed tuple %q", t)
+			}
+			tuple[i] = v
+		}
+		tuples = append(tuples, tuple)
+	}
+
+	return tuples, nil
+}
+
+// TrascriptStats prints the sizes of the captured transcripts and of the
+// given circuit artifacts
+func TrascriptStats(circuitFiles ...string) error {
 
 	filename1 := "ClientSentRecords.raw"
 	f1, err := getFileInfo("./local_storage/" + filename1)
@@ -208,29 +184,14 @@ func TrascriptStats() error {
 	}
 	fmt.Printf("The file "+filename2+" is %d bytes long.\n", f2.Size())
 
-	filename3 := "oracle_groth16.ccs"
-	f3, err := getFileInfo("./local_storage/circuits/" + filename3)
-	if err != nil {
-		log.Error().Err(err).Msg("getFileInfo")
-		return err
-	}
-	fmt.Printf("The file "+filename3+" is %d bytes long.\n", f3.Size())
-
-	filename4 := "oracle_groth16.pk"
-	f4, err := getFileInfo("./local_storage/circuits/" + filename4)
-	if err != nil {
-		log.Error().Err(err).Msg("getFileInfo")
-		return err
-	}
-	fmt.Printf("The file "+filename4+" is %d bytes long.\n", f4.Size())
-
-	filename5 := "oracle_groth16.vk"
-	f5, err := getFileInfo("./local_storage/circuits/" + filename5)
-	if err != nil {
-		log.Error().Err(err).Msg("getFileInfo")
-		return err
+	for _, path := range circuitFiles {
+		f, err := getFileInfo(path)
+		if err != nil {
+			log.Error().Err(err).Msg("getFileInfo")
+			return err
+		}
+		fmt.Printf("The file "+filepath.Base(path)+" is %d bytes long.\n", f.Size())
 	}
-	fmt.Printf("The file "+filename5+" is %d bytes long.\n", f5.Size())
 
 	return nil
 }
@@ -262,4 +223,17 @@ func SaveJSONToFile(filename string, data map[string]interface{}) error {
         return err
     }
     return nil
-}
\ No newline at end of file
+}
+
+// stores data like SaveJSONToFile, or removes a stale file if data is nil
+func SaveOptionalJSONToFile(filename string, data map[string]interface{}) error {
+    if data != nil {
+        return SaveJSONToFile(filename, data)
+    }
+
+    err := os.Remove(filepath.Join("local_storage", filename))
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
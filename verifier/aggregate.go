@@ -0,0 +1,474 @@
+package verifier
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+
+	u "proxy/utils"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	bls12377_fr "github.com/consensys/gnark-crypto/ecc/bls12-377/fr"
+	"github.com/consensys/gnark-crypto/ecc/bw6-761/fr"
+	"github.com/consensys/gnark-crypto/ecc/bw6-761/fr/mimc"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16_bls12377_backend "github.com/consensys/gnark/backend/groth16/bls12-377"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/std/algebra/native/sw_bls12377"
+	"github.com/consensys/gnark/std/groth16_bls12377"
+	stdmimc "github.com/consensys/gnark/std/hash/mimc"
+)
+
+// aggregation proofs are groth16 proofs on bw6-761, whose scalar field is
+// the base field of bls12-377. the inner proofs must use bls12-377 so that
+// their verification is native arithmetic in the outer circuit.
+const aggregateCurve = ecc.BW6_761
+
+// AggregationCircuit verifies N groth16 proofs of bls12-377 against the same
+// verifying key. the key and the public inputs of all proofs are committed
+// to by their mimc hashes, the only public inputs of the aggregate.
+type AggregationCircuit struct {
+	InnerVK    groth16_bls12377.VerifyingKey
+	Proofs     []groth16_bls12377.Proof
+	Inputs     [][]frontend.Variable
+	VKHash     frontend.Variable `gnark:",public"`
+	InputsHash frontend.Variable `gnark:",public"`
+}
+
+// Define declares the circuit constraints
+func (c *AggregationCircuit) Define(api frontend.API) error {
+
+	h, err := stdmimc.NewMiMC(api)
+	if err != nil {
+		return err
+	}
+	h.Write(vkVariables(&c.InnerVK)...)
+	api.AssertIsEqual(h.Sum(), c.VKHash)
+
+	h.Reset()
+	for i := range c.Proofs {
+		err = verifyInner(api, c.InnerVK, c.Proofs[i], c.Inputs[i])
+		if err != nil {
+			return err
+		}
+		h.Write(c.Inputs[i]...)
+	}
+	api.AssertIsEqual(h.Sum(), c.InputsHash)
+
+	return nil
+}
+
+// verifyInner is groth16_bls12377.Verify with zero public inputs skipped,
+// the incomplete formulas of the scalar multiplication fail on them
+func verifyInner(api frontend.API, vk groth16_bls12377.VerifyingKey, proof groth16_bls12377.Proof, inputs []frontend.Variable) error {
+
+	if len(inputs) != len(vk.G1.K)-1 {
+		return fmt.Errorf("verifying key expects %d public inputs, proof has %d", len(vk.G1.K)-1, len(inputs))
+	}
+
+	// kSum = K₀ + Σ xᵢ Kᵢ₊₁
+	kSum := vk.G1.K[0]
+	for i, x := range inputs {
+		isZero := api.IsZero(x)
+		var ki, sum sw_bls12377.G1Affine
+		ki.ScalarMul(api, vk.G1.K[i+1], api.Select(isZero, 1, x))
+		sum = kSum
+		sum.AddAssign(api, ki)
+		kSum.Select(api, isZero, kSum, sum)
+	}
+
+	// e(kSum, -γ) e(Krs, -δ) e(Ar, Bs) = e(α, β)
+	pairing, err := sw_bls12377.Pair(api, []sw_bls12377.G1Affine{kSum, proof.Krs, proof.Ar}, []sw_bls12377.G2Affine{vk.G2.GammaNeg, vk.G2.DeltaNeg, proof.Bs})
+	if err != nil {
+		return err
+	}
+	vk.E.AssertIsEqual(api, pairing)
+
+	return nil
+}
+
+// vkVariables lists the coordinates of the verifying key in the order they
+// are hashed, in and out of the circuit
+func vkVariables(vk *groth16_bls12377.VerifyingKey) []frontend.Variable {
+	e := &vk.E
+	variables := []frontend.Variable{
+		e.C0.B0.A0, e.C0.B0.A1, e.C0.B1.A0, e.C0.B1.A1, e.C0.B2.A0, e.C0.B2.A1,
+		e.C1.B0.A0, e.C1.B0.A1, e.C1.B1.A0, e.C1.B1.A1, e.C1.B2.A0, e.C1.B2.A1,
+		vk.G2.GammaNeg.X.A0, vk.G2.GammaNeg.X.A1, vk.G2.GammaNeg.Y.A0, vk.G2.GammaNeg.Y.A1,
+		vk.G2.DeltaNeg.X.A0, vk.G2.DeltaNeg.X.A1, vk.G2.DeltaNeg.Y.A0, vk.G2.DeltaNeg.Y.A1,
+	}
+	for _, k := range vk.G1.K {
+		variables = append(variables, k.X, k.Y)
+	}
+	return variables
+}
+
+// Aggregate is a recursive proof over the proofs of a circuit, inputs holds
+// the public inputs of every aggregated proof as decimal strings. the inner
+// verifying key is the archived key of the given hash.
+type Aggregate struct {
+	Circuit          string     `json:"circuit"`
+	VerifyingKeyHash string     `json:"verifying_key_hash,omitempty"`
+	VKHash           string     `json:"vk_hash"`
+	InputsHash       string     `json:"inputs_hash"`
+	Inputs           [][]string `json:"inputs"`
+	Proof            string     `json:"proof"`
+}
+
+// path of the keys of the aggregation circuit, the circuit only depends on
+// the number of proofs and their public inputs
+func aggregatePath(nbProofs int, nbPublic int, ext string) string {
+	return fmt.Sprintf("./local_storage/circuits/aggregate_%dx%d_%s_groth16%s", nbProofs, nbPublic, aggregateCurve, ext)
+}
+
+// checks the curve of the inner proofs. the keys of the aggregation circuit
+// come from a setup run by the verifier, ceremonies cover bn254 circuits only,
+// so aggregation is refused if keys must stem from a ceremony.
+func checkAggregateCurve() error {
+	if Curve != ecc.BLS12_377 {
+		return fmt.Errorf("aggregation requires bls12_377 proofs, circuits use %s", Curve)
+	}
+	if RequireCeremony {
+		return fmt.Errorf("aggregation keys on %s cannot stem from a ceremony", aggregateCurve)
+	}
+	return nil
+}
+
+// the in-circuit verifier does not check pedersen commitments
+func checkInnerVerifyingKey(vk groth16.VerifyingKey) error {
+	_vk, ok := vk.(*groth16_bls12377_backend.VerifyingKey)
+	if !ok {
+		return errors.New("unexpected groth16 verifying key type")
+	}
+	if len(_vk.PublicAndCommitmentCommitted) > 0 {
+		return errors.New("aggregation does not support circuits with commitments")
+	}
+	return nil
+}
+
+// AggregateBatch proves that all proofs of the batch verify against the
+// verifying key of the batch. every proof is verified before aggregation.
+func AggregateBatch(batch Batch) (*Aggregate, error) {
+
+	err := checkAggregateCurve()
+	if err != nil {
+		return nil, err
+	}
+	err = CheckCircuit(batch.Circuit)
+	if err != nil {
+		return nil, err
+	}
+	if len(batch.Items) == 0 {
+		return nil, errors.New("no proofs to aggregate")
+	}
+
+	nullifiers, err := batchNullifiers(batch)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := readBatchVerifyingKey("groth16", batch, nullifiers)
+	if err != nil {
+		return nil, err
+	}
+	err = checkInnerVerifyingKey(bv.groth16)
+	if err != nil {
+		return nil, err
+	}
+
+	var assignment AggregationCircuit
+	assignment.InnerVK.Assign(bv.groth16)
+	assignment.Proofs = make([]groth16_bls12377.Proof, len(batch.Items))
+	assignment.Inputs = make([][]frontend.Variable, len(batch.Items))
+
+	nbPublic := 0
+	inputs := make([][]fr.Element, len(batch.Items))
+	for i, item := range batch.Items {
+		p, _, err := bv.decode(item, nullifiers)
+		if err != nil {
+			return nil, fmt.Errorf("proof %d: %w", i, err)
+		}
+		err = bv.verify(p)
+		if err != nil {
+			return nil, fmt.Errorf("proof %d: %w", i, err)
+		}
+
+		vector, ok := p.witness.Vector().(bls12377_fr.Vector)
+		if !ok {
+			return nil, witness.ErrInvalidWitness
+		}
+		nbPublic = len(vector)
+		inputs[i] = make([]fr.Element, len(vector))
+		assignment.Inputs[i] = make([]frontend.Variable, len(vector))
+		for j := range vector {
+			var b big.Int
+			inputs[i][j].SetBigInt(vector[j].BigInt(&b))
+			assignment.Inputs[i][j] = inputs[i][j]
+		}
+		assignment.Proofs[i].Assign(p.proof.(groth16.Proof))
+	}
+
+	elements, err := vkElements(&assignment.InnerVK)
+	if err != nil {
+		return nil, err
+	}
+	vkHash := hashElements(elements)
+	var all []fr.Element
+	for i := range inputs {
+		all = append(all, inputs[i]...)
+	}
+	inputsHash := hashElements(all)
+	assignment.VKHash = vkHash
+	assignment.InputsHash = inputsHash
+
+	ccs, pk, err := aggregationKeys(bv.groth16, len(batch.Items), nbPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := frontend.NewWitness(&assignment, aggregateCurve.ScalarField())
+	if err != nil {
+		log.Error().Err(err).Msg("frontend.NewWitness()")
+		return nil, err
+	}
+	proof, err := groth16.Prove(ccs, pk, w)
+	if err != nil {
+		log.Error().Err(err).Msg("groth16.Prove()")
+		return nil, err
+	}
+	var buf bytes.Buffer
+	_, err = proof.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregate := &Aggregate{
+		Circuit:          batch.Circuit,
+		VerifyingKeyHash: bv.vkHash,
+		VKHash:           hex.EncodeToString(vkHash.Marshal()),
+		InputsHash:       hex.EncodeToString(inputsHash.Marshal()),
+		Inputs:           make([][]string, len(inputs)),
+		Proof:            hex.EncodeToString(buf.Bytes()),
+	}
+	for i := range inputs {
+		aggregate.Inputs[i] = make([]string, len(inputs[i]))
+		for j := range inputs[i] {
+			aggregate.Inputs[i][j] = inputs[i][j].String()
+		}
+	}
+	return aggregate, nil
+}
+
+// VerifyAggregate verifies the aggregate against the archived verifying key
+// it names, the current key of its circuit if it names none, and the public
+// inputs it lists
+func VerifyAggregate(aggregate Aggregate) error {
+
+	err := checkAggregateCurve()
+	if err != nil {
+		return err
+	}
+	err = CheckCircuit(aggregate.Circuit)
+	if err != nil {
+		return err
+	}
+	if len(aggregate.Inputs) == 0 {
+		return errors.New("aggregate holds no proofs")
+	}
+
+	innerVK := groth16.NewVerifyingKey(Curve)
+	data, err := readPinnedVerifyingKey(aggregate.Circuit, "groth16", aggregate.VerifyingKeyHash)
+	if err != nil {
+		return err
+	}
+	err = decodeVerifyingKey(innerVK, data)
+	if err != nil {
+		return err
+	}
+	err = checkInnerVerifyingKey(innerVK)
+	if err != nil {
+		return err
+	}
+	var vk groth16_bls12377.VerifyingKey
+	vk.Assign(innerVK)
+	nbPublic := len(vk.G1.K) - 1
+
+	// the public inputs of the aggregate are recomputed, not taken as given
+	var all []fr.Element
+	for i, signals := range aggregate.Inputs {
+		if len(signals) != nbPublic {
+			return fmt.Errorf("proof %d holds %d public inputs, verifying key expects %d", i, len(signals), nbPublic)
+		}
+		for _, s := range signals {
+			b, ok := new(big.Int).SetString(s, 10)
+			if !ok || b.Sign() < 0 || b.Cmp(Curve.ScalarField()) >= 0 {
+				return fmt.Errorf("invalid public input %q", s)
+			}
+			var e fr.Element
+			e.SetBigInt(b)
+			all = append(all, e)
+		}
+	}
+	elements, err := vkElements(&vk)
+	if err != nil {
+		return err
+	}
+	vkHash := hashElements(elements)
+	inputsHash := hashElements(all)
+	if hex.EncodeToString(vkHash.Marshal()) != aggregate.VKHash {
+		return errors.New("aggregate was not produced for the verifying key of the circuit")
+	}
+	if hex.EncodeToString(inputsHash.Marshal()) != aggregate.InputsHash {
+		return errors.New("aggregate does not commit to the listed public inputs")
+	}
+
+	aggregateVK, err := aggregationVerifyingKey(len(aggregate.Inputs), nbPublic)
+	if err != nil {
+		return err
+	}
+
+	proofData, err := hex.DecodeString(aggregate.Proof)
+	if err != nil {
+		return fmt.Errorf("proof is not hex encoded: %w", err)
+	}
+	proof := groth16.NewProof(aggregateCurve)
+	n, err := proof.ReadFrom(bytes.NewReader(proofData))
+	if err != nil || n != int64(len(proofData)) {
+		return fmt.Errorf("proof is not encoded on curve %s", aggregateCurve)
+	}
+
+	publicWitness, err := witness.New(aggregateCurve.ScalarField())
+	if err != nil {
+		return err
+	}
+	values := make(chan any, 2)
+	values <- vkHash
+	values <- inputsHash
+	close(values)
+	err = publicWitness.Fill(2, 0, values)
+	if err != nil {
+		return err
+	}
+
+	return groth16.Verify(proof, aggregateVK, publicWitness)
+}
+
+// only one aggregation setup runs at a time, concurrent setups of the same
+// size would write the same key files
+var aggregateMu sync.Mutex
+
+// aggregationKeys loads the constraint system and proving key of the
+// aggregation circuit, compiling the circuit and running the setup on first
+// use. the proving key is stored uncompressed, decompressing bw6-761 points
+// dominates the loading time otherwise.
+func aggregationKeys(innerVK groth16.VerifyingKey, nbProofs int, nbPublic int) (constraint.ConstraintSystem, groth16.ProvingKey, error) {
+
+	aggregateMu.Lock()
+	defer aggregateMu.Unlock()
+
+	ccs := groth16.NewCS(aggregateCurve)
+	pk := groth16.NewProvingKey(aggregateCurve)
+
+	ccsData, errCCS := os.ReadFile(aggregatePath(nbProofs, nbPublic, ".ccs"))
+	pkData, errPK := os.ReadFile(aggregatePath(nbProofs, nbPublic, ".pk"))
+	if errCCS == nil && errPK == nil {
+		_, err := ccs.ReadFrom(bytes.NewReader(ccsData))
+		if err != nil {
+			log.Error().Err(err).Msg("ccs.ReadFrom()")
+			return nil, nil, err
+		}
+		// the key has been written by this verifier
+		_, err = pk.UnsafeReadFrom(bytes.NewReader(pkData))
+		if err != nil {
+			log.Error().Err(err).Msg("pk.UnsafeReadFrom()")
+			return nil, nil, err
+		}
+		return ccs, pk, nil
+	}
+
+	circuit := AggregationCircuit{
+		Proofs: make([]groth16_bls12377.Proof, nbProofs),
+		Inputs: make([][]frontend.Variable, nbProofs),
+	}
+	circuit.InnerVK.Allocate(innerVK)
+	for i := range circuit.Inputs {
+		circuit.Inputs[i] = make([]frontend.Variable, nbPublic)
+	}
+
+	_ccs, err := frontend.Compile(aggregateCurve.ScalarField(), r1cs.NewBuilder, &circuit)
+	if err != nil {
+		log.Error().Err(err).Msg("frontend.Compile")
+		return nil, nil, err
+	}
+	_pk, vk, err := groth16.Setup(_ccs)
+	if err != nil {
+		log.Error().Err(err).Msg("groth16.Setup")
+		return nil, nil, err
+	}
+
+	var raw bytes.Buffer
+	_, err = _pk.WriteRawTo(&raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = os.WriteFile(aggregatePath(nbProofs, nbPublic, ".pk"), raw.Bytes(), 0644)
+	if err != nil {
+		log.Error().Err(err).Msg("os.WriteFile(pk)")
+		return nil, nil, err
+	}
+	u.Serialize(_ccs, aggregatePath(nbProofs, nbPublic, ".ccs"))
+	u.Serialize(vk, aggregatePath(nbProofs, nbPublic, ".vk"))
+
+	return _ccs, _pk, nil
+}
+
+// aggregationVerifyingKey reads the verifying key of the aggregation circuit
+func aggregationVerifyingKey(nbProofs int, nbPublic int) (groth16.VerifyingKey, error) {
+
+	data, err := os.ReadFile(aggregatePath(nbProofs, nbPublic, ".vk"))
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(vk)")
+		return nil, fmt.Errorf("no aggregation keys for %d proofs with %d public inputs: %w", nbProofs, nbPublic, err)
+	}
+
+	vk := groth16.NewVerifyingKey(aggregateCurve)
+	_, err = vk.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		log.Error().Err(err).Msg("vk.ReadFrom()")
+		return nil, err
+	}
+	return vk, nil
+}
+
+// returns the assigned coordinates of the verifying key as field elements
+func vkElements(vk *groth16_bls12377.VerifyingKey) ([]fr.Element, error) {
+	variables := vkVariables(vk)
+	elements := make([]fr.Element, len(variables))
+	for i, v := range variables {
+		_, err := elements[i].SetInterface(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
+
+// hashElements computes the hash of the circuit outside of the circuit
+func hashElements(elements []fr.Element) fr.Element {
+	h := mimc.NewMiMC()
+	for _, e := range elements {
+		b := e.Marshal()
+		h.Write(b)
+	}
+	var sum fr.Element
+	sum.SetBytes(h.Sum(nil))
+	return sum
+}
@@ -0,0 +1,464 @@
+package verifier
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"runtime"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// number of groth16 proofs checked with a single multi-pairing, a failing
+// chunk is verified proof by proof to find the invalid ones
+const batchChunkSize = 16
+
+// Batch holds stored proofs of one circuit to be verified again, e.g. for
+// audits or after migrations
+type Batch struct {
+	Circuit string `json:"circuit"`
+	// hex encoded verifying key the proofs were generated for. only accepted
+	// from the command line, the key is not checked against the circuit.
+	VerifyingKey string `json:"verifying_key,omitempty"`
+	// hash of an archived verifying key of the circuit. defaults to the key
+	// pinned by the nullifiers of the sessions, else the current key.
+	VerifyingKeyHash string      `json:"verifying_key_hash,omitempty"`
+	Items            []BatchItem `json:"items"`
+}
+
+// BatchItem is a proof with its public witness. proofs are hex encoded or
+// snarkjs json, public witnesses hex encoded or snarkjs public signals.
+type BatchItem struct {
+	// nonce of the session, if set the witness must match the nullifier
+	// recorded at the verification of the session
+	Session       string          `json:"session,omitempty"`
+	Proof         json.RawMessage `json:"proof"`
+	PublicWitness json.RawMessage `json:"public_witness"`
+}
+
+// BatchResult is the verification result of the item at Index
+type BatchResult struct {
+	Index     int    `json:"index"`
+	Session   string `json:"session,omitempty"`
+	Valid     bool   `json:"valid"`
+	Nullifier string `json:"nullifier,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type batchProof struct {
+	index   int
+	proof   io.ReaderFrom
+	witness witness.Witness
+}
+
+type batchVerifier struct {
+	backend  string
+	vkHash   string
+	groth16  groth16.VerifyingKey
+	plonk    plonk.VerifyingKey
+	batching bool
+}
+
+// VerifyBatch verifies the items of the batch concurrently with the given
+// number of workers. groth16 proofs on bn254 are checked in chunks with
+// batched pairings. errors of single items are reported in their results.
+func VerifyBatch(backend string, batch Batch, workers int) ([]BatchResult, error) {
+
+	err := CheckBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+	err = CheckCircuit(batch.Circuit)
+	if err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// the registry is read once, sessions are not consumed again
+	nullifiers, err := batchNullifiers(batch)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := readBatchVerifyingKey(backend, batch, nullifiers)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(batch.Items))
+	proofs := make([]*batchProof, len(batch.Items))
+
+	g := new(errgroup.Group)
+	g.SetLimit(workers)
+	for i := range batch.Items {
+		i := i
+		g.Go(func() error {
+			results[i].Index = i
+			results[i].Session = batch.Items[i].Session
+			proof, nullifier, err := bv.decode(batch.Items[i], nullifiers)
+			results[i].Nullifier = nullifier
+			if err != nil {
+				results[i].Error = err.Error()
+				return nil
+			}
+			proof.index = i
+			proofs[i] = proof
+			return nil
+		})
+	}
+	g.Wait()
+
+	// decoded proofs are verified in chunks, without batching one by one
+	chunkSize := 1
+	if bv.batching {
+		chunkSize = batchChunkSize
+	}
+	var chunks [][]*batchProof
+	var chunk []*batchProof
+	for _, proof := range proofs {
+		if proof == nil {
+			continue
+		}
+		chunk = append(chunk, proof)
+		if len(chunk) == chunkSize {
+			chunks = append(chunks, chunk)
+			chunk = nil
+		}
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	g = new(errgroup.Group)
+	g.SetLimit(workers)
+	for _, chunk := range chunks {
+		chunk := chunk
+		g.Go(func() error {
+			if len(chunk) > 1 {
+				ok, err := batchVerifyGroth16(bv.groth16.(*groth16_bn254.VerifyingKey), chunk)
+				if err != nil {
+					log.Debug().Err(err).Msg("batchVerifyGroth16()")
+				}
+				if ok {
+					for _, p := range chunk {
+						results[p.index].Valid = true
+					}
+					return nil
+				}
+			}
+			for _, p := range chunk {
+				err := bv.verify(p)
+				if err != nil {
+					results[p.index].Error = err.Error()
+					continue
+				}
+				results[p.index].Valid = true
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	return results, nil
+}
+
+// reads the verifying key of the batch. without an explicit key the proofs
+// are verified against the key their sessions have been verified with, a
+// later setup of the circuit does not invalidate them.
+func readBatchVerifyingKey(backend string, batch Batch, nullifiers map[string]Nullifier) (*batchVerifier, error) {
+
+	data, err := hex.DecodeString(batch.VerifyingKey)
+	if err != nil {
+		return nil, fmt.Errorf("verifying key is not hex encoded: %w", err)
+	}
+	if len(data) == 0 {
+		vkHash := batch.VerifyingKeyHash
+		if vkHash == "" {
+			vkHash, err = pinnedKeyHash(batch, nullifiers)
+			if err != nil {
+				return nil, err
+			}
+		}
+		data, err = readPinnedVerifyingKey(batch.Circuit, backend, vkHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bv := &batchVerifier{backend: backend, vkHash: fileHash(data)}
+	switch backend {
+	case "groth16":
+		bv.groth16 = groth16.NewVerifyingKey(Curve)
+		err = decodeVerifyingKey(bv.groth16, data)
+		if err != nil {
+			return nil, err
+		}
+		// proofs with pedersen commitments are verified one by one
+		_vk, ok := bv.groth16.(*groth16_bn254.VerifyingKey)
+		bv.batching = ok && len(_vk.PublicAndCommitmentCommitted) == 0
+
+	case "plonk":
+		bv.plonk = plonk.NewVerifyingKey(Curve)
+		err = decodeVerifyingKey(bv.plonk, data)
+		if err != nil {
+			return nil, err
+		}
+		err = checkVerifyingKeySRS(bv.plonk)
+		if err != nil {
+			log.Error().Err(err).Msg("checkVerifyingKeySRS(vk)")
+			return nil, err
+		}
+	}
+
+	return bv, nil
+}
+
+// returns the nullifiers of the sessions verified with the circuit, nil if
+// no item of the batch names a session
+func batchNullifiers(batch Batch) (map[string]Nullifier, error) {
+
+	sessions := false
+	for _, item := range batch.Items {
+		sessions = sessions || item.Session != ""
+	}
+	if !sessions {
+		return nil, nil
+	}
+
+	nullifierMu.Lock()
+	defer nullifierMu.Unlock()
+
+	nullifiers, err := readNullifiers()
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := make(map[string]Nullifier)
+	for _, n := range nullifiers {
+		if n.Circuit == batch.Circuit {
+			recorded[n.Session] = n
+		}
+	}
+	return recorded, nil
+}
+
+// returns the verifying key hash pinned by the sessions of the batch, empty
+// if none is pinned. sessions verified with different keys must be split
+// into one batch per key.
+func pinnedKeyHash(batch Batch, nullifiers map[string]Nullifier) (string, error) {
+
+	var vkHash string
+	for _, item := range batch.Items {
+		n, ok := nullifiers[item.Session]
+		if !ok || n.VerifyingKeyHash == "" {
+			continue
+		}
+		if vkHash != "" && vkHash != n.VerifyingKeyHash {
+			return "", errors.New("sessions of the batch have been verified with different verifying keys")
+		}
+		vkHash = n.VerifyingKeyHash
+	}
+	return vkHash, nil
+}
+
+// decodes proof and public witness of the item and binds the witness to
+// the nullifier of the session
+func (bv *batchVerifier) decode(item BatchItem, nullifiers map[string]Nullifier) (*batchProof, string, error) {
+
+	var p batchProof
+	if bv.backend == "groth16" {
+		p.proof = groth16.NewProof(Curve)
+	} else {
+		p.proof = plonk.NewProof(Curve)
+	}
+	data, err := batchProofData(item.Proof)
+	if err != nil {
+		return nil, "", err
+	}
+	err = decodeProof(p.proof, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	p.witness, err = batchWitness(item.PublicWitness)
+	if err != nil {
+		return nil, "", err
+	}
+	nullifier, err := WitnessHash(p.witness)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if item.Session != "" {
+		recorded, ok := nullifiers[item.Session]
+		if !ok {
+			return nil, nullifier, fmt.Errorf("session %s has not been verified", item.Session)
+		}
+		if recorded.WitnessHash != nullifier {
+			return nil, nullifier, fmt.Errorf("session %s has been verified with a different witness", item.Session)
+		}
+		if recorded.VerifyingKeyHash != "" && recorded.VerifyingKeyHash != bv.vkHash {
+			return nil, nullifier, fmt.Errorf("session %s has been verified with verifying key %s", item.Session, recorded.VerifyingKeyHash)
+		}
+	}
+
+	return &p, nullifier, nil
+}
+
+func batchProofData(raw json.RawMessage) ([]byte, error) {
+
+	raw = bytes.TrimSpace(raw)
+	if bytes.HasPrefix(raw, []byte("{")) {
+		return ProofFromSnarkJS(raw)
+	}
+
+	var s string
+	err := json.Unmarshal(raw, &s)
+	if err != nil {
+		return nil, errors.New("proof is neither hex encoded nor snarkjs json")
+	}
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("proof is not hex encoded: %w", err)
+	}
+	return data, nil
+}
+
+func batchWitness(raw json.RawMessage) (witness.Witness, error) {
+
+	w, err := witness.New(Curve.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+
+	var signals []string
+	if json.Unmarshal(raw, &signals) == nil {
+		// public signals must be reduced as in the solidity verifier
+		values := make(chan any, len(signals))
+		for _, s := range signals {
+			b, ok := new(big.Int).SetString(s, 10)
+			if !ok || b.Sign() < 0 || b.Cmp(Curve.ScalarField()) >= 0 {
+				return nil, fmt.Errorf("invalid public signal %q", s)
+			}
+			values <- b
+		}
+		close(values)
+		err = w.Fill(len(signals), 0, values)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+
+	var s string
+	err = json.Unmarshal(raw, &s)
+	if err != nil {
+		return nil, errors.New("public witness is neither hex encoded nor snarkjs public signals")
+	}
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("public witness is not hex encoded: %w", err)
+	}
+	err = w.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (bv *batchVerifier) verify(p *batchProof) error {
+	if bv.backend == "groth16" {
+		return groth16.Verify(p.proof.(groth16.Proof), bv.groth16, p.witness)
+	}
+	return plonk.Verify(p.proof.(plonk.Proof), bv.plonk, p.witness)
+}
+
+// batchVerifyGroth16 checks all proofs of the chunk at once. with random rᵢ
+//
+//	∏ e(rᵢAᵢ, Bᵢ) = e(Σ rᵢ α, β) e(Σ rᵢ Lᵢ, γ) e(Σ rᵢ Cᵢ, δ)
+//
+// holds if all proofs are valid and otherwise fails except with negligible
+// probability. Lᵢ = K₀ + Σⱼ wᵢⱼ Kⱼ₊₁ are summed up in a single multi-exp.
+func batchVerifyGroth16(vk *groth16_bn254.VerifyingKey, chunk []*batchProof) (bool, error) {
+
+	if Curve != ecc.BN254 {
+		return false, errors.New("batched pairings require bn254")
+	}
+
+	nbPublic := len(vk.G1.K) - 1
+	P := make([]bn254.G1Affine, 0, len(chunk)+3)
+	Q := make([]bn254.G2Affine, 0, len(chunk)+3)
+	C := make([]bn254.G1Affine, len(chunk))
+	r := make([]fr.Element, len(chunk))
+	k := make([]fr.Element, len(vk.G1.K))
+
+	for i, p := range chunk {
+		proof, ok := p.proof.(*groth16_bn254.Proof)
+		if !ok || len(proof.Commitments) > 0 {
+			return false, errors.New("unexpected groth16 proof")
+		}
+		inputs, ok := p.witness.Vector().(fr.Vector)
+		if !ok || len(inputs) != nbPublic {
+			return false, witness.ErrInvalidWitness
+		}
+		if !proof.Ar.IsInSubGroup() || !proof.Bs.IsInSubGroup() || !proof.Krs.IsInSubGroup() {
+			return false, errors.New("proof points are not in the subgroup")
+		}
+
+		_, err := r[i].SetRandom()
+		if err != nil {
+			return false, err
+		}
+		var b big.Int
+		r[i].BigInt(&b)
+
+		var a bn254.G1Affine
+		a.ScalarMultiplication(&proof.Ar, &b)
+		P = append(P, a)
+		Q = append(Q, proof.Bs)
+		C[i] = proof.Krs
+
+		k[0].Add(&k[0], &r[i])
+		for j := range inputs {
+			var t fr.Element
+			t.Mul(&r[i], &inputs[j])
+			k[j+1].Add(&k[j+1], &t)
+		}
+	}
+
+	config := ecc.MultiExpConfig{}
+	var l, c, alpha bn254.G1Affine
+	_, err := l.MultiExp(vk.G1.K, k, config)
+	if err != nil {
+		return false, err
+	}
+	_, err = c.MultiExp(C, r, config)
+	if err != nil {
+		return false, err
+	}
+	var sum big.Int
+	k[0].BigInt(&sum)
+	alpha.ScalarMultiplication(&vk.G1.Alpha, &sum)
+
+	l.Neg(&l)
+	c.Neg(&c)
+	alpha.Neg(&alpha)
+	P = append(P, alpha, l, c)
+	Q = append(Q, vk.G2.Beta, vk.G2.Gamma, vk.G2.Delta)
+
+	return bn254.PairingCheck(P, Q)
+}
@@ -0,0 +1,91 @@
+package verifier
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// stored proofs stay verifiable after a new setup of the circuit, against
+// the verifying key pinned at the verification of their session
+func TestBatchPinnedVerifyingKey(t *testing.T) {
+	chdirStorage(t)
+
+	public := proveCubic(t, OracleCircuit)
+	vkHash, err := VerifyCircuit("groth16", OracleCircuit, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessHash, err := WitnessHash(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ConsumeSession(OracleCircuit, "session", witnessHash, vkHash, ReverifyReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := os.ReadFile(ArtifactPath(OracleCircuit, "groth16", ".proof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := public.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := BatchItem{
+		Proof:         json.RawMessage(strconv.Quote(hex.EncodeToString(proof))),
+		PublicWitness: json.RawMessage(strconv.Quote(hex.EncodeToString(data))),
+	}
+	withSession := item
+	withSession.Session = "session"
+
+	// the new setup replaces the current verifying key
+	proveCubic(t, OracleCircuit)
+
+	tests := []struct {
+		name    string
+		batch   Batch
+		valid   bool
+		wantErr bool
+	}{
+		{"key pinned by the session", Batch{Items: []BatchItem{withSession}}, true, false},
+		{"key selected by hash", Batch{VerifyingKeyHash: vkHash, Items: []BatchItem{item}}, true, false},
+		{"current key", Batch{Items: []BatchItem{item}}, false, false},
+		{"session pinned to another key", Batch{VerifyingKeyHash: hashOf(t, OracleCircuit), Items: []BatchItem{withSession}}, false, false},
+		{"hash is no path", Batch{VerifyingKeyHash: "../oracle_bn254_groth16", Items: []BatchItem{item}}, false, true},
+		{"key is not archived", Batch{VerifyingKeyHash: fileHash(nil), Items: []BatchItem{item}}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.batch.Circuit = OracleCircuit
+			results, err := VerifyBatch("groth16", tt.batch, 1)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("batch was verified")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if results[0].Valid != tt.valid {
+				t.Errorf("valid %v, want %v: %s", results[0].Valid, tt.valid, results[0].Error)
+			}
+		})
+	}
+}
+
+// returns the hash of the current verifying key of the circuit
+func hashOf(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(ArtifactPath(name, "groth16", ".vk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileHash(data)
+}
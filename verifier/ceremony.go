@@ -0,0 +1,626 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	u "proxy/utils"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/backend/groth16/bn254/mpcsetup"
+	"github.com/consensys/gnark/constraint"
+	cs_bn254 "github.com/consensys/gnark/constraint/bn254"
+)
+
+// groth16 phase-2 ceremonies are kept in a directory per circuit, which holds
+// everything an auditor needs to check the ceremony offline:
+//
+//	phase1.bin        powers of tau the ceremony was initialized from
+//	circuit.ccs       constraint system the keys are specific to
+//	phase2_NNNN.bin   initial parameters and each contribution
+//	transcript.json   contributors and hashes of all files
+//	<circuit>_groth16.pk/.vk after finalization
+var CeremonyDir = "./local_storage/ceremony"
+
+// RequireCeremony refuses groth16 setups with keys generated in process
+var RequireCeremony bool
+
+// only one contribution is applied at a time
+var ceremonyMu sync.Mutex
+
+// Transcript records the phase-2 ceremony of a circuit
+type Transcript struct {
+	Circuit       string         `json:"circuit"`
+	Phase1Hash    string         `json:"phase1_hash"`
+	CCSHash       string         `json:"ccs_hash"`
+	Contributions []Contribution `json:"contributions"`
+	FinalizedAt   *time.Time     `json:"finalized_at,omitempty"`
+	PKHash        string         `json:"pk_hash,omitempty"`
+	VKHash        string         `json:"vk_hash,omitempty"`
+}
+
+// Contribution is a verified ceremony step, the first one holds the
+// initial parameters
+type Contribution struct {
+	Index       int       `json:"index"`
+	Contributor string    `json:"contributor"`
+	File        string    `json:"file"`
+	FileHash    string    `json:"file_hash"`
+	Hash        string    `json:"hash"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+func ceremonyPath(name string) (string, error) {
+	err := CheckCircuit(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(CeremonyDir, name), nil
+}
+
+func contributionFile(index int) string {
+	return fmt.Sprintf("phase2_%04d.bin", index)
+}
+
+func fileHash(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// hash of the serialized gnark object
+func objectHash(object io.WriterTo) (string, error) {
+	h := sha256.New()
+	_, err := object.WriteTo(h)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// InitCeremony starts the phase-2 ceremony of the compiled groth16 circuit
+// from the output of a phase-1 ceremony
+func InitCeremony(name string, phase1Path string) error {
+
+	if Curve != ecc.BN254 {
+		return fmt.Errorf("ceremony requires bn254, circuits use %s", Curve)
+	}
+
+	ceremonyMu.Lock()
+	defer ceremonyMu.Unlock()
+
+	dir, err := ceremonyPath(name)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(filepath.Join(dir, "transcript.json"))
+	if err == nil {
+		return fmt.Errorf("ceremony of circuit %s already exists", name)
+	}
+
+	phase1Data, err := os.ReadFile(phase1Path)
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(phase1Path)")
+		return err
+	}
+	ccsData, err := os.ReadFile(ArtifactPath(name, "groth16", ".ccs"))
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(ccs)")
+		return err
+	}
+
+	setup, err := initPhase2(phase1Data, ccsData)
+	if err != nil {
+		return err
+	}
+
+	var phase2Buf bytes.Buffer
+	_, err = setup.initial.WriteTo(&phase2Buf)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Error().Err(err).Msg("os.MkdirAll(dir)")
+		return err
+	}
+	files := map[string][]byte{
+		"phase1.bin":        phase1Data,
+		"circuit.ccs":       ccsData,
+		contributionFile(0): phase2Buf.Bytes(),
+	}
+	for file, data := range files {
+		err = os.WriteFile(filepath.Join(dir, file), data, 0644)
+		if err != nil {
+			log.Error().Err(err).Msg("os.WriteFile")
+			return err
+		}
+	}
+
+	transcript := Transcript{
+		Circuit:    name,
+		Phase1Hash: fileHash(phase1Data),
+		CCSHash:    fileHash(ccsData),
+		Contributions: []Contribution{{
+			Index:       0,
+			Contributor: "init",
+			File:        contributionFile(0),
+			FileHash:    fileHash(phase2Buf.Bytes()),
+			Hash:        hex.EncodeToString(setup.initial.Hash),
+			AddedAt:     time.Now().UTC(),
+		}},
+	}
+
+	return storeTranscript(dir, transcript)
+}
+
+// ceremony inputs and what is derived from them. the evaluations are
+// derived again when needed, gnark does not serialize all of them.
+type phase2Setup struct {
+	phase1  mpcsetup.Phase1
+	r1cs    *cs_bn254.R1CS
+	initial mpcsetup.Phase2
+	evals   mpcsetup.Phase2Evaluations
+}
+
+// derives the initial phase-2 parameters of the circuit
+func initPhase2(phase1Data []byte, ccsData []byte) (*phase2Setup, error) {
+
+	var setup phase2Setup
+	_, err := setup.phase1.ReadFrom(bytes.NewReader(phase1Data))
+	if err != nil {
+		log.Error().Err(err).Msg("phase1.ReadFrom()")
+		return nil, err
+	}
+
+	setup.r1cs, err = readR1CS(ccsData)
+	if err != nil {
+		return nil, err
+	}
+
+	// lagrange basis of the phase-1 powers must match the circuit domain
+	powers := len(setup.phase1.Parameters.G1.AlphaTau)
+	domainSize := ecc.NextPowerOfTwo(uint64(setup.r1cs.GetNbConstraints()))
+	if uint64(powers) != domainSize {
+		return nil, fmt.Errorf("phase-1 holds %d powers, circuit requires %d", powers, domainSize)
+	}
+
+	setup.initial, setup.evals = mpcsetup.InitPhase2(setup.r1cs, &setup.phase1)
+
+	return &setup, nil
+}
+
+func readR1CS(ccsData []byte) (*cs_bn254.R1CS, error) {
+
+	ccs := groth16.NewCS(ecc.BN254)
+	_, err := ccs.ReadFrom(bytes.NewReader(ccsData))
+	if err != nil {
+		log.Error().Err(err).Msg("ccs.ReadFrom()")
+		return nil, err
+	}
+
+	r1cs, ok := ccs.(*cs_bn254.R1CS)
+	if !ok {
+		return nil, errors.New("ceremony requires a bn254 r1cs")
+	}
+	// phase-2 of gnark does not derive commitment keys
+	commitments, _ := r1cs.CommitmentInfo.(constraint.Groth16Commitments)
+	if len(commitments) > 0 {
+		return nil, errors.New("ceremony does not support circuits with commitments")
+	}
+
+	return r1cs, nil
+}
+
+// ContributePhase2 adds fresh randomness to the latest ceremony parameters,
+// run by participants on their own machine. the randomness is discarded
+// when the contribution is written.
+func ContributePhase2(r io.Reader, w io.Writer) error {
+
+	var phase2 mpcsetup.Phase2
+	_, err := phase2.ReadFrom(r)
+	if err != nil {
+		log.Error().Err(err).Msg("phase2.ReadFrom(r)")
+		return err
+	}
+
+	phase2.Contribute()
+
+	_, err = phase2.WriteTo(w)
+	if err != nil {
+		log.Error().Err(err).Msg("phase2.WriteTo(w)")
+		return err
+	}
+	return nil
+}
+
+// LatestContribution returns the path of the parameters the next
+// contribution has to build on
+func LatestContribution(name string) (string, error) {
+
+	ceremonyMu.Lock()
+	defer ceremonyMu.Unlock()
+
+	dir, err := ceremonyPath(name)
+	if err != nil {
+		return "", err
+	}
+	transcript, err := readTranscript(dir)
+	if err != nil {
+		return "", err
+	}
+	if transcript.FinalizedAt != nil {
+		return "", fmt.Errorf("ceremony of circuit %s has been finalized", name)
+	}
+	last := transcript.Contributions[len(transcript.Contributions)-1]
+
+	return filepath.Join(dir, last.File), nil
+}
+
+// AddContribution verifies a contribution against the latest parameters
+// and appends it to the ceremony
+func AddContribution(name string, contributor string, r io.Reader) (Contribution, error) {
+
+	ceremonyMu.Lock()
+	defer ceremonyMu.Unlock()
+
+	dir, err := ceremonyPath(name)
+	if err != nil {
+		return Contribution{}, err
+	}
+	transcript, err := readTranscript(dir)
+	if err != nil {
+		return Contribution{}, err
+	}
+	if transcript.FinalizedAt != nil {
+		return Contribution{}, fmt.Errorf("ceremony of circuit %s has been finalized", name)
+	}
+
+	last := transcript.Contributions[len(transcript.Contributions)-1]
+	current, _, err := readPhase2(filepath.Join(dir, last.File))
+	if err != nil {
+		return Contribution{}, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		log.Error().Err(err).Msg("io.ReadAll(r)")
+		return Contribution{}, err
+	}
+	var next mpcsetup.Phase2
+	_, err = next.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		log.Error().Err(err).Msg("next.ReadFrom()")
+		return Contribution{}, err
+	}
+
+	err = verifyContribution(current, &next)
+	if err != nil {
+		log.Error().Err(err).Msg("verifyContribution()")
+		return Contribution{}, err
+	}
+
+	contribution := Contribution{
+		Index:       last.Index + 1,
+		Contributor: contributor,
+		File:        contributionFile(last.Index + 1),
+		FileHash:    fileHash(data),
+		Hash:        hex.EncodeToString(next.Hash),
+		AddedAt:     time.Now().UTC(),
+	}
+	err = os.WriteFile(filepath.Join(dir, contribution.File), data, 0644)
+	if err != nil {
+		log.Error().Err(err).Msg("os.WriteFile")
+		return Contribution{}, err
+	}
+
+	transcript.Contributions = append(transcript.Contributions, contribution)
+
+	return contribution, storeTranscript(dir, transcript)
+}
+
+// gnark checks the proof of knowledge of δ, the update of all parameters
+// and the hash, but not that the parameter vectors kept their length
+func verifyContribution(current *mpcsetup.Phase2, next *mpcsetup.Phase2) error {
+
+	if len(next.Parameters.G1.L) != len(current.Parameters.G1.L) || len(next.Parameters.G1.Z) != len(current.Parameters.G1.Z) {
+		return errors.New("contribution changes the parameter sizes")
+	}
+	return mpcsetup.VerifyPhase2(current, next)
+}
+
+// FinalizeCeremony extracts proving and verifying key from the last
+// contribution, at least one contribution is required
+func FinalizeCeremony(name string) error {
+
+	ceremonyMu.Lock()
+	defer ceremonyMu.Unlock()
+
+	dir, err := ceremonyPath(name)
+	if err != nil {
+		return err
+	}
+	transcript, err := readTranscript(dir)
+	if err != nil {
+		return err
+	}
+	if transcript.FinalizedAt != nil {
+		return fmt.Errorf("ceremony of circuit %s has been finalized", name)
+	}
+	if len(transcript.Contributions) < 2 {
+		return errors.New("ceremony requires at least one contribution")
+	}
+
+	pk, vk, err := extractCeremonyKeys(dir, transcript)
+	if err != nil {
+		return err
+	}
+
+	transcript.PKHash, err = objectHash(pk)
+	if err != nil {
+		return err
+	}
+	transcript.VKHash, err = objectHash(vk)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	transcript.FinalizedAt = &now
+
+	u.Serialize(pk, filepath.Join(dir, name+"_groth16.pk"))
+	u.Serialize(vk, filepath.Join(dir, name+"_groth16.vk"))
+
+	return storeTranscript(dir, transcript)
+}
+
+func extractCeremonyKeys(dir string, transcript Transcript) (*groth16_bn254.ProvingKey, *groth16_bn254.VerifyingKey, error) {
+
+	setup, err := readCeremonyInputs(dir, transcript)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last := transcript.Contributions[len(transcript.Contributions)-1]
+	phase2, _, err := readPhase2(filepath.Join(dir, last.File))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pk, vk := mpcsetup.ExtractKeys(&setup.phase1, phase2, &setup.evals, setup.r1cs.GetNbConstraints())
+
+	return &pk, &vk, nil
+}
+
+// reads phase-1 and circuit of the ceremony and checks them against the
+// transcript
+func readCeremonyInputs(dir string, transcript Transcript) (*phase2Setup, error) {
+
+	phase1Data, err := os.ReadFile(filepath.Join(dir, "phase1.bin"))
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(phase1.bin)")
+		return nil, err
+	}
+	ccsData, err := os.ReadFile(filepath.Join(dir, "circuit.ccs"))
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(circuit.ccs)")
+		return nil, err
+	}
+	if fileHash(phase1Data) != transcript.Phase1Hash || fileHash(ccsData) != transcript.CCSHash {
+		return nil, errors.New("ceremony inputs do not match the transcript")
+	}
+
+	return initPhase2(phase1Data, ccsData)
+}
+
+// VerifyCeremony audits a ceremony directory: the initial parameters are
+// derived again from phase-1 and circuit, every contribution is verified
+// against its predecessor and the final keys are extracted again
+func VerifyCeremony(dir string) error {
+
+	transcript, err := readTranscript(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(transcript.Contributions) == 0 {
+		return errors.New("transcript holds no parameters")
+	}
+
+	// initial parameters are deterministic apart from the public key
+	setup, err := readCeremonyInputs(dir, transcript)
+	if err != nil {
+		return err
+	}
+
+	var previous *mpcsetup.Phase2
+	for i, c := range transcript.Contributions {
+		if c.Index != i || c.File != contributionFile(i) {
+			return fmt.Errorf("contribution %d is out of sequence", i)
+		}
+		current, hash, err := readPhase2(filepath.Join(dir, c.File))
+		if err != nil {
+			return err
+		}
+		if hash != c.FileHash || hex.EncodeToString(current.Hash) != c.Hash {
+			return fmt.Errorf("contribution %d does not match the transcript", i)
+		}
+		if previous == nil && !sameParameters(&setup.initial, current) {
+			return errors.New("initial parameters do not derive from phase-1 and circuit")
+		}
+		if previous != nil {
+			err = verifyContribution(previous, current)
+			if err != nil {
+				return fmt.Errorf("contribution %d of %s: %w", i, c.Contributor, err)
+			}
+		}
+		previous = current
+	}
+
+	if transcript.FinalizedAt == nil {
+		return nil
+	}
+	pk, vk, err := extractCeremonyKeys(dir, transcript)
+	if err != nil {
+		return err
+	}
+	pkHash, err := objectHash(pk)
+	if err != nil {
+		return err
+	}
+	vkHash, err := objectHash(vk)
+	if err != nil {
+		return err
+	}
+	if pkHash != transcript.PKHash || vkHash != transcript.VKHash {
+		return errors.New("final keys do not derive from the last contribution")
+	}
+
+	return nil
+}
+
+func sameParameters(a *mpcsetup.Phase2, b *mpcsetup.Phase2) bool {
+
+	if !a.Parameters.G1.Delta.Equal(&b.Parameters.G1.Delta) || !a.Parameters.G2.Delta.Equal(&b.Parameters.G2.Delta) {
+		return false
+	}
+	if len(a.Parameters.G1.L) != len(b.Parameters.G1.L) || len(a.Parameters.G1.Z) != len(b.Parameters.G1.Z) {
+		return false
+	}
+	for i := range a.Parameters.G1.L {
+		if !a.Parameters.G1.L[i].Equal(&b.Parameters.G1.L[i]) {
+			return false
+		}
+	}
+	for i := range a.Parameters.G1.Z {
+		if !a.Parameters.G1.Z[i].Equal(&b.Parameters.G1.Z[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ceremonyKeys returns the keys of a finalized ceremony for the constraint
+// system, nil keys if there is none
+func ceremonyKeys(name string, ccs constraint.ConstraintSystem) (groth16.ProvingKey, groth16.VerifyingKey, error) {
+
+	if Curve != ecc.BN254 {
+		return nil, nil, nil
+	}
+
+	ceremonyMu.Lock()
+	defer ceremonyMu.Unlock()
+
+	// ceremonies are only run for the oracle circuits
+	dir, err := ceremonyPath(name)
+	if err != nil {
+		return nil, nil, nil
+	}
+	transcript, err := readTranscript(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if transcript.FinalizedAt == nil {
+		return nil, nil, nil
+	}
+
+	// keys are specific to the constraint system
+	ccsHash, err := objectHash(ccs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ccsHash != transcript.CCSHash {
+		return nil, nil, nil
+	}
+
+	pk := groth16.NewProvingKey(ecc.BN254)
+	vk := groth16.NewVerifyingKey(ecc.BN254)
+	u.Deserialize(pk, filepath.Join(dir, name+"_groth16.pk"))
+	u.Deserialize(vk, filepath.Join(dir, name+"_groth16.vk"))
+
+	pkHash, err := objectHash(pk)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pkHash != transcript.PKHash {
+		return nil, nil, errors.New("ceremony proving key does not match the transcript")
+	}
+	vkHash, err := objectHash(vk)
+	if err != nil {
+		return nil, nil, err
+	}
+	if vkHash != transcript.VKHash {
+		return nil, nil, errors.New("ceremony verifying key does not match the transcript")
+	}
+
+	return pk, vk, nil
+}
+
+// reads ceremony parameters and returns them with the hash of the file
+func readPhase2(path string) (*mpcsetup.Phase2, string, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(path)")
+		return nil, "", err
+	}
+
+	var phase2 mpcsetup.Phase2
+	_, err = phase2.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		log.Error().Err(err).Msg("phase2.ReadFrom()")
+		return nil, "", err
+	}
+
+	return &phase2, fileHash(data), nil
+}
+
+func readTranscript(dir string) (Transcript, error) {
+
+	var transcript Transcript
+
+	data, err := os.ReadFile(filepath.Join(dir, "transcript.json"))
+	if err != nil {
+		return transcript, err
+	}
+
+	err = json.Unmarshal(data, &transcript)
+	if err != nil {
+		log.Error().Err(err).Msg("json.Unmarshal(data, &transcript)")
+		return transcript, err
+	}
+
+	return transcript, nil
+}
+
+func storeTranscript(dir string, transcript Transcript) error {
+
+	file, err := json.MarshalIndent(transcript, "", " ")
+	if err != nil {
+		log.Error().Err(err).Msg("json.MarshalIndent")
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "transcript.json"), file, 0644)
+	if err != nil {
+		log.Error().Err(err).Msg("os.WriteFile")
+		return err
+	}
+	return nil
+}
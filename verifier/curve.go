@@ -0,0 +1,64 @@
+package verifier
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// Curve of compiled circuits, keys, witnesses and proofs
+var Curve = ecc.BN254
+
+// curves circuits can be compiled for, plonk is limited to bn254 by the
+// universal srs, the phase-2 ceremony to bn254 by gnark and recursive
+// aggregation to bls12_377, the inner curve of the bw6-761 2-chain
+var curves = []ecc.ID{ecc.BN254, ecc.BLS12_381, ecc.BLS12_377}
+
+// SetCurve selects the curve by name, e.g. bn254, bls12_381 or bls12_377
+func SetCurve(name string) error {
+	for _, id := range curves {
+		if id.String() == name {
+			Curve = id
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported curve %q", name)
+}
+
+// ArtifactPath returns the path of a stored circuit artifact. artifacts are
+// tagged by curve and backend, keys of one curve are never used with another.
+func ArtifactPath(name string, backend string, ext string) string {
+	return "./local_storage/circuits/" + name + "_" + Curve.String() + "_" + backend + ext
+}
+
+// readProof decodes a submitted proof, a proof of another curve fails to
+// decode or leaves trailing bytes
+func readProof(proof io.ReaderFrom, path string) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(path)")
+		return err
+	}
+
+	return decodeProof(proof, data)
+}
+
+// decodeProof decodes a proof that must span all of data
+func decodeProof(proof io.ReaderFrom, data []byte) error {
+
+	n, err := proof.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("proof is not encoded on curve %s: %w", Curve, err)
+	}
+	if n != int64(len(data)) {
+		return fmt.Errorf("proof is not encoded on curve %s", Curve)
+	}
+
+	return nil
+}
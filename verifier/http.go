@@ -0,0 +1,300 @@
+package verifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	u "proxy/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+var httpLayoutPath = "./local_storage/http_public_input.json"
+var responseOffsetsPath = "./local_storage/response_offsets.json"
+
+// span is a half open byte range of the http response
+type span struct {
+	start int
+	end   int
+}
+
+func (s span) within(o span) bool {
+	return s.start >= o.start && s.end <= o.end
+}
+
+// chunkMarker is the framing between two chunks of a chunked body: the chunk
+// size line, preceded by the crlf of the previous chunk except for the first
+// marker. size is the length of the chunk data following the marker.
+type chunkMarker struct {
+	offset int
+	length int
+	size   int
+}
+
+// application protocols as negotiated via alpn
+const (
+	ProtocolHTTP1 = "http/1.1"
+	ProtocolHTTP2 = "h2"
+)
+
+// HTTPLayout describes an http/1.1 response or the http/2 DATA frames of a
+// connection as declared by the client. offsets are relative to the first
+// byte of the response, respectively of the server application data.
+type HTTPLayout struct {
+	Protocol      string
+	StatusLineEnd int
+	BodyStart     int
+	BodyEnd       int
+	Chunked       bool
+	chunks        []chunkMarker
+	// stream carrying the proven value
+	StreamID int
+	frames   []u.DataFrame
+}
+
+// reads the http layout declared by the client, nil if none was declared
+func ReadHTTPLayout() (*HTTPLayout, error) {
+
+	if _, err := os.Stat(httpLayoutPath); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	params, err := u.ReadM(httpLayoutPath)
+	if err != nil {
+		log.Error().Msg("u.ReadM")
+		return nil, err
+	}
+
+	if params["protocol"] == ProtocolHTTP2 {
+		return readH2Layout(params)
+	}
+
+	layout := &HTTPLayout{Protocol: ProtocolHTTP1}
+	layout.BodyStart, err = strconv.Atoi(params["header_length"])
+	if err != nil {
+		return nil, errors.New("invalid header_length")
+	}
+	if s, ok := params["status_line_length"]; ok {
+		layout.StatusLineEnd, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.New("invalid status_line_length")
+		}
+	}
+
+	bodyLength := params["content_length"]
+	if params["transfer_encoding"] == "chunked" {
+		layout.Chunked = true
+		bodyLength = params["body_length"]
+		layout.chunks, err = parseChunkMarkers(params["chunk_markers"])
+		if err != nil {
+			return nil, err
+		}
+	}
+	length, err := strconv.Atoi(bodyLength)
+	if err != nil {
+		return nil, errors.New("invalid body length")
+	}
+	layout.BodyEnd = layout.BodyStart + length
+
+	err = layout.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return layout, nil
+}
+
+// reads DATA frames of the form "offset:length:stream:flags:padding;..."
+func readH2Layout(params map[string]string) (*HTTPLayout, error) {
+
+	layout := &HTTPLayout{Protocol: ProtocolHTTP2}
+
+	var err error
+	layout.StreamID, err = strconv.Atoi(params["stream_id"])
+	if err != nil || layout.StreamID%2 == 0 {
+		return nil, errors.New("invalid stream_id, expected a client initiated stream")
+	}
+
+	layout.frames, err = u.ParseDataFrames(params["data_frames"])
+	if err != nil {
+		return nil, err
+	}
+
+	return layout, nil
+}
+
+// parses chunk markers of the form "offset:length:size;offset:length:size"
+func parseChunkMarkers(s string) ([]chunkMarker, error) {
+
+	tuples, err := u.ParseTuples(s, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	var markers []chunkMarker
+	for _, t := range tuples {
+		markers = append(markers, chunkMarker{offset: t[0], length: t[1], size: t[2]})
+	}
+
+	return markers, nil
+}
+
+// validate checks that the declared structure is self consistent: the status
+// line precedes the headers, and chunk markers and chunk data tile the body
+// without gaps, ending with the zero sized last chunk.
+func (l *HTTPLayout) validate() error {
+
+	if l.StatusLineEnd < 0 || l.StatusLineEnd > l.BodyStart || l.BodyStart > l.BodyEnd {
+		return errors.New("inconsistent http layout")
+	}
+	if !l.Chunked {
+		return nil
+	}
+
+	pos := l.BodyStart
+	for i, c := range l.chunks {
+		if c.offset != pos || c.length == 0 {
+			return fmt.Errorf("chunk marker %d does not continue the body", i)
+		}
+		pos = c.offset + c.length + c.size
+		last := i == len(l.chunks)-1
+		if (c.size == 0) != last {
+			return errors.New("only the last chunk may be empty")
+		}
+	}
+	if len(l.chunks) == 0 || pos != l.BodyEnd {
+		return errors.New("chunks do not cover the body")
+	}
+
+	return nil
+}
+
+// dataSpan returns the body data range which contains s, chunk markers and
+// the trailing crlf of chunks are not body data. for http/2 the range is the
+// payload of a DATA frame of the declared stream.
+func (l *HTTPLayout) dataSpan(s span) (span, bool) {
+
+	if l.Protocol == ProtocolHTTP2 {
+		for _, f := range l.frames {
+			if f.Stream != l.StreamID {
+				continue
+			}
+			data := span{f.Offset + u.H2FrameHeaderLen, f.End() - f.PadLength}
+			if f.Flags&u.H2FlagPadded != 0 {
+				data.start++
+			}
+			if s.within(data) {
+				return data, true
+			}
+		}
+		return span{}, false
+	}
+
+	body := span{l.BodyStart, l.BodyEnd}
+	if !l.Chunked {
+		return body, s.within(body)
+	}
+
+	for _, c := range l.chunks {
+		data := span{c.offset + c.length, c.offset + c.length + c.size}
+		if s.within(data) {
+			return data, true
+		}
+	}
+	return span{}, false
+}
+
+// CheckValueLocation checks that the proven substring and value lie in the
+// body data of the response, and that the substring is the json field name
+// required by the policy. the check is advisory: the layout is declared by
+// the client and not proven by the circuit, it only rejects substrings
+// outside the body of a consistent declaration.
+func CheckValueLocation(policy Policy) error {
+
+	layout, err := ReadHTTPLayout()
+	if err != nil {
+		log.Error().Err(err).Msg("ReadHTTPLayout()")
+		return err
+	}
+	if layout == nil {
+		if policy.BodyField != "" {
+			return errors.New("policy requires an http layout")
+		}
+		return nil
+	}
+
+	// declared protocol must match the protocol negotiated via alpn
+	verdict, err := u.ReadM("./local_storage/session_verdict.json")
+	if err != nil {
+		log.Error().Msg("u.ReadM")
+		return err
+	}
+	negotiated := ProtocolHTTP1
+	if verdict["alpn"] == ProtocolHTTP2 {
+		negotiated = ProtocolHTTP2
+	}
+	if layout.Protocol != negotiated {
+		return fmt.Errorf("declared %s layout on a %s connection", layout.Protocol, negotiated)
+	}
+
+	params, err := u.ReadM(serverSide.recordDataPath)
+	if err != nil {
+		log.Error().Msg("u.ReadM")
+		return err
+	}
+
+	// offset of the proven record within the response
+	confirmed, err := u.ReadConfirmedRecord(serverSide.recordConfirmedPath)
+	if err != nil {
+		return err
+	}
+	offsets, err := u.ReadM(responseOffsetsPath)
+	if err != nil {
+		log.Error().Msg("u.ReadM")
+		return err
+	}
+	recordOffset, err := strconv.Atoi(offsets[confirmed.Seq.String()])
+	if err != nil {
+		return errors.New("proven record is not part of the response")
+	}
+
+	// offsets within the record plaintext, the chunk window starts at counter block 2
+	chunkIndex, _ := strconv.Atoi(params["chunk_index"])
+	substringStart, _ := strconv.Atoi(params["substring_start"])
+	substringStartIdx, _ := strconv.Atoi(params["substring_start_idx"])
+	valueStart, _ := strconv.Atoi(params["value_start"])
+	valueEnd, _ := strconv.Atoi(params["value_end"])
+	chunkOffset := (chunkIndex - 2) * 16
+	if chunkOffset+substringStart != substringStartIdx {
+		return errors.New("substring offsets are inconsistent")
+	}
+
+	substring := span{recordOffset + substringStartIdx, recordOffset + substringStartIdx + len(params["substring"])}
+	value := span{recordOffset + chunkOffset + valueStart, recordOffset + chunkOffset + valueEnd}
+
+	data, ok := layout.dataSpan(substring)
+	if !ok && layout.Protocol == ProtocolHTTP2 {
+		return fmt.Errorf("proven substring is not located in a DATA frame of stream %d", layout.StreamID)
+	}
+	if !ok {
+		return errors.New("proven substring is not located in the response body")
+	}
+	if !value.within(data) {
+		return errors.New("proven value is not located in the body data of the substring")
+	}
+
+	if policy.BodyField == "" {
+		return nil
+	}
+	field, err := json.Marshal(policy.BodyField)
+	if err != nil {
+		return err
+	}
+	if params["substring"] != string(field) {
+		return fmt.Errorf("proven substring is not the json body field %s", field)
+	}
+
+	return nil
+}
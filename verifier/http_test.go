@@ -0,0 +1,68 @@
+package verifier
+
+import (
+	"testing"
+)
+
+func TestHTTPLayoutValidate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		layout  HTTPLayout
+		wantErr bool
+	}{
+		{"content length", HTTPLayout{StatusLineEnd: 17, BodyStart: 80, BodyEnd: 120}, false},
+		{"empty body", HTTPLayout{StatusLineEnd: 17, BodyStart: 80, BodyEnd: 80}, false},
+		{"status line after headers", HTTPLayout{StatusLineEnd: 90, BodyStart: 80, BodyEnd: 120}, true},
+		{"negative status line", HTTPLayout{StatusLineEnd: -1, BodyStart: 80, BodyEnd: 120}, true},
+		{"body ends before it starts", HTTPLayout{BodyStart: 80, BodyEnd: 70}, true},
+		{
+			"chunked body",
+			HTTPLayout{BodyStart: 80, BodyEnd: 80 + 4 + 16 + 7, Chunked: true, chunks: []chunkMarker{{80, 4, 16}, {100, 7, 0}}},
+			false,
+		},
+		{"chunked without chunks", HTTPLayout{BodyStart: 80, BodyEnd: 80, Chunked: true}, true},
+		{
+			"gap between chunks",
+			HTTPLayout{BodyStart: 80, BodyEnd: 80 + 4 + 16 + 1 + 7, Chunked: true, chunks: []chunkMarker{{80, 4, 16}, {101, 7, 0}}},
+			true,
+		},
+		{
+			"first marker after body start",
+			HTTPLayout{BodyStart: 80, BodyEnd: 81 + 4 + 16 + 7, Chunked: true, chunks: []chunkMarker{{81, 4, 16}, {101, 7, 0}}},
+			true,
+		},
+		{
+			"empty marker",
+			HTTPLayout{BodyStart: 80, BodyEnd: 80 + 16 + 7, Chunked: true, chunks: []chunkMarker{{80, 0, 16}, {96, 7, 0}}},
+			true,
+		},
+		{
+			"empty chunk before the last",
+			HTTPLayout{BodyStart: 80, BodyEnd: 80 + 3 + 7, Chunked: true, chunks: []chunkMarker{{80, 3, 0}, {83, 7, 0}}},
+			true,
+		},
+		{
+			"missing last chunk",
+			HTTPLayout{BodyStart: 80, BodyEnd: 80 + 4 + 16, Chunked: true, chunks: []chunkMarker{{80, 4, 16}}},
+			true,
+		},
+		{
+			"chunks shorter than the body",
+			HTTPLayout{BodyStart: 80, BodyEnd: 80 + 4 + 16 + 7 + 10, Chunked: true, chunks: []chunkMarker{{80, 4, 16}, {100, 7, 0}}},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.layout.validate()
+			if tt.wantErr && err == nil {
+				t.Fatal("accepted inconsistent layout")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
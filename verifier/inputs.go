@@ -0,0 +1,125 @@
+package verifier
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	u "proxy/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// InputSource tells who vouches for a public input of the oracle circuit
+type InputSource int
+
+const (
+	// computed by the proxy from the transcript and verified secrets
+	ProxyDerived InputSource = iota
+	// taken from the tag verified record
+	RecordConfirmed
+	// declared by the client and only bound by constraints of the circuit
+	ClientDeclared
+)
+
+func (s InputSource) String() string {
+	switch s {
+	case ProxyDerived:
+		return "proxy-derived"
+	case RecordConfirmed:
+		return "record-confirmed"
+	case ClientDeclared:
+		return "client-declared"
+	}
+	return "unknown"
+}
+
+// PublicInput describes a public input of the oracle circuit. Name is the
+// key in the proxy computed files, ClientName the key the client uses in
+// kdc_public_input.json or recorddata_public_input.json.
+type PublicInput struct {
+	Name       string
+	ClientName string
+	Source     InputSource
+}
+
+// KdcInputs lists the kdc and authtag inputs of the oracle circuit
+var KdcInputs = []PublicInput{
+	{"intermediateHashHSopad", "intermediateHashHSopad", ProxyDerived},
+	{"MSin", "MSin", ProxyDerived},
+	{"SATSin", "SATSin", ProxyDerived},
+	{"tkSappIn", "tkSAPPin", ProxyDerived},
+	{"CATSin", "CATSin", ProxyDerived},
+	{"tkCappIn", "tkCAPPin", ProxyDerived},
+	{"ecb0", "ECB0", RecordConfirmed},
+	{"ecbk", "ECBK", RecordConfirmed},
+	{"ivSapp", "ivSapp", ClientDeclared},
+	{"ivCapp", "ivCapp", ClientDeclared},
+}
+
+// RecordInputs lists the record inputs of the oracle circuit
+var RecordInputs = []PublicInput{
+	{"cipher_chunks", "cipher_chunks", RecordConfirmed},
+	{"chunk_index", "chunk_index", ClientDeclared},
+	{"substring", "substring", ClientDeclared},
+	{"substring_start", "substring_start", ClientDeclared},
+	{"substring_end", "substring_end", ClientDeclared},
+	{"value_start", "value_start", ClientDeclared},
+	{"value_end", "value_end", ClientDeclared},
+}
+
+// readKdcParams builds the kdc and authtag inputs of the public witness from
+// the values of their source only. client declared values which conflict
+// with proxy derived or confirmed values are reported and reject the session.
+func readKdcParams(side recordSide) (map[string]string, error) {
+
+	client, err := u.ReadM("./local_storage/kdc_public_input.json")
+	if err != nil {
+		log.Error().Msg("u.ReadM")
+		return nil, err
+	}
+	derived, err := u.ReadM("./local_storage/kdc_confirmed.json")
+	if err != nil {
+		log.Error().Msg("u.ReadM")
+		return nil, err
+	}
+	confirmed, err := u.ReadConfirmedRecord(side.recordConfirmedPath)
+	if err != nil {
+		log.Error().Msg("u.ReadConfirmedRecord")
+		return nil, err
+	}
+	derived["ecb0"] = hex.EncodeToString(confirmed.ECB0)
+	derived["ecbk"] = hex.EncodeToString(confirmed.ECBK)
+
+	params := make(map[string]string)
+	var conflicts []string
+	for _, in := range KdcInputs {
+
+		declared, isDeclared := client[in.ClientName]
+		if in.Source == ClientDeclared {
+			params[in.Name] = declared
+			continue
+		}
+
+		value, ok := derived[in.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing %s input %s", in.Source, in.Name)
+		}
+		if isDeclared && !strings.EqualFold(declared, value) {
+			log.Error().Str("input", in.Name).Str("declared", declared).Str(in.Source.String(), value).Msg("conflicting public input")
+			conflicts = append(conflicts, in.Name)
+		}
+		params[in.Name] = value
+	}
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("client declared inputs conflict with proxy values: %s", strings.Join(conflicts, ", "))
+	}
+
+	// the iv of the traffic direction is bound by the authtag constraints
+	iv, err := hex.DecodeString(params[side.iv])
+	if err != nil || len(iv) != 12 {
+		return nil, fmt.Errorf("invalid client declared input %s", side.iv)
+	}
+
+	return params, nil
+}
@@ -0,0 +1,103 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// every setup replaces the verifying key of a circuit. keys that verified a
+// session are archived under the sha256 hash of their serialization, which
+// is pinned in the nullifier, so that stored proofs can be verified again
+// against the key they were accepted with.
+
+// path of the archived verifying key with the given hash
+func archivedKeyPath(name string, backend string, hash string) string {
+	return ArtifactPath(name, backend, "_"+hash+".vk")
+}
+
+// checks that the hash selects an archived key and is no path
+func checkKeyHash(hash string) error {
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("invalid verifying key hash %q", hash)
+	}
+	return nil
+}
+
+// readVerifyingKey decodes the current verifying key of the circuit, archives
+// it and returns its hash
+func readVerifyingKey(vk io.ReaderFrom, name string, backend string) (string, error) {
+
+	data, err := os.ReadFile(ArtifactPath(name, backend, ".vk"))
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(vk)")
+		return "", err
+	}
+	err = decodeVerifyingKey(vk, data)
+	if err != nil {
+		return "", err
+	}
+
+	hash := fileHash(data)
+	path := archivedKeyPath(name, backend, hash)
+	_, err = os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("archive vk")
+		return "", err
+	}
+	return hash, nil
+}
+
+// readPinnedVerifyingKey returns the serialized verifying key with the given
+// hash, the current key of the circuit if the hash is empty
+func readPinnedVerifyingKey(name string, backend string, hash string) ([]byte, error) {
+
+	if hash == "" {
+		data, err := os.ReadFile(ArtifactPath(name, backend, ".vk"))
+		if err != nil {
+			log.Error().Err(err).Msg("os.ReadFile(vk)")
+			return nil, err
+		}
+		return data, nil
+	}
+
+	err := checkKeyHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(archivedKeyPath(name, backend, hash))
+	if errors.Is(err, os.ErrNotExist) {
+		// keys are archived on their first verification
+		data, err = os.ReadFile(ArtifactPath(name, backend, ".vk"))
+		if err == nil && fileHash(data) != hash {
+			return nil, fmt.Errorf("verifying key %s of circuit %s is not archived", hash, name)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(archived vk)")
+		return nil, err
+	}
+	if fileHash(data) != hash {
+		return nil, fmt.Errorf("archived verifying key %s of circuit %s is corrupted", hash, name)
+	}
+	return data, nil
+}
+
+func decodeVerifyingKey(vk io.ReaderFrom, data []byte) error {
+	_, err := vk.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		log.Error().Err(err).Msg("vk.ReadFrom()")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package verifier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/rs/zerolog/log"
+)
+
+// re-verification policies of consumed sessions
+const (
+	// a session can be verified once per circuit
+	ReverifyReject = "reject"
+	// the same statement may be verified again, a different witness for a
+	// consumed session is rejected
+	ReverifySameWitness = "same-witness"
+	// sessions are never consumed
+	ReverifyAllow = "allow"
+)
+
+var nullifierPath = "./local_storage/nullifiers.json"
+
+// serializes access to the registry across concurrent verifications
+var nullifierMu sync.Mutex
+
+// Nullifier marks a session as consumed by a successful verification with
+// the verifying key of the given hash
+type Nullifier struct {
+	Circuit          string    `json:"circuit"`
+	Session          string    `json:"session"`
+	WitnessHash      string    `json:"witness_hash"`
+	VerifyingKeyHash string    `json:"verifying_key_hash,omitempty"`
+	VerifiedAt       time.Time `json:"verified_at"`
+}
+
+// returns the hex encoded sha256 hash of the serialized public witness
+func WitnessHash(publicWitness witness.Witness) (string, error) {
+
+	data, err := publicWitness.MarshalBinary()
+	if err != nil {
+		log.Error().Err(err).Msg("publicWitness.MarshalBinary()")
+		return "", err
+	}
+	h := sha256.Sum256(data)
+
+	return hex.EncodeToString(h[:]), nil
+}
+
+// CheckReverify rejects unknown re-verification policies
+func CheckReverify(policy string) error {
+	switch policy {
+	case "", ReverifyReject, ReverifySameWitness, ReverifyAllow:
+		return nil
+	}
+	return fmt.Errorf("unknown reverify policy %q", policy)
+}
+
+// ConsumeSession records a verified session in the nullifier registry.
+// duplicates are rejected according to the re-verification policy, a
+// witness already recorded for the session is not recorded again. the hash
+// of the verifying key is pinned for later batch verifications.
+func ConsumeSession(circuit string, session string, witnessHash string, vkHash string, policy string) error {
+
+	err := CheckReverify(policy)
+	if err != nil {
+		return err
+	}
+	if session == "" {
+		return errors.New("session without nonce cannot be consumed")
+	}
+
+	nullifierMu.Lock()
+	defer nullifierMu.Unlock()
+
+	nullifiers, err := readNullifiers()
+	if err != nil {
+		return err
+	}
+
+	for _, n := range nullifiers {
+		if n.Circuit != circuit || n.Session != session {
+			continue
+		}
+		switch policy {
+		case ReverifyAllow:
+			if n.WitnessHash == witnessHash {
+				return nil
+			}
+		case ReverifySameWitness:
+			if n.WitnessHash != witnessHash {
+				return fmt.Errorf("session %s has been consumed with a different witness", session)
+			}
+			return nil
+		default:
+			return fmt.Errorf("session %s has already been verified at %s", session, n.VerifiedAt.Format(time.RFC3339))
+		}
+	}
+
+	nullifiers = append(nullifiers, Nullifier{
+		Circuit:          circuit,
+		Session:          session,
+		WitnessHash:      witnessHash,
+		VerifyingKeyHash: vkHash,
+		VerifiedAt:       time.Now().UTC(),
+	})
+
+	return storeNullifiers(nullifiers)
+}
+
+// ConsumedWitness returns the witness hash a session has last been consumed
+// with by a circuit, false if the session has not been consumed
+func ConsumedWitness(circuit string, session string) (string, bool, error) {
+
+	nullifierMu.Lock()
+	defer nullifierMu.Unlock()
+
+	nullifiers, err := readNullifiers()
+	if err != nil {
+		return "", false, err
+	}
+
+	for i := len(nullifiers) - 1; i >= 0; i-- {
+		n := nullifiers[i]
+		if n.Circuit == circuit && n.Session == session {
+			return n.WitnessHash, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func readNullifiers() ([]Nullifier, error) {
+
+	data, err := os.ReadFile(nullifierPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(nullifierPath)")
+		return nil, err
+	}
+
+	var nullifiers []Nullifier
+	err = json.Unmarshal(data, &nullifiers)
+	if err != nil {
+		log.Error().Err(err).Msg("json.Unmarshal(data, &nullifiers)")
+		return nil, err
+	}
+
+	return nullifiers, nil
+}
+
+func storeNullifiers(nullifiers []Nullifier) error {
+
+	file, err := json.MarshalIndent(nullifiers, "", " ")
+	if err != nil {
+		log.Error().Err(err).Msg("json.MarshalIndent")
+		return err
+	}
+
+	err = os.WriteFile(nullifierPath, file, 0644)
+	if err != nil {
+		log.Error().Err(err).Msg("os.WriteFile")
+		return err
+	}
+	return nil
+}
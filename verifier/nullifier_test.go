@@ -0,0 +1,79 @@
+package verifier
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestConsumeSession(t *testing.T) {
+
+	// a verification of the session with the given witness
+	type verification struct {
+		circuit string
+		witness string
+		wantErr bool
+	}
+
+	tests := []struct {
+		policy        string
+		verifications []verification
+	}{
+		{ReverifyReject, []verification{
+			{OracleCircuit, "a", false},
+			{OracleCircuit, "a", true},
+			{OracleCircuit, "b", true},
+			// sessions are consumed per circuit
+			{RequestCircuit, "a", false},
+		}},
+		{ReverifySameWitness, []verification{
+			{OracleCircuit, "a", false},
+			{OracleCircuit, "a", false},
+			{OracleCircuit, "b", true},
+		}},
+		{ReverifyAllow, []verification{
+			{OracleCircuit, "a", false},
+			{OracleCircuit, "a", false},
+			{OracleCircuit, "b", false},
+		}},
+		{"sometimes", []verification{
+			{OracleCircuit, "a", true},
+		}},
+	}
+
+	defer func(path string) { nullifierPath = path }(nullifierPath)
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			nullifierPath = filepath.Join(t.TempDir(), "nullifiers.json")
+
+			for i, v := range tt.verifications {
+				err := ConsumeSession(v.circuit, "session", v.witness, "", tt.policy)
+				if v.wantErr && err == nil {
+					t.Fatalf("verification %d accepted", i)
+				}
+				if !v.wantErr && err != nil {
+					t.Fatalf("verification %d: %v", i, err)
+				}
+			}
+
+			// the last accepted witness is recorded
+			var want string
+			for _, v := range tt.verifications {
+				if !v.wantErr && v.circuit == OracleCircuit {
+					want = v.witness
+				}
+			}
+			got, ok, err := ConsumedWitness(OracleCircuit, "session")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != (want != "") || got != want {
+				t.Errorf("consumed witness %q, want %q", got, want)
+			}
+		})
+	}
+
+	err := ConsumeSession(OracleCircuit, "", "a", "", ReverifyReject)
+	if err == nil {
+		t.Error("consumed a session without nonce")
+	}
+}
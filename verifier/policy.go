@@ -0,0 +1,135 @@
+package verifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	u "proxy/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Policy holds the requirements a verified session must satisfy
+//
+// response completeness is not a policy: the close_notify and http framing
+// values behind the response_complete verdict are asserted by the client
+// and not bound to the proven key, so they cannot reject truncation. for the
+// same reason the body field is advisory and not enforced.
+type Policy struct {
+	// json field of the response body the proven substring must name. it is
+	// not enforced by the proof: the substring is only checked against the
+	// http layout declared by the client, which the circuit does not bind,
+	// so a client can declare a layout that places a header value in the body.
+	BodyField string `json:"body_field,omitempty"`
+	// re-verification of consumed sessions, reject by default
+	Reverify string `json:"reverify,omitempty"`
+	// accepted client fingerprints, any client is accepted if empty
+	AllowedJA3 []string `json:"allowed_ja3,omitempty"`
+	AllowedJA4 []string `json:"allowed_ja4,omitempty"`
+	// accepted server identities as verified from the certificate, with ech
+	// the routed server name is only the public name and never checked
+	ServerNames []string `json:"server_names,omitempty"`
+}
+
+var policyPath = "./local_storage/policy.json"
+
+// reads the verifier policy, a missing policy file yields the default policy
+func ReadPolicy() (Policy, error) {
+
+	var policy Policy
+
+	data, err := os.ReadFile(policyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return policy, nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(policyPath)")
+		return policy, err
+	}
+
+	// unknown fields, e.g. the removed require_response_complete, must not
+	// be mistaken for enforced requirements
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&policy)
+	if err != nil {
+		log.Error().Err(err).Msg("dec.Decode(&policy)")
+		return policy, err
+	}
+
+	err = CheckReverify(policy.Reverify)
+	if err != nil {
+		log.Error().Err(err).Msg("CheckReverify(policy.Reverify)")
+		return policy, err
+	}
+
+	return policy, nil
+}
+
+// checks the session verdict of the parser against the policy
+func CheckPolicy(policy Policy) error {
+
+	verdict, err := u.ReadM("./local_storage/session_verdict.json")
+	if err != nil {
+		log.Error().Msg("u.ReadM")
+		return err
+	}
+
+	if len(policy.ServerNames) > 0 && !contains(policy.ServerNames, verdict["server_name"]) {
+		return fmt.Errorf("server %q is not allowed", verdict["server_name"])
+	}
+
+	// client must match an allowed fingerprint
+	err = checkClientFingerprint(policy)
+	if err != nil {
+		log.Error().Err(err).Msg("checkClientFingerprint(policy)")
+		return err
+	}
+
+	// proven offsets must lie in the declared http body, advisory only
+	err = CheckValueLocation(policy)
+	if err != nil {
+		log.Error().Err(err).Msg("CheckValueLocation(policy)")
+		return err
+	}
+
+	return nil
+}
+
+// checks the client hello fingerprints recorded by the listener
+func checkClientFingerprint(policy Policy) error {
+
+	if len(policy.AllowedJA3) == 0 && len(policy.AllowedJA4) == 0 {
+		return nil
+	}
+
+	session, err := u.ReadSession()
+	if err != nil {
+		return err
+	}
+	hello := session.ClientHello
+	if hello == nil {
+		return errors.New("policy requires a recorded client hello")
+	}
+
+	if len(policy.AllowedJA3) > 0 && !contains(policy.AllowedJA3, hello.JA3Hash) {
+		return fmt.Errorf("client ja3 %s is not allowed", hello.JA3Hash)
+	}
+	if len(policy.AllowedJA4) > 0 && !contains(policy.AllowedJA4, hello.JA4) {
+		return fmt.Errorf("client ja4 %s is not allowed", hello.JA4)
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
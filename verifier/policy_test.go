@@ -0,0 +1,43 @@
+package verifier
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPolicy(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{"missing file", "", false},
+		{"server names", `{"server_names": ["example.com"], "reverify": "reject"}`, false},
+		{"response completeness is client asserted", `{"require_response_complete": true}`, true},
+		{"misspelled field", `{"server_name": ["example.com"]}`, true},
+		{"unknown reverify policy", `{"reverify": "sometimes"}`, true},
+	}
+
+	defer func(path string) { policyPath = path }(policyPath)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policyPath = filepath.Join(t.TempDir(), "policy.json")
+			if tt.policy != "" {
+				err := os.WriteFile(policyPath, []byte(tt.policy), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := ReadPolicy()
+			if tt.wantErr && err == nil {
+				t.Fatalf("accepted policy %s", tt.policy)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
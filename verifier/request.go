@@ -0,0 +1,86 @@
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	u "proxy/utils"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RequestLine holds the request data a request proof binds the response to
+type RequestLine struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Host   string `json:"host"`
+}
+
+// reads the substring proven over the client request and parses its request line.
+// the substring must start at the beginning of the first request record,
+// otherwise it could be taken from the request body or a later request. the
+// offset is derived from the chunk window of the circuit inputs and the
+// record from the tag verified client record.
+func ReadRequestLine() (RequestLine, error) {
+
+	params, err := u.ReadM(clientSide.recordDataPath)
+	if err != nil {
+		log.Error().Msg("u.ReadM")
+		return RequestLine{}, err
+	}
+	confirmed, err := u.ReadConfirmedRecord(clientSide.recordConfirmedPath)
+	if err != nil {
+		log.Error().Msg("u.ReadConfirmedRecord")
+		return RequestLine{}, err
+	}
+
+	// the first client application record carries the request line
+	if confirmed.Seq != 0 {
+		return RequestLine{}, fmt.Errorf("request proof covers client record %s, not the first record", confirmed.Seq)
+	}
+
+	// the chunk window starts at counter block 2, the first record block
+	chunkIndex, err := strconv.Atoi(params["chunk_index"])
+	if err != nil || chunkIndex < 2 {
+		return RequestLine{}, errors.New("invalid chunk_index")
+	}
+	substringStart, err := strconv.Atoi(params["substring_start"])
+	if err != nil {
+		return RequestLine{}, errors.New("invalid substring_start")
+	}
+	if (chunkIndex-2)*16+substringStart != 0 {
+		return RequestLine{}, errors.New("request substring does not start at the request line")
+	}
+
+	return ParseRequestLine(params["substring"])
+}
+
+// parses method, path and host of an http/1.1 request prefix such as
+// "GET /path HTTP/1.1\r\nHost: example.com"
+func ParseRequestLine(substring string) (RequestLine, error) {
+
+	lines := strings.Split(substring, "\r\n")
+
+	// request line
+	fields := strings.Split(lines[0], " ")
+	if len(fields) < 2 || fields[0] == "" || !strings.HasPrefix(fields[1], "/") {
+		return RequestLine{}, errors.New("malformed request line")
+	}
+	rl := RequestLine{
+		Method: fields[0],
+		Path:   fields[1],
+	}
+
+	// host header, if covered by the substring
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(name, "host") {
+			rl.Host = strings.TrimSpace(value)
+			break
+		}
+	}
+
+	return rl, nil
+}
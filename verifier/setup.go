@@ -2,6 +2,8 @@ package verifier
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
 	glg "proxy/tls-zkp/circuits/gadgets"
 	u "proxy/utils"
 	"strconv"
@@ -9,22 +11,71 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/consensys/gnark-crypto/ecc"
-	"github.com/consensys/gnark-crypto/kzg"
 	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/backend/plonk"
-
-	// "github.com/consensys/gnark/backend/plonkfri"
 	"github.com/consensys/gnark/constraint"
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/frontend/cs/r1cs"
 	"github.com/consensys/gnark/frontend/cs/scs"
-	"github.com/consensys/gnark/test"
 )
 
+// circuit names used as prefix of stored circuit artifacts
+const (
+	OracleCircuit  = "oracle"
+	RequestCircuit = "oracle_request"
+)
+
+// checks that the name is one of the circuits, names from requests end up
+// in artifact paths
+func CheckCircuit(name string) error {
+	switch name {
+	case OracleCircuit, RequestCircuit:
+		return nil
+	}
+	return fmt.Errorf("unknown circuit %q", name)
+}
+
+// ErrUnsupportedBackend is returned for proof systems other than groth16
+// and plonk
+var ErrUnsupportedBackend = errors.New("unsupported backend")
+
+// errPlonkFRIProof rejects plonkFRI. the backend is blocked on gnark: the
+// merkle paths of fri opening proofs, the folded evaluations and the iopp of
+// the keys are unexported in gnark v0.9.0, so proofs of the prover and keys
+// cannot be stored. plonkFRI stays unsupported until a gnark release that
+// serializes them is adopted.
+var errPlonkFRIProof = errors.New("plonkFRI is blocked until gnark can serialize its proofs and keys")
+
+// checks that the backend is a supported proof system on the curve
+func CheckBackend(backend string) error {
+	switch backend {
+	case "groth16":
+		return nil
+	case "plonkFRI":
+		return fmt.Errorf("%w %q: %v", ErrUnsupportedBackend, backend, errPlonkFRIProof)
+	case "plonk":
+		if Curve != ecc.BN254 {
+			return fmt.Errorf("plonk requires the bn254 universal srs, circuits use %s", Curve)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w %q", ErrUnsupportedBackend, backend)
+}
+
+// returns the oracle circuit over the server response
 func GetCircuit() (frontend.Circuit, error) {
+	return getCircuit(serverSide)
+}
+
+// returns the oracle circuit over the client request
+func GetRequestCircuit() (frontend.Circuit, error) {
+	return getCircuit(clientSide)
+}
+
+func getCircuit(side recordSide) (frontend.Circuit, error) {
 
 	// read data which defines circuit size
-	params, err := readCircuitParams()
+	params, err := readCircuitParams(side)
 	if err != nil {
 		log.Error().Err(err).Msg("readCircuitParams()")
 		return nil, err
@@ -53,13 +104,13 @@ func GetCircuit() (frontend.Circuit, error) {
 	return &circuit, nil
 }
 
-func readCircuitParams() (map[string]string, error) {
+func readCircuitParams(side recordSide) (map[string]string, error) {
 
 	// to be returned
 	finalMap := make(map[string]string)
 
 	// read in record publ params
-	record_pub, err := u.ReadM("./local_storage/recorddata_public_input.json")
+	record_pub, err := u.ReadM(side.recordDataPath)
 	if err != nil {
 		log.Error().Msg("u.ReadM")
 		return nil, err
@@ -70,10 +121,17 @@ func readCircuitParams() (map[string]string, error) {
 		finalMap[k] = v
 	}
 
+	// cipher chunks are taken from the confirmed record
+	finalMap["cipher_chunks"], err = deriveCipherChunks(side, finalMap)
+	if err != nil {
+		log.Error().Err(err).Msg("deriveCipherChunks()")
+		return nil, err
+	}
+
 	return finalMap, nil
 }
 
-func CompileCircuit(backend string, circuit frontend.Circuit) (constraint.ConstraintSystem, error) {
+func CompileCircuit(backend string, name string, circuit frontend.Circuit) (constraint.ConstraintSystem, error) {
 
 	// init builders
 	var builder frontend.NewBuilder
@@ -83,71 +141,71 @@ func CompileCircuit(backend string, circuit frontend.Circuit) (constraint.Constr
 		builder = r1cs.NewBuilder
 	case "plonk":
 		builder = scs.NewBuilder
-	case "plonkFRI":
-		builder = scs.NewBuilder
+	default:
+		return nil, CheckBackend(backend)
 	}
 
 	// generate CompiledConstraintSystem
-	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), builder, circuit)
+	ccs, err := frontend.Compile(Curve.ScalarField(), builder, circuit)
 	if err != nil {
 		log.Error().Msg("frontend.Compile")
 		return nil, err
 	}
 
 	// serialize constraint system
-	u.Serialize(ccs, "./local_storage/circuits/oracle_"+backend+".ccs")
+	u.Serialize(ccs, ArtifactPath(name, backend, ".ccs"))
 	// checkSum(ccs, "CCS")
 
 	return ccs, nil
 }
 
-func ComputeSetup(backend string, ccs constraint.ConstraintSystem) error {
-
-	// kzg setup if using plonk
-	var srs kzg.SRS
-	if backend == "plonk" {
-		srs, err := test.NewKZGSRS(ccs)
-		if err != nil {
-			log.Error().Msg("test.NewKZGSRS(ccs)")
-			return err
-		}
-		u.Serialize(srs, "./local_storage/circuits/oracle_"+backend+".srs")
-	}
+func ComputeSetup(backend string, name string, ccs constraint.ConstraintSystem) error {
 
 	// proof system execution
 	switch backend {
 	case "groth16":
 
-		// setup
-		pk, vk, err := groth16.Setup(ccs)
+		// keys of a finalized phase-2 ceremony for this constraint system
+		pk, vk, err := ceremonyKeys(name, ccs)
 		if err != nil {
-			log.Error().Msg("groth16.Setup")
+			log.Error().Err(err).Msg("ceremonyKeys()")
 			return err
 		}
-		u.Serialize(pk, "./local_storage/circuits/oracle_"+backend+".pk")
-		u.Serialize(vk, "./local_storage/circuits/oracle_"+backend+".vk")
+		if pk == nil && RequireCeremony {
+			return fmt.Errorf("no finalized ceremony for circuit %s", name)
+		}
+
+		// setup
+		if pk == nil {
+			pk, vk, err = groth16.Setup(ccs)
+			if err != nil {
+				log.Error().Msg("groth16.Setup")
+				return err
+			}
+		}
+		u.Serialize(pk, ArtifactPath(name, backend, ".pk"))
+		u.Serialize(vk, ArtifactPath(name, backend, ".vk"))
 
 	case "plonk":
 
+		// universal srs sized to the circuit
+		srs, err := circuitSRS(ccs)
+		if err != nil {
+			log.Error().Err(err).Msg("circuitSRS(ccs)")
+			return err
+		}
+
 		// setup
 		pk, vk, err := plonk.Setup(ccs, srs)
 		if err != nil {
 			log.Error().Msg("plonk.Setup")
 			return err
 		}
-		u.Serialize(pk, "./local_storage/circuits/oracle_"+backend+".pk")
-		u.Serialize(vk, "./local_storage/circuits/oracle_"+backend+".vk")
+		u.Serialize(pk, ArtifactPath(name, backend, ".pk"))
+		u.Serialize(vk, ArtifactPath(name, backend, ".vk"))
 
-	case "plonkFRI":
-
-		// setup
-		// pk, vk, err := plonkfri.Setup(ccs)
-		// if err != nil {
-		// 	log.Error().Msg("plonkfri.Setup")
-		// 	return err
-		// }
-		// u.Serialize(pk, "./local_storage/circuits/oracle_"+backend+".pk")
-		// u.Serialize(vk, "./local_storage/circuits/oracle_"+backend+".vk")
+	default:
+		return CheckBackend(backend)
 	}
 	return nil
 }
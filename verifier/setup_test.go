@@ -0,0 +1,177 @@
+package verifier
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+)
+
+// cubicCircuit proves knowledge of x with x**3 + x + 5 == y
+type cubicCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (c *cubicCircuit) Define(api frontend.API) error {
+	x3 := api.Mul(c.X, c.X, c.X)
+	api.AssertIsEqual(c.Y, api.Add(x3, c.X, 5))
+	return nil
+}
+
+// chdirStorage runs the test in an empty directory with the local storage
+// layout of the proxy
+func chdirStorage(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "local_storage", "circuits"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestCheckBackend(t *testing.T) {
+
+	tests := []struct {
+		backend string
+		curve   ecc.ID
+		ok      bool
+	}{
+		{"groth16", ecc.BN254, true},
+		{"groth16", ecc.BLS12_381, true},
+		{"plonk", ecc.BN254, true},
+		{"plonk", ecc.BLS12_381, false},
+		{"plonkFRI", ecc.BN254, false},
+		{"marlin", ecc.BN254, false},
+	}
+
+	defer func(c ecc.ID) { Curve = c }(Curve)
+	for _, tt := range tests {
+		Curve = tt.curve
+		err := CheckBackend(tt.backend)
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckBackend(%s) on %s: %v", tt.backend, tt.curve, err)
+		}
+	}
+}
+
+// circuit names of requests must not leave the storage directory
+func TestCheckCircuit(t *testing.T) {
+
+	for _, name := range []string{OracleCircuit, RequestCircuit} {
+		if err := CheckCircuit(name); err != nil {
+			t.Errorf("CheckCircuit(%s): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "../../etc/passwd", "oracle/../oracle", "/tmp/oracle", "ORACLE"} {
+		if CheckCircuit(name) == nil {
+			t.Errorf("CheckCircuit accepted %q", name)
+		}
+		if _, err := LatestContribution(name); err == nil {
+			t.Errorf("LatestContribution accepted %q", name)
+		}
+	}
+}
+
+// plonkFRI is blocked on gnark and must fail at every stage instead of
+// silently succeeding
+func TestPlonkFRIRejected(t *testing.T) {
+	chdirStorage(t)
+
+	_, err := CompileCircuit("plonkFRI", "cubic", &cubicCircuit{})
+	if !errors.Is(err, ErrUnsupportedBackend) {
+		t.Fatalf("CompileCircuit: %v", err)
+	}
+	err = ComputeSetup("plonkFRI", "cubic", nil)
+	if !errors.Is(err, ErrUnsupportedBackend) {
+		t.Fatalf("ComputeSetup: %v", err)
+	}
+	_, err = VerifyCircuit("plonkFRI", "cubic", nil)
+	if !errors.Is(err, ErrUnsupportedBackend) {
+		t.Fatalf("VerifyCircuit: %v", err)
+	}
+}
+
+// proveCubic runs the groth16 setup of cubicCircuit under the given name,
+// stores a proof of x = 3 as a submitted proof and returns its public witness
+func proveCubic(t *testing.T, name string) witness.Witness {
+	t.Helper()
+
+	ccs, err := CompileCircuit("groth16", name, &cubicCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ComputeSetup("groth16", name, ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// prove with the stored proving key as the prover does
+	pk := groth16.NewProvingKey(Curve)
+	data, err := os.ReadFile(ArtifactPath(name, "groth16", ".pk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pk.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := frontend.NewWitness(&cubicCircuit{X: 3, Y: 35}, Curve.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := groth16.Prove(ccs, pk, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err = proof.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(ArtifactPath(name, "groth16", ".proof"), buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := full.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public
+}
+
+func TestGroth16SetupAndVerify(t *testing.T) {
+	chdirStorage(t)
+
+	public := proveCubic(t, "cubic")
+	_, err := VerifyCircuit("groth16", "cubic", public)
+	if err != nil {
+		t.Fatalf("VerifyCircuit: %v", err)
+	}
+
+	wrong, err := frontend.NewWitness(&cubicCircuit{Y: 36}, Curve.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyCircuit("groth16", "cubic", wrong)
+	if err == nil {
+		t.Fatal("verified a proof against a different public input")
+	}
+}
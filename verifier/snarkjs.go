@@ -0,0 +1,346 @@
+package verifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// snarkjs names bn254 bn128 and encodes field elements as decimal strings,
+// points in projective form with z = 1 and G2 coefficients as [c0, c1]
+const snarkjsCurve = "bn128"
+
+// SnarkJSProof is a groth16 proof as written by snarkjs
+type SnarkJSProof struct {
+	A        []string   `json:"pi_a"`
+	B        [][]string `json:"pi_b"`
+	C        []string   `json:"pi_c"`
+	Protocol string     `json:"protocol"`
+	Curve    string     `json:"curve"`
+}
+
+// SnarkJSVerifyingKey is a groth16 verification key as written by snarkjs
+type SnarkJSVerifyingKey struct {
+	Protocol    string       `json:"protocol"`
+	Curve       string       `json:"curve"`
+	NPublic     int          `json:"nPublic"`
+	Alpha1      []string     `json:"vk_alpha_1"`
+	Beta2       [][]string   `json:"vk_beta_2"`
+	Gamma2      [][]string   `json:"vk_gamma_2"`
+	Delta2      [][]string   `json:"vk_delta_2"`
+	AlphaBeta12 [][][]string `json:"vk_alphabeta_12"`
+	IC          [][]string   `json:"IC"`
+}
+
+func checkSnarkJSCurve(protocol string, curve string) error {
+	if Curve != ecc.BN254 {
+		return fmt.Errorf("snarkjs interchange requires bn254, circuits use %s", Curve)
+	}
+	if protocol != "groth16" || curve != snarkjsCurve {
+		return fmt.Errorf("unsupported snarkjs %s proof on %s", protocol, curve)
+	}
+	return nil
+}
+
+func fpString(e fp.Element) string {
+	var b big.Int
+	return e.BigInt(&b).String()
+}
+
+func g1ToSnarkJS(p bn254.G1Affine) []string {
+	return []string{fpString(p.X), fpString(p.Y), "1"}
+}
+
+func g2ToSnarkJS(p bn254.G2Affine) [][]string {
+	return [][]string{
+		{fpString(p.X.A0), fpString(p.X.A1)},
+		{fpString(p.Y.A0), fpString(p.Y.A1)},
+		{"1", "0"},
+	}
+}
+
+// parses a base field element and rejects unreduced values
+func parseFp(s string) (fp.Element, error) {
+	var e fp.Element
+	b, ok := new(big.Int).SetString(s, 10)
+	if !ok || b.Sign() < 0 || b.Cmp(fp.Modulus()) >= 0 {
+		return e, fmt.Errorf("invalid field element %q", s)
+	}
+	e.SetBigInt(b)
+	return e, nil
+}
+
+func g1FromSnarkJS(s []string) (bn254.G1Affine, error) {
+	var p bn254.G1Affine
+	var err error
+	if len(s) != 3 || s[2] != "1" {
+		return p, errors.New("G1 point is not in affine form")
+	}
+	p.X, err = parseFp(s[0])
+	if err != nil {
+		return p, err
+	}
+	p.Y, err = parseFp(s[1])
+	if err != nil {
+		return p, err
+	}
+	if !p.IsOnCurve() || !p.IsInSubGroup() {
+		return p, errors.New("invalid G1 point")
+	}
+	return p, nil
+}
+
+func g2FromSnarkJS(s [][]string) (bn254.G2Affine, error) {
+	var p bn254.G2Affine
+	if len(s) != 3 || len(s[0]) != 2 || len(s[1]) != 2 || len(s[2]) != 2 || s[2][0] != "1" || s[2][1] != "0" {
+		return p, errors.New("G2 point is not in affine form")
+	}
+	coordinates := []*fp.Element{&p.X.A0, &p.X.A1, &p.Y.A0, &p.Y.A1}
+	for i, c := range []string{s[0][0], s[0][1], s[1][0], s[1][1]} {
+		e, err := parseFp(c)
+		if err != nil {
+			return p, err
+		}
+		*coordinates[i] = e
+	}
+	if !p.IsOnCurve() || !p.IsInSubGroup() {
+		return p, errors.New("invalid G2 point")
+	}
+	return p, nil
+}
+
+// ProofFromSnarkJS decodes a snarkjs proof into the gnark encoding of the
+// proof
+func ProofFromSnarkJS(data []byte) ([]byte, error) {
+
+	var sp SnarkJSProof
+	err := json.Unmarshal(data, &sp)
+	if err != nil {
+		log.Error().Err(err).Msg("json.Unmarshal(data, &sp)")
+		return nil, err
+	}
+	err = checkSnarkJSCurve(sp.Protocol, sp.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	var proof groth16_bn254.Proof
+	proof.Ar, err = g1FromSnarkJS(sp.A)
+	if err != nil {
+		return nil, fmt.Errorf("pi_a: %w", err)
+	}
+	proof.Bs, err = g2FromSnarkJS(sp.B)
+	if err != nil {
+		return nil, fmt.Errorf("pi_b: %w", err)
+	}
+	proof.Krs, err = g1FromSnarkJS(sp.C)
+	if err != nil {
+		return nil, fmt.Errorf("pi_c: %w", err)
+	}
+
+	var buf bytes.Buffer
+	_, err = proof.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ProofToSnarkJS encodes the stored proof of the circuit as snarkjs proof
+func ProofToSnarkJS(name string) ([]byte, error) {
+
+	err := checkSnarkJSCurve("groth16", snarkjsCurve)
+	if err != nil {
+		return nil, err
+	}
+
+	var proof groth16_bn254.Proof
+	err = readProof(&proof, ArtifactPath(name, "groth16", ".proof"))
+	if err != nil {
+		return nil, err
+	}
+	if len(proof.Commitments) > 0 {
+		return nil, errors.New("snarkjs proofs have no commitments")
+	}
+
+	return json.MarshalIndent(SnarkJSProof{
+		A:        g1ToSnarkJS(proof.Ar),
+		B:        g2ToSnarkJS(proof.Bs),
+		C:        g1ToSnarkJS(proof.Krs),
+		Protocol: "groth16",
+		Curve:    snarkjsCurve,
+	}, "", " ")
+}
+
+// PublicSignals returns the public witness as snarkjs public signals
+func PublicSignals(publicWitness witness.Witness) ([]string, error) {
+
+	inputs, ok := publicWitness.Vector().(fr.Vector)
+	if !ok {
+		return nil, witness.ErrInvalidWitness
+	}
+
+	signals := make([]string, len(inputs))
+	for i, e := range inputs {
+		var b big.Int
+		signals[i] = e.BigInt(&b).String()
+	}
+	return signals, nil
+}
+
+// VerifyingKeyToSnarkJS encodes the verifying key of the circuit as snarkjs
+// verification key
+func VerifyingKeyToSnarkJS(name string) ([]byte, error) {
+
+	err := checkSnarkJSCurve("groth16", snarkjsCurve)
+	if err != nil {
+		return nil, err
+	}
+
+	var vk groth16_bn254.VerifyingKey
+	file, err := os.Open(ArtifactPath(name, "groth16", ".vk"))
+	if err != nil {
+		log.Error().Err(err).Msg("os.Open(vk)")
+		return nil, err
+	}
+	defer file.Close()
+	_, err = vk.ReadFrom(file)
+	if err != nil {
+		log.Error().Err(err).Msg("vk.ReadFrom(file)")
+		return nil, err
+	}
+	if len(vk.PublicAndCommitmentCommitted) > 0 {
+		return nil, errors.New("snarkjs verification keys have no commitments")
+	}
+
+	alphaBeta, err := bn254.Pair([]bn254.G1Affine{vk.G1.Alpha}, []bn254.G2Affine{vk.G2.Beta})
+	if err != nil {
+		return nil, err
+	}
+	e2 := func(c [3][2]fp.Element) [][]string {
+		out := make([][]string, 3)
+		for i := range c {
+			out[i] = []string{fpString(c[i][0]), fpString(c[i][1])}
+		}
+		return out
+	}
+	c0 := [3][2]fp.Element{{alphaBeta.C0.B0.A0, alphaBeta.C0.B0.A1}, {alphaBeta.C0.B1.A0, alphaBeta.C0.B1.A1}, {alphaBeta.C0.B2.A0, alphaBeta.C0.B2.A1}}
+	c1 := [3][2]fp.Element{{alphaBeta.C1.B0.A0, alphaBeta.C1.B0.A1}, {alphaBeta.C1.B1.A0, alphaBeta.C1.B1.A1}, {alphaBeta.C1.B2.A0, alphaBeta.C1.B2.A1}}
+
+	svk := SnarkJSVerifyingKey{
+		Protocol:    "groth16",
+		Curve:       snarkjsCurve,
+		NPublic:     len(vk.G1.K) - 1,
+		Alpha1:      g1ToSnarkJS(vk.G1.Alpha),
+		Beta2:       g2ToSnarkJS(vk.G2.Beta),
+		Gamma2:      g2ToSnarkJS(vk.G2.Gamma),
+		Delta2:      g2ToSnarkJS(vk.G2.Delta),
+		AlphaBeta12: [][][]string{e2(c0), e2(c1)},
+	}
+	for _, k := range vk.G1.K {
+		svk.IC = append(svk.IC, g1ToSnarkJS(k))
+	}
+
+	return json.MarshalIndent(svk, "", " ")
+}
+
+// VerifyingKeyFromSnarkJS stores a snarkjs verification key as verifying
+// key of the circuit, e.g. for keys of a ceremony run with snarkjs. the key
+// must match the public inputs of the compiled circuit, the proving key of
+// the proxy setup no longer matches and is removed. imports are refused if
+// keys must stem from a ceremony of the proxy.
+func VerifyingKeyFromSnarkJS(name string, data []byte) error {
+
+	if RequireCeremony {
+		return fmt.Errorf("verifying keys of circuit %s must stem from a ceremony", name)
+	}
+
+	ccsData, err := os.ReadFile(ArtifactPath(name, "groth16", ".ccs"))
+	if err != nil {
+		log.Error().Err(err).Msg("os.ReadFile(ccs)")
+		return err
+	}
+	r1cs, err := readR1CS(ccsData)
+	if err != nil {
+		return err
+	}
+
+	var svk SnarkJSVerifyingKey
+	err = json.Unmarshal(data, &svk)
+	if err != nil {
+		log.Error().Err(err).Msg("json.Unmarshal(data, &svk)")
+		return err
+	}
+	err = checkSnarkJSCurve(svk.Protocol, svk.Curve)
+	if err != nil {
+		return err
+	}
+	if len(svk.IC) != svk.NPublic+1 {
+		return fmt.Errorf("verification key holds %d IC points for %d public inputs", len(svk.IC), svk.NPublic)
+	}
+	// the constraint system counts the constant wire as public variable
+	if svk.NPublic != r1cs.GetNbPublicVariables()-1 {
+		return fmt.Errorf("verification key has %d public inputs, circuit %s has %d", svk.NPublic, name, r1cs.GetNbPublicVariables()-1)
+	}
+
+	var vk groth16_bn254.VerifyingKey
+	vk.G1.Alpha, err = g1FromSnarkJS(svk.Alpha1)
+	if err != nil {
+		return fmt.Errorf("vk_alpha_1: %w", err)
+	}
+	g2 := map[string]struct {
+		s [][]string
+		p *bn254.G2Affine
+	}{
+		"vk_beta_2":  {svk.Beta2, &vk.G2.Beta},
+		"vk_gamma_2": {svk.Gamma2, &vk.G2.Gamma},
+		"vk_delta_2": {svk.Delta2, &vk.G2.Delta},
+	}
+	for field, e := range g2 {
+		*e.p, err = g2FromSnarkJS(e.s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+	}
+	vk.G1.K = make([]bn254.G1Affine, len(svk.IC))
+	for i, s := range svk.IC {
+		vk.G1.K[i], err = g1FromSnarkJS(s)
+		if err != nil {
+			return fmt.Errorf("IC[%d]: %w", i, err)
+		}
+	}
+
+	// e(α, β) and the negated G2 points
+	err = vk.Precompute()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	_, err = vk.WriteTo(&buf)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(ArtifactPath(name, "groth16", ".vk"), buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(ArtifactPath(name, "groth16", ".pk"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// on-chain verification relies on the bn254 precompiles of the evm
+func checkSolidityCurve() error {
+	if Curve != ecc.BN254 {
+		return fmt.Errorf("solidity verifiers require bn254, circuits use %s", Curve)
+	}
+	return nil
+}
+
+func readSolidityVerifyingKey(name string) (*groth16_bn254.VerifyingKey, error) {
+
+	err := checkSolidityCurve()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(ArtifactPath(name, "groth16", ".vk"))
+	if err != nil {
+		log.Error().Err(err).Msg("os.Open(vk)")
+		return nil, err
+	}
+	defer file.Close()
+
+	var vk groth16_bn254.VerifyingKey
+	_, err = vk.ReadFrom(file)
+	if err != nil {
+		log.Error().Err(err).Msg("vk.ReadFrom(file)")
+		return nil, err
+	}
+
+	// the generated contract does not check pedersen commitments
+	if len(vk.PublicAndCommitmentCommitted) > 0 {
+		return nil, errors.New("solidity verifier does not support circuits with commitments")
+	}
+
+	return &vk, nil
+}
+
+// ExportSolidity writes a solidity contract verifying groth16 proofs of the
+// circuit against its current verifying key
+func ExportSolidity(name string, w io.Writer) error {
+
+	vk, err := readSolidityVerifyingKey(name)
+	if err != nil {
+		return err
+	}
+
+	return vk.ExportSolidity(w)
+}
+
+// Calldata returns the abi encoded call of verifyProof(uint256[8],uint256[n])
+// of the exported contract for the stored proof of the circuit
+func Calldata(name string, publicWitness witness.Witness) ([]byte, error) {
+
+	vk, err := readSolidityVerifyingKey(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var proof groth16_bn254.Proof
+	err = readProof(&proof, ArtifactPath(name, "groth16", ".proof"))
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, ok := publicWitness.Vector().(fr.Vector)
+	if !ok {
+		return nil, witness.ErrInvalidWitness
+	}
+	if len(inputs) != len(vk.G1.K)-1 {
+		return nil, fmt.Errorf("verifying key expects %d public inputs, witness holds %d", len(vk.G1.K)-1, len(inputs))
+	}
+
+	return encodeCalldata(&proof, inputs), nil
+}
+
+// encodeCalldata encodes the proof points as in eip-197, the coefficients
+// of G2 points in big-endian order, followed by the public inputs
+func encodeCalldata(proof *groth16_bn254.Proof, inputs fr.Vector) []byte {
+
+	signature := fmt.Sprintf("verifyProof(uint256[8],uint256[%d])", len(inputs))
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(signature))
+	calldata := h.Sum(nil)[:4]
+
+	points := []fp.Element{
+		proof.Ar.X, proof.Ar.Y,
+		proof.Bs.X.A1, proof.Bs.X.A0, proof.Bs.Y.A1, proof.Bs.Y.A0,
+		proof.Krs.X, proof.Krs.Y,
+	}
+	for _, e := range points {
+		b := e.Bytes()
+		calldata = append(calldata, b[:]...)
+	}
+	for _, e := range inputs {
+		b := e.Bytes()
+		calldata = append(calldata, b[:]...)
+	}
+
+	return calldata
+}
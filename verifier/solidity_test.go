@@ -0,0 +1,167 @@
+package verifier
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+)
+
+// size of an abi word and of the verifyProof selector
+const (
+	abiWord     = 32
+	selectorLen = 4
+)
+
+// tamperInput returns the calldata with the first public input incremented
+func tamperInput(calldata []byte) []byte {
+	tampered := append([]byte{}, calldata...)
+	tampered[selectorLen+9*abiWord-1]++
+	return tampered
+}
+
+// precompile runs an evm precompile of the istanbul fork
+func precompile(t *testing.T, addr byte, input []byte) []byte {
+	t.Helper()
+
+	p := vm.PrecompiledContractsIstanbul[common.BytesToAddress([]byte{addr})]
+	out, err := p.Run(input)
+	if err != nil {
+		t.Fatalf("precompile %d: %v", addr, err)
+	}
+	return out
+}
+
+func g1Bytes(p bn254.G1Affine) []byte {
+	x, y := p.X.Bytes(), p.Y.Bytes()
+	return append(x[:], y[:]...)
+}
+
+// g2 coefficients in big-endian order as expected by the pairing precompile
+func g2NegBytes(p bn254.G2Affine) []byte {
+	var neg bn254.G2Affine
+	neg.Neg(&p)
+	var out []byte
+	for _, e := range [][32]byte{neg.X.A1.Bytes(), neg.X.A0.Bytes(), neg.Y.A1.Bytes(), neg.Y.A0.Bytes()} {
+		out = append(out, e[:]...)
+	}
+	return out
+}
+
+// pairingCheck evaluates the pairing equation of the exported contract
+// e(A, B) e(C, -δ) e(α, -β) e(L, -γ) == 1 for calldata of verifyProof with
+// the bn254 precompiles of the evm
+func pairingCheck(t *testing.T, calldata []byte) bool {
+	t.Helper()
+
+	vk, err := readSolidityVerifyingKey("cubic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := calldata[selectorLen : selectorLen+8*abiWord]
+	inputs := calldata[selectorLen+8*abiWord:]
+	if len(inputs) != (len(vk.G1.K)-1)*abiWord {
+		t.Fatalf("calldata holds %d bytes of public inputs", len(inputs))
+	}
+
+	// L = K0 + sum of input_i * K_i+1 with ecMul and ecAdd
+	l := g1Bytes(vk.G1.K[0])
+	for i := 0; i < len(inputs)/abiWord; i++ {
+		term := precompile(t, 7, append(g1Bytes(vk.G1.K[i+1]), inputs[i*abiWord:(i+1)*abiWord]...))
+		l = precompile(t, 6, append(l, term...))
+	}
+
+	var pairings []byte
+	pairings = append(pairings, proof[:6*abiWord]...)
+	pairings = append(pairings, proof[6*abiWord:]...)
+	pairings = append(pairings, g2NegBytes(vk.G2.Delta)...)
+	pairings = append(pairings, g1Bytes(vk.G1.Alpha)...)
+	pairings = append(pairings, g2NegBytes(vk.G2.Beta)...)
+	pairings = append(pairings, l...)
+	pairings = append(pairings, g2NegBytes(vk.G2.Gamma)...)
+
+	out := precompile(t, 8, pairings)
+	return out[abiWord-1] == 1
+}
+
+// the calldata encoding must satisfy the pairing check of the contract as
+// evaluated by the evm precompiles, runs without a solidity compiler
+func TestCalldataPrecompiles(t *testing.T) {
+	chdirStorage(t)
+
+	public := proveCubic(t, "cubic")
+	calldata, err := Calldata("cubic", public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calldata) != selectorLen+9*abiWord {
+		t.Fatalf("calldata length %d", len(calldata))
+	}
+
+	if !pairingCheck(t, calldata) {
+		t.Fatal("pairing check of the calldata failed")
+	}
+	if pairingCheck(t, tamperInput(calldata)) {
+		t.Fatal("pairing check of tampered calldata succeeded")
+	}
+}
+
+// the exported contract accepts the calldata and reverts on a tampered input.
+// requires solc in PATH to compile the contract.
+func TestCalldataSolidityVerifier(t *testing.T) {
+
+	solc, err := exec.LookPath("solc")
+	if err != nil {
+		t.Skip("solc not found")
+	}
+	chdirStorage(t)
+
+	public := proveCubic(t, "cubic")
+	calldata, err := Calldata("cubic", public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// export and compile the verifier contract
+	dir := t.TempDir()
+	var contract bytes.Buffer
+	err = ExportSolidity("cubic", &contract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "verifier.sol"), contract.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(solc, "--optimize", "--bin", "-o", dir, filepath.Join(dir, "verifier.sol")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("solc: %v\n%s", err, out)
+	}
+	bin, err := os.ReadFile(filepath.Join(dir, "Verifier.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deploy and call verifyProof in a local evm
+	cfg := &runtime.Config{}
+	_, address, _, err := runtime.Create(common.FromHex(string(bytes.TrimSpace(bin))), cfg)
+	if err != nil {
+		t.Fatalf("deploy: %v", err)
+	}
+
+	_, _, err = runtime.Call(address, calldata, cfg)
+	if err != nil {
+		t.Fatalf("verifyProof: %v", err)
+	}
+	_, _, err = runtime.Call(address, tamperInput(calldata), cfg)
+	if !errors.Is(err, vm.ErrExecutionReverted) {
+		t.Fatalf("verifyProof of a tampered input: %v, want revert", err)
+	}
+}
@@ -0,0 +1,163 @@
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	kzg_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/fr/kzg"
+	"github.com/consensys/gnark/backend/plonk"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/constraint"
+)
+
+// SRSPath points to the universal kzg srs shared by all plonk circuits. the
+// file holds a gnark-crypto serialized kzg srs, e.g. converted from the
+// output of a powers-of-tau ceremony.
+var SRSPath = "./local_storage/circuits/kzg_bn254.srs"
+
+// the srs is loaded and validated once and reused across circuits
+var (
+	srsMu     sync.Mutex
+	srsLoaded *kzg_bn254.SRS
+	srsFrom   string
+)
+
+// number of powers plonk requires for the constraint system
+func srsSize(ccs constraint.ConstraintSystem) uint64 {
+	sizeSystem := ccs.GetNbConstraints() + ccs.GetNbPublicVariables()
+	return ecc.NextPowerOfTwo(uint64(sizeSystem)) + 3
+}
+
+// returns the universal srs trimmed to the size of the constraint system
+func circuitSRS(ccs constraint.ConstraintSystem) (*kzg_bn254.SRS, error) {
+
+	srs, err := universalSRS()
+	if err != nil {
+		return nil, err
+	}
+
+	size := srsSize(ccs)
+	if uint64(len(srs.Pk.G1)) < size {
+		return nil, fmt.Errorf("kzg srs holds %d powers, circuit requires %d", len(srs.Pk.G1), size)
+	}
+
+	return &kzg_bn254.SRS{
+		Pk: kzg_bn254.ProvingKey{G1: srs.Pk.G1[:size]},
+		Vk: srs.Vk,
+	}, nil
+}
+
+func universalSRS() (*kzg_bn254.SRS, error) {
+
+	if Curve != ecc.BN254 {
+		return nil, fmt.Errorf("universal srs requires bn254, circuits use %s", Curve)
+	}
+
+	srsMu.Lock()
+	defer srsMu.Unlock()
+
+	if srsLoaded != nil && srsFrom == SRSPath {
+		return srsLoaded, nil
+	}
+
+	file, err := os.Open(SRSPath)
+	if err != nil {
+		log.Error().Err(err).Msg("os.Open(SRSPath)")
+		return nil, err
+	}
+	defer file.Close()
+
+	// decoding checks that all points are on the curve and in the subgroup
+	var srs kzg_bn254.SRS
+	_, err = srs.ReadFrom(file)
+	if err != nil {
+		log.Error().Err(err).Msg("srs.ReadFrom(file)")
+		return nil, err
+	}
+
+	err = validateSRS(&srs)
+	if err != nil {
+		log.Error().Err(err).Msg("validateSRS()")
+		return nil, err
+	}
+
+	srsLoaded = &srs
+	srsFrom = SRSPath
+
+	return srsLoaded, nil
+}
+
+// validateSRS checks that the srs consists of successive powers
+// [1]G1, [α]G1, [α²]G1, ... matching [1]G2, [α]G2 of the verifying key
+func validateSRS(srs *kzg_bn254.SRS) error {
+
+	g1 := srs.Pk.G1
+	if len(g1) < 2 {
+		return errors.New("kzg srs requires at least two powers")
+	}
+
+	_, _, gen1, gen2 := bn254.Generators()
+	if !g1[0].Equal(&gen1) || !srs.Vk.G1.Equal(&gen1) || !srs.Vk.G2[0].Equal(&gen2) {
+		return errors.New("kzg srs does not start with the curve generators")
+	}
+	if g1[1].IsInfinity() || g1[1].Equal(&gen1) || srs.Vk.G2[1].IsInfinity() || srs.Vk.G2[1].Equal(&gen2) {
+		return errors.New("kzg srs has a trivial secret")
+	}
+
+	// with random r, e(Σ rᵢ[αⁱ⁺¹]G1, G2) = e(Σ rᵢ[αⁱ]G1, [α]G2) holds for all
+	// powers at once except with negligible probability
+	r := make([]fr.Element, len(g1)-1)
+	for i := range r {
+		_, err := r[i].SetRandom()
+		if err != nil {
+			return err
+		}
+	}
+	config := ecc.MultiExpConfig{}
+	var lo, hi bn254.G1Affine
+	_, err := lo.MultiExp(g1[:len(g1)-1], r, config)
+	if err != nil {
+		return err
+	}
+	_, err = hi.MultiExp(g1[1:], r, config)
+	if err != nil {
+		return err
+	}
+	lo.Neg(&lo)
+
+	ok, err := bn254.PairingCheck([]bn254.G1Affine{hi, lo}, []bn254.G2Affine{srs.Vk.G2[0], srs.Vk.G2[1]})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("kzg srs powers are inconsistent")
+	}
+
+	return nil
+}
+
+// checks that the plonk verifying key commits to the universal srs
+func checkVerifyingKeySRS(vk plonk.VerifyingKey) error {
+
+	srs, err := universalSRS()
+	if err != nil {
+		return err
+	}
+
+	_vk, ok := vk.(*plonk_bn254.VerifyingKey)
+	if !ok {
+		return errors.New("unexpected plonk verifying key type")
+	}
+	if !_vk.Kzg.G1.Equal(&srs.Vk.G1) || !_vk.Kzg.G2[0].Equal(&srs.Vk.G2[0]) || !_vk.Kzg.G2[1].Equal(&srs.Vk.G2[1]) {
+		return errors.New("plonk verifying key was not derived from the universal srs")
+	}
+
+	return nil
+}
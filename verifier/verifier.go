@@ -2,6 +2,8 @@ package verifier
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -10,17 +12,62 @@ import (
 
 	"github.com/rs/zerolog/log"
 
-	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/backend/plonk"
 	"github.com/consensys/gnark/backend/witness"
 	"github.com/consensys/gnark/frontend"
 )
 
+// record direction specific parameter sources
+type recordSide struct {
+	recordDataPath      string
+	recordConfirmedPath string
+	// kdc parameter names of the traffic direction
+	atsIn string
+	tkIn  string
+	iv    string
+	// !!! policy value !!!
+	threshold int
+}
+
+// ResponseThreshold is the threshold public input of the oracle circuit
+// over the server response
+// !!! policy value !!!
+const ResponseThreshold = 38001
+
+var serverSide = recordSide{
+	recordDataPath:      "./local_storage/recorddata_public_input.json",
+	recordConfirmedPath: "./local_storage/record_confirmed.json",
+	atsIn:               "SATSin",
+	tkIn:                "tkSappIn",
+	iv:                  "ivSapp",
+	threshold:           ResponseThreshold,
+}
+
+// the request is bound by its substring only, the threshold is neutral
+var clientSide = recordSide{
+	recordDataPath:      "./local_storage/recorddata_client_public_input.json",
+	recordConfirmedPath: "./local_storage/record_client_confirmed.json",
+	atsIn:               "CATSin",
+	tkIn:                "tkCappIn",
+	iv:                  "ivCapp",
+	threshold:           0,
+}
+
+// computes the public witness of the oracle circuit over the server response
 func ComputeWitness() (witness.Witness, error) {
+	return computeWitness(serverSide)
+}
+
+// computes the public witness of the oracle circuit over the client request
+func ComputeRequestWitness() (witness.Witness, error) {
+	return computeWitness(clientSide)
+}
+
+func computeWitness(side recordSide) (witness.Witness, error) {
 
 	// read in data
-	params, err := readOracleParams()
+	params, err := readOracleParams(side)
 	if err != nil {
 		log.Error().Msg("readOracleParams()")
 		return nil, err
@@ -28,23 +75,22 @@ func ComputeWitness() (witness.Witness, error) {
 
 	// further preprocessing
 	zeros := "00000000000000000000000000000000"
-	ivCounter := addCounter(params["ivSapp"])
+	ivCounter := addCounter(params[side.iv])
 	chunkIndex, _ := strconv.Atoi(params["chunk_index"])
 	substringStart, _ := strconv.Atoi(params["substring_start"])
 	substringEnd, _ := strconv.Atoi(params["substring_end"])
 	valueStart, _ := strconv.Atoi(params["value_start"])
 	valueEnd, _ := strconv.Atoi(params["value_end"])
-	// !!! policy value !!!
-	threshold := 38001
+	threshold := side.threshold
 
 	// kdc to bytes
 	byteSlice, _ := hex.DecodeString(params["intermediateHashHSopad"])
 	intermediateHashHSopadByteLen := len(byteSlice)
 	byteSlice, _ = hex.DecodeString(params["MSin"])
 	MSinByteLen := len(byteSlice)
-	byteSlice, _ = hex.DecodeString(params["SATSin"])
+	byteSlice, _ = hex.DecodeString(params[side.atsIn])
 	SATSinByteLen := len(byteSlice)
-	byteSlice, _ = hex.DecodeString(params["tkSappIn"])
+	byteSlice, _ = hex.DecodeString(params[side.tkIn])
 	tkSAPPinByteLen := len(byteSlice)
 	// authtag to bytes
 	byteSlice, _ = hex.DecodeString(ivCounter)
@@ -56,7 +102,7 @@ func ComputeWitness() (witness.Witness, error) {
 	byteSlice, _ = hex.DecodeString(params["ecbk"])
 	ecbkByteLen := len(byteSlice)
 	// record to bytes
-	byteSlice, _ = hex.DecodeString(params["ivSapp"])
+	byteSlice, _ = hex.DecodeString(params[side.iv])
 	ivByteLen := len(byteSlice)
 	byteSlice, _ = hex.DecodeString(params["cipher_chunks"])
 	chipherChunksByteLen := len(byteSlice)
@@ -65,15 +111,15 @@ func ComputeWitness() (witness.Witness, error) {
 	// witness definition kdc
 	intermediateHashHSopadAssign := u.StrToIntSlice(params["intermediateHashHSopad"], true)
 	MSinAssign := u.StrToIntSlice(params["MSin"], true)
-	SATSinAssign := u.StrToIntSlice(params["SATSin"], true)
-	tkSAPPinAssign := u.StrToIntSlice(params["tkSappIn"], true)
+	SATSinAssign := u.StrToIntSlice(params[side.atsIn], true)
+	tkSAPPinAssign := u.StrToIntSlice(params[side.tkIn], true)
 	// witness definition authtag
 	ivCounterAssign := u.StrToIntSlice(ivCounter, true)
 	zerosAssign := u.StrToIntSlice(zeros, true)
 	ecb0Assign := u.StrToIntSlice(params["ecb0"], true)
 	ecbkAssign := u.StrToIntSlice(params["ecbk"], true)
 	// witness definition record
-	ivAssign := u.StrToIntSlice(params["ivSapp"], true)
+	ivAssign := u.StrToIntSlice(params[side.iv], true)
 	chipherChunksAssign := u.StrToIntSlice(params["cipher_chunks"], true)
 	substringAssign := u.StrToIntSlice(params["substring"], false)
 
@@ -160,7 +206,7 @@ func ComputeWitness() (witness.Witness, error) {
 	// fmt.Println("ivAssign:", ivAssign)
 
 	// get witness
-	witnessPublic, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	witnessPublic, err := frontend.NewWitness(&assignment, Curve.ScalarField(), frontend.PublicOnly())
 	if err != nil {
 		log.Error().Err(err).Msg("frontend.NewWitness")
 		return nil, err
@@ -193,60 +239,83 @@ func ComputeWitness() (witness.Witness, error) {
 	return witnessPublic, nil
 }
 
-func readOracleParams() (map[string]string, error) {
+// readOracleParams collects the public inputs of the oracle circuit, each
+// input is taken from its source as listed in KdcInputs and RecordInputs
+func readOracleParams(side recordSide) (map[string]string, error) {
 
-	// to be returned
-	finalMap := make(map[string]string)
+	// kdc and authtag params
+	finalMap, err := readKdcParams(side)
+	if err != nil {
+		log.Error().Err(err).Msg("readKdcParams()")
+		return nil, err
+	}
 
-	// read in kdc publ params from client
-	kdc_client_pub, err := u.ReadM("./local_storage/kdc_public_input.json")
+	// read in record publ params
+	record_pub, err := u.ReadM(side.recordDataPath)
 	if err != nil {
 		log.Error().Msg("u.ReadM")
 		return nil, err
 	}
 
-	// copy
-	for k, v := range kdc_client_pub {
-		finalMap[k] = v
+	// copy client declared record params only
+	for _, in := range RecordInputs {
+		if in.Source == ClientDeclared {
+			finalMap[in.Name] = record_pub[in.ClientName]
+		}
 	}
 
-	// read in kdc pub params
-	kdc_confirmed, err := u.ReadM("./local_storage/kdc_confirmed.json")
+	// cipher chunks are taken from the confirmed record
+	finalMap["cipher_chunks"], err = deriveCipherChunks(side, record_pub)
 	if err != nil {
-		log.Error().Msg("u.ReadM")
+		log.Error().Err(err).Msg("deriveCipherChunks()")
 		return nil, err
 	}
 
-	// copy
-	for k, v := range kdc_confirmed {
-		finalMap[k] = v
-	}
+	return finalMap, nil
+}
+
+// deriveCipherChunks returns the ciphertext chunks at chunk_index of the tag
+// verified record. chunk_index is the gcm counter of the first chunk, the
+// counter of the first record block is 2. cipher_chunks declared by the
+// client must match the derived chunks.
+func deriveCipherChunks(side recordSide, params map[string]string) (string, error) {
 
-	// read in authtag params
-	tag_pub, err := u.ReadMM("./local_storage/record_confirmed.json")
+	confirmed, err := u.ReadConfirmedRecord(side.recordConfirmedPath)
 	if err != nil {
-		log.Error().Msg("u.ReadMM")
-		return nil, err
+		log.Error().Msg("u.ReadConfirmedRecord")
+		return "", err
 	}
 
-	// copy
-	for k, v := range tag_pub {
-		finalMap[k] = v
+	chunkIndex, err := strconv.Atoi(params["chunk_index"])
+	if err != nil || chunkIndex < 2 {
+		return "", errors.New("invalid chunk_index")
 	}
 
-	// read in record publ params
-	record_pub, err := u.ReadM("./local_storage/recorddata_public_input.json")
-	if err != nil {
-		log.Error().Msg("u.ReadM")
-		return nil, err
+	// number of chunks, as declared or implied by the declared chunks
+	numberChunks := (len(params["cipher_chunks"]) + 31) / 32
+	if s, ok := params["number_chunks"]; ok {
+		numberChunks, err = strconv.Atoi(s)
+		if err != nil {
+			return "", errors.New("invalid number_chunks")
+		}
+	}
+	if numberChunks < 1 {
+		return "", errors.New("no cipher chunks")
 	}
 
-	// copy
-	for k, v := range record_pub {
-		finalMap[k] = v
+	start := (chunkIndex - 2) * 16
+	end := start + numberChunks*16
+	if end > len(confirmed.CipherChunks) {
+		return "", fmt.Errorf("chunks %d to %d exceed the confirmed record", chunkIndex, chunkIndex+numberChunks-1)
 	}
+	derived := hex.EncodeToString(confirmed.CipherChunks[start:end])
 
-	return finalMap, nil
+	declared, ok := params["cipher_chunks"]
+	if ok && !strings.EqualFold(declared, derived) {
+		return "", errors.New("declared cipher_chunks do not match the confirmed record")
+	}
+
+	return derived, nil
 }
 
 func addCounter(iv string) string {
@@ -263,35 +332,55 @@ func addCounter(iv string) string {
 	return sb.String()
 }
 
-func VerifyCircuit(backend string, publicWitness witness.Witness) error {
+// VerifyCircuit verifies the submitted proof of the circuit against its
+// current verifying key and returns the hash the key is archived under
+func VerifyCircuit(backend string, name string, publicWitness witness.Witness) (string, error) {
 
 	switch backend {
 	case "groth16":
 
 		// read R1CS, proving key and verifying keys
-		proof := groth16.NewProof(ecc.BN254)
-		vk := groth16.NewVerifyingKey(ecc.BN254)
-		u.Deserialize(proof, "./local_storage/circuits/oracle_"+backend+".proof")
-		u.Deserialize(vk, "./local_storage/circuits/oracle_"+backend+".vk")
-
-		err := groth16.Verify(proof, vk, publicWitness)
-		return err
+		proof := groth16.NewProof(Curve)
+		vk := groth16.NewVerifyingKey(Curve)
+		err := readProof(proof, ArtifactPath(name, backend, ".proof"))
+		if err != nil {
+			log.Error().Err(err).Msg("readProof()")
+			return "", err
+		}
+		vkHash, err := readVerifyingKey(vk, name, backend)
+		if err != nil {
+			return "", err
+		}
+
+		err = groth16.Verify(proof, vk, publicWitness)
+		return vkHash, err
 
 	case "plonk":
 
 		// read constraint system, proving key and verifying keys
-		proof := plonk.NewProof(ecc.BN254)
-		vk := plonk.NewVerifyingKey(ecc.BN254)
-
-		u.Deserialize(proof, "./local_storage/circuits/oracle_"+backend+".proof")
-		u.Deserialize(vk, "./local_storage/circuits/oracle_"+backend+".vk")
-
-		err := plonk.Verify(proof, vk, publicWitness)
-		return err
-
-	case "plonkFRI":
-
+		proof := plonk.NewProof(Curve)
+		vk := plonk.NewVerifyingKey(Curve)
+
+		err := readProof(proof, ArtifactPath(name, backend, ".proof"))
+		if err != nil {
+			log.Error().Err(err).Msg("readProof()")
+			return "", err
+		}
+		vkHash, err := readVerifyingKey(vk, name, backend)
+		if err != nil {
+			return "", err
+		}
+
+		// the srs part of the key must not come from another setup
+		err = checkVerifyingKeySRS(vk)
+		if err != nil {
+			log.Error().Err(err).Msg("checkVerifyingKeySRS(vk)")
+			return "", err
+		}
+
+		err = plonk.Verify(proof, vk, publicWitness)
+		return vkHash, err
 	}
 
-	return nil
+	return "", CheckBackend(backend)
 }